@@ -0,0 +1,326 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/config"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/log"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/router"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Hook starts and stops a subsystem with the App, e.g. a
+// webhook.Dispatcher. Either of the funcs can be nil.
+type Hook struct {
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+}
+
+// App is a crud service built from a config.BaseConfig.
+type App struct {
+	Config any                // the config model given to New
+	Base   *config.BaseConfig // the BaseConfig in Config
+	DB     *gorm.DB           // orm.DB
+	Router *gin.Engine
+
+	configSources   []config.Option
+	models          []any
+	routerOptions   []router.RouterOption
+	routes          []func(r *gin.Engine)
+	shutdownTimeout time.Duration
+
+	mu       sync.Mutex
+	hooks    []Hook
+	listener net.Listener
+}
+
+// Option is an option to construct the App.
+type Option func(a *App)
+
+// WithConfigSources reads the config from the sources (config.FromFile,
+// config.FromEnv, ...), see config.Init. Without sources, the config model
+// is used as it is.
+func WithConfigSources(sources ...config.Option) Option {
+	return func(a *App) {
+		a.configSources = append(a.configSources, sources...)
+	}
+}
+
+// WithModels registers the models (orm.RegisterModel).
+func WithModels(models ...any) Option {
+	return func(a *App) {
+		a.models = append(a.models, models...)
+	}
+}
+
+// WithRouterOptions constructs the router with the options
+// (router.NewRouter).
+func WithRouterOptions(options ...router.RouterOption) Option {
+	return func(a *App) {
+		a.routerOptions = append(a.routerOptions, options...)
+	}
+}
+
+// WithRoutes adds routes to the router, e.g. by router.Crud.
+func WithRoutes(routes func(r *gin.Engine)) Option {
+	return func(a *App) {
+		a.routes = append(a.routes, routes)
+	}
+}
+
+// WithShutdownTimeout sets the time to drain the in-flight requests on
+// shutdown, default 30s. The OnStop hooks are given the same time after.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(a *App) {
+		a.shutdownTimeout = timeout
+	}
+}
+
+// New builds the App from the configModel, a pointer to a
+// config.BaseConfig or to a struct embedding it:
+//
+//  1. config.Init(configModel, sources...)
+//  2. sets the log level to BaseConfig.LogLevel
+//  3. orm.ConnectDB(BaseConfig.DB.Driver, BaseConfig.DB.DSN)
+//  4. orm.RegisterModel(models...)
+//  5. router.NewRouter(routerOptions...), and adds the routes
+//
+// The database is closed if it fails after connected.
+func New(configModel any, options ...Option) (*App, error) {
+	a := &App{
+		Config:          configModel,
+		shutdownTimeout: 30 * time.Second,
+	}
+	for _, option := range options {
+		option(a)
+	}
+
+	if err := config.Init(configModel, a.configSources...); err != nil {
+		return nil, err
+	}
+	base, ok := baseConfigOf(configModel)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNoBaseConfig, configModel)
+	}
+	a.Base = base
+
+	if base.LogLevel != "" {
+		// the Logger is shared by the zone loggers of the packages,
+		// so it's configured in place, instead of log.NewLogger.
+		log.UseLogger(log.Logger, log.WithLevel(log.Level(base.LogLevel)))
+	}
+
+	if err := a.connectDB(); err != nil {
+		return nil, err
+	}
+
+	a.Router = router.NewRouter(a.routerOptions...)
+	for _, routes := range a.routes {
+		routes(a.Router)
+	}
+	return a, nil
+}
+
+// connectDB connects to the DB of the BaseConfig, and registers the
+// models.
+func (a *App) connectDB() error {
+	driver := orm.DBDriver(a.Base.DB.Driver)
+	switch driver {
+	case orm.DBDriverSqlite, orm.DBDriverMySQL, orm.DBDriverPostgres:
+	default: // orm.ConnectDB exits on unknown drivers
+		return fmt.Errorf("%w: %q", ErrUnknownDriver, a.Base.DB.Driver)
+	}
+
+	db, err := orm.ConnectDB(driver, a.Base.DB.DSN)
+	if err != nil {
+		logger.WithError(err).WithField("driver", driver).
+			Error("New: ConnectDB failed")
+		return err
+	}
+	a.DB = db
+	if len(a.models) > 0 {
+		if err := orm.RegisterModel(a.models...); err != nil {
+			_ = a.closeDB()
+			return err
+		}
+	}
+	return nil
+}
+
+// AddHook adds a hook to Run. The OnStarts are run in the order of adding
+// before serving: if one of them fails, the hooks started are stopped, and
+// Run returns the error. The OnStops are run in the reverse order on
+// shutdown, after the in-flight requests are drained and before the
+// database is closed.
+func (a *App) AddHook(hook Hook) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.hooks = append(a.hooks, hook)
+}
+
+// Addr returns the address the App listens on, or nil if it's not
+// running. It's useful with the port 0 (e.g. ":0") in tests.
+func (a *App) Addr() net.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.listener == nil {
+		return nil
+	}
+	return a.listener.Addr()
+}
+
+// Run listens on BaseConfig.HTTP.Addr (default ":8080"), runs the OnStart
+// hooks, and serves the Router until SIGINT, SIGTERM, or ctx is done (the
+// ctx given to the OnStarts is done then as well). Then it shuts down
+// gracefully:
+//
+//  1. stops accepting requests, ends the streams (/stream) and closes the
+//     WebSocket sessions (/ws), see controller.Shutdown, and waits for
+//     the in-flight requests and the sessions to finish, at most the
+//     shutdown timeout (see WithShutdownTimeout);
+//  2. runs the OnStop hooks;
+//  3. closes the database.
+//
+// It returns nil after a graceful shutdown, or the error of serving, the
+// hooks or the shutdown.
+func (a *App) Run(ctx context.Context) error {
+	addr := a.Base.HTTP.Addr
+	if addr == "" {
+		addr = ":8080"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		_ = a.closeDB()
+		return err
+	}
+	a.mu.Lock()
+	a.listener = listener
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.listener = nil
+		a.mu.Unlock()
+	}()
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	a.mu.Lock()
+	hooks := a.hooks
+	a.mu.Unlock()
+	for i, hook := range hooks {
+		if hook.OnStart == nil {
+			continue
+		}
+		if err := hook.OnStart(ctx); err != nil {
+			logger.WithError(err).Error("Run: start hook failed")
+			_ = listener.Close()
+			return errors.Join(err, a.stop(hooks[:i]))
+		}
+	}
+
+	shutdown := controller.NewShutdown()
+	server := &http.Server{
+		Handler: a.Router,
+		BaseContext: func(net.Listener) context.Context {
+			return shutdown.Context(context.Background())
+		},
+	}
+	server.RegisterOnShutdown(shutdown.Start) // ends /stream and /ws
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	logger.WithField("addr", listener.Addr().String()).Info("Run: serving")
+
+	select {
+	case err := <-served: // failed to serve
+		logger.WithError(err).Error("Run: serve failed")
+		return errors.Join(err, a.stop(hooks))
+	case <-ctx.Done():
+		stop() // a second signal kills the process
+	}
+
+	logger.WithField("timeout", a.shutdownTimeout).
+		Info("Run: shutting down, draining requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err == nil {
+		// the WebSocket sessions are hijacked, not waited by Shutdown
+		err = shutdown.Wait(shutdownCtx)
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Run: drain requests failed")
+	}
+	return errors.Join(err, a.stop(hooks))
+}
+
+// stop runs the OnStops of the hooks in reverse order, and closes the
+// database.
+func (a *App) stop(hooks []Hook) error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.shutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if hooks[i].OnStop == nil {
+			continue
+		}
+		if err := hooks[i].OnStop(ctx); err != nil {
+			logger.WithError(err).Warn("Run: stop hook failed")
+			errs = append(errs, err)
+		}
+	}
+	errs = append(errs, a.closeDB())
+	logger.Info("Run: stopped")
+	return errors.Join(errs...)
+}
+
+// closeDB closes the database.
+func (a *App) closeDB() error {
+	if a.DB == nil {
+		return nil
+	}
+	sqlDB, err := a.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// baseConfigOf finds the BaseConfig in configModel: a *BaseConfig, or a
+// pointer to a struct with a BaseConfig field (embedded or not).
+func baseConfigOf(configModel any) (*config.BaseConfig, bool) {
+	if base, ok := configModel.(*config.BaseConfig); ok {
+		return base, base != nil
+	}
+	v := reflect.ValueOf(configModel)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, false
+	}
+	v = v.Elem()
+	baseType := reflect.TypeOf(config.BaseConfig{})
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Type == baseType && v.Field(i).CanAddr() {
+			return v.Field(i).Addr().Interface().(*config.BaseConfig), true
+		}
+	}
+	return nil, false
+}
+
+var (
+	ErrNoBaseConfig  = errors.New("no config.BaseConfig in the config")
+	ErrUnknownDriver = errors.New("unknown database driver")
+)
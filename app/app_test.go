@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/tqrj/cd/changelog"
+	"github.com/tqrj/cd/config"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/router"
+)
+
+type testConfig struct {
+	config.BaseConfig `mapstructure:",squash"`
+	Name              string
+}
+
+type widget struct {
+	orm.BasicModel
+	Name string
+}
+
+func newTestApp(t *testing.T, dsn string, options ...Option) *App {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := testConfig{BaseConfig: config.BaseConfig{
+		DB:       config.DBConfig{Driver: orm.DBDriverSqlite, DSN: dsn},
+		HTTP:     config.HTTPConfig{Addr: "127.0.0.1:0"},
+		LogLevel: "warn",
+	}}
+	a, err := New(&cfg, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestNew(t *testing.T) {
+	a := newTestApp(t, "file:app_new?mode=memory&cache=shared",
+		WithModels(&widget{}),
+		WithRoutes(func(r *gin.Engine) {
+			router.Crud[widget](r, "/widgets", router.DefaultCrudOption())
+		}))
+	defer a.closeDB()
+
+	if a.Base.DB.Driver != orm.DBDriverSqlite || a.DB != orm.DB {
+		t.Errorf("Base = %v, DB = %v", a.Base, a.DB)
+	}
+	w := httptest.NewRecorder()
+	a.Router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/widgets", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /widgets = %d %s", w.Code, w.Body)
+	}
+
+	if _, err := New(&testConfig{}); !errors.Is(err, ErrUnknownDriver) {
+		t.Errorf("New without driver: err = %v", err)
+	}
+	if _, err := New(&struct{ Name string }{}); !errors.Is(err, ErrNoBaseConfig) {
+		t.Errorf("New without BaseConfig: err = %v", err)
+	}
+	base := &config.BaseConfig{DB: config.DBConfig{Driver: orm.DBDriverSqlite, DSN: "file:app_base?mode=memory&cache=shared"}}
+	if a, err := New(base); err != nil || a.Base != base {
+		t.Errorf("New(*BaseConfig) = %v, %v", a, err)
+	} else {
+		_ = a.closeDB()
+	}
+}
+
+func TestRunGracefulShutdown(t *testing.T) {
+	entered := make(chan struct{})
+	a := newTestApp(t, "file:app_run?mode=memory&cache=shared",
+		WithShutdownTimeout(2*time.Second),
+		WithRoutes(func(r *gin.Engine) {
+			r.GET("/slow", func(c *gin.Context) {
+				close(entered)
+				time.Sleep(200 * time.Millisecond)
+				c.String(http.StatusOK, "done")
+			})
+		}))
+	var events []string
+	a.AddHook(Hook{
+		OnStart: func(ctx context.Context) error { events = append(events, "start"); return nil },
+		OnStop:  func(ctx context.Context) error { events = append(events, "stop"); return nil },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error, 1)
+	go func() { ran <- a.Run(ctx) }()
+
+	addr := waitAddr(t, a)
+	responded := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			t.Error(err)
+			responded <- 0
+			return
+		}
+		resp.Body.Close()
+		responded <- resp.StatusCode
+	}()
+	<-entered
+	cancel() // shut down with a request in flight
+
+	if err := <-ran; err != nil {
+		t.Errorf("Run = %v", err)
+	}
+	if code := <-responded; code != http.StatusOK {
+		t.Errorf("in-flight request = %d, want drained with 200", code)
+	}
+	if len(events) != 2 || events[0] != "start" || events[1] != "stop" {
+		t.Errorf("hooks = %v", events)
+	}
+	sqlDB, _ := a.DB.DB()
+	if err := sqlDB.Ping(); err == nil {
+		t.Error("DB is not closed")
+	}
+}
+
+func TestRunStartHookFailed(t *testing.T) {
+	a := newTestApp(t, "file:app_hook?mode=memory&cache=shared")
+	var stopped []string
+	failed := errors.New("failed")
+	a.AddHook(Hook{
+		OnStart: func(ctx context.Context) error { return nil },
+		OnStop:  func(ctx context.Context) error { stopped = append(stopped, "first"); return nil },
+	})
+	a.AddHook(Hook{
+		OnStart: func(ctx context.Context) error { return failed },
+		OnStop:  func(ctx context.Context) error { stopped = append(stopped, "second"); return nil },
+	})
+
+	if err := a.Run(context.Background()); !errors.Is(err, failed) {
+		t.Errorf("Run = %v, want %v", err, failed)
+	}
+	if len(stopped) != 1 || stopped[0] != "first" {
+		t.Errorf("stopped = %v, want only the started one", stopped)
+	}
+}
+
+// waitAddr waits for the App to listen, and returns its address.
+func TestRunShutdownStreams(t *testing.T) {
+	a := newTestApp(t, "file:app_streams?mode=memory&cache=shared",
+		WithShutdownTimeout(2*time.Second),
+		WithModels(&widget{}),
+		WithRoutes(func(r *gin.Engine) {
+			opt := router.DefaultCrudOption()
+			opt.StreamOption.Enable = true
+			opt.WebSocketOption.Enable = true
+			router.Crud[widget](r, "/widgets", opt)
+		}))
+	if err := changelog.Enable(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error, 1)
+	go func() { ran <- a.Run(ctx) }()
+	addr := waitAddr(t, a)
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	stream, err := client.Get("http://" + addr + "/widgets/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/widgets/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	start := time.Now()
+	cancel() // shut down with a stream and a session open
+	if err := <-ran; err != nil || time.Since(start) > time.Second {
+		t.Errorf("Run = %v after %v, want the stream and the session ended", err, time.Since(start))
+	}
+	if _, err := io.ReadAll(stream.Body); err != nil {
+		t.Errorf("stream is not ended: %v", err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("session is not closed going away: %v", err)
+	}
+}
+
+func waitAddr(t *testing.T, a *App) string {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if addr := a.Addr(); addr != nil {
+			return addr.String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("App is not listening")
+	return ""
+}
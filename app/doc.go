@@ -0,0 +1,33 @@
+// Package app wires a crud service from a config.BaseConfig: the config,
+// the logger, the database, the router and an HTTP server with graceful
+// shutdown.
+//
+//	type Config struct {
+//	    config.BaseConfig `mapstructure:",squash"`
+//	    Foo               string
+//	}
+//
+//	var cfg Config
+//	a, err := app.New(&cfg,
+//	    app.WithConfigSources(config.FromFile("config.yaml"), config.FromEnv("MYAPP")),
+//	    app.WithModels(&Todo{}),
+//	    app.WithRouterOptions(router.WithHealth()),
+//	    app.WithRoutes(func(r *gin.Engine) {
+//	        router.Crud[Todo](r, "/todos", router.DefaultCrudOption())
+//	    }))
+//	if err != nil { ... }
+//	dispatcher := webhook.NewDispatcher()
+//	a.AddHook(app.Hook{ // start and stop the subsystems with the app
+//	    OnStart: func(ctx context.Context) error { dispatcher.Start(); return nil },
+//	    OnStop:  func(ctx context.Context) error { dispatcher.Stop(); return nil },
+//	})
+//	err = a.Run(context.Background())
+//
+// Run serves until SIGINT / SIGTERM (or the ctx is done), then ends the
+// streams and WebSocket sessions, drains the in-flight requests within the
+// shutdown timeout, runs the OnStop hooks and closes the database.
+package app
+
+import "github.com/tqrj/cd/log"
+
+var logger = log.ZoneLogger("crud/app")
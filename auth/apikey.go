@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// APIKey is a key for machine-to-machine clients. Only the hash of the
+// secret is stored: the plaintext key is returned once, by CreateAPIKey
+// and RotateAPIKey.
+type APIKey struct {
+	orm.BasicModel
+	Name  string `json:"name" binding:"required"`
+	KeyID string `json:"keyId" gorm:"size:32;uniqueIndex"` // the public part of the key
+	Hash  string `json:"-" gorm:"size:64"`                 // sha256 of the secret part
+
+	// The Principal of the key. Subject defaults to "apikey:<KeyID>",
+	// Roles is space separated.
+	Subject  string `json:"subject"`
+	Roles    string `json:"roles"`
+	TenantID string `json:"tenantId"`
+
+	// Scopes is a comma separated list of the operations allowed, as
+	// "Model:operation": "Todo:list", "Todo:*", "*:get". Operations are
+	// list, get, create, update, delete, and read (list and get) or write
+	// (create, update and delete). A key without scopes can do nothing.
+	Scopes string `json:"scopes"`
+
+	ExpiresAt  *time.Time `json:"expiresAt"` // nil for never
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+// Allows reports whether the scopes of the key allow the operation on
+// the model (type name).
+func (k *APIKey) Allows(model string, operation enum.Operation) bool {
+	for _, scope := range strings.Split(k.Scopes, ",") {
+		m, op, ok := strings.Cut(strings.TrimSpace(scope), ":")
+		if !ok || (m != "*" && m != model) {
+			continue
+		}
+		switch op {
+		case "*", string(operation):
+			return true
+		case "read":
+			if operation == enum.OpList || operation == enum.OpGet {
+				return true
+			}
+		case "write":
+			if operation == enum.OpCreate || operation == enum.OpUpdate || operation == enum.OpDelete {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Principal returns the Principal authenticated by the key.
+// The KeyID and Scopes are in its Claims as "api_key" and "scopes".
+func (k *APIKey) Principal() *Principal {
+	subject := k.Subject
+	if subject == "" {
+		subject = "apikey:" + k.KeyID
+	}
+	return &Principal{
+		Subject:  subject,
+		Roles:    strings.Fields(k.Roles),
+		TenantID: k.TenantID,
+		Claims: map[string]any{
+			"sub":     subject,
+			"api_key": k.KeyID,
+			"scopes":  k.Scopes,
+		},
+	}
+}
+
+// EnableAPIKeys migrates the table of API keys. It should be called after
+// orm.ConnectDB, before using the API keys.
+func EnableAPIKeys() error {
+	return orm.RegisterModel(&APIKey{})
+}
+
+// apiKeyLastUsedInterval throttles the writes of APIKey.LastUsedAt.
+const apiKeyLastUsedInterval = time.Minute
+
+// CreateAPIKey generates the key, and stores it with its hash.
+// The plaintext key ("<KeyID>.<secret>") is returned, which can not be
+// recovered later.
+func CreateAPIKey(ctx context.Context, key *APIKey) (string, error) {
+	keyID, err := randomString(9)
+	if err != nil {
+		return "", err
+	}
+	secret, hash, err := newSecret()
+	if err != nil {
+		return "", err
+	}
+	key.ID = 0
+	key.KeyID, key.Hash = keyID, hash
+	key.LastUsedAt, key.RevokedAt = nil, nil
+	if err := orm.DB.WithContext(ctx).Create(key).Error; err != nil {
+		return "", err
+	}
+	return keyID + "." + secret, nil
+}
+
+// RotateAPIKey replaces the secret of the key with id. The old plaintext
+// key stops working at once, and the new one is returned.
+func RotateAPIKey(ctx context.Context, id any) (*APIKey, string, error) {
+	key, err := getAPIKey(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if key.RevokedAt != nil {
+		return nil, "", ErrRevokedAPIKey
+	}
+	secret, hash, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	key.Hash = hash
+	if err := orm.DB.WithContext(ctx).Model(key).Update("Hash", hash).Error; err != nil {
+		return nil, "", err
+	}
+	return key, key.KeyID + "." + secret, nil
+}
+
+// RevokeAPIKey revokes the key with id. Revoked keys are kept for the
+// records, but never authenticate again.
+func RevokeAPIKey(ctx context.Context, id any) (*APIKey, error) {
+	key, err := getAPIKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		if err := orm.DB.WithContext(ctx).Model(key).Update("RevokedAt", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// AuthenticateAPIKey checks the plaintext key, and returns the APIKey if
+// it's valid: known, not revoked and not expired. LastUsedAt of the key is
+// updated (at most once a minute).
+func AuthenticateAPIKey(ctx context.Context, plaintext string) (*APIKey, error) {
+	keyID, secret, ok := strings.Cut(plaintext, ".")
+	if !ok || keyID == "" || secret == "" {
+		return nil, ErrInvalidAPIKey
+	}
+	var key APIKey
+	err := orm.DB.WithContext(ctx).Where("key_id = ?", keyID).Take(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil {
+		return nil, ErrRevokedAPIKey
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return nil, ErrExpiredAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyLastUsedInterval {
+		key.LastUsedAt = &now
+		// UpdateColumn: using a key is not an update of it
+		err := orm.DB.WithContext(ctx).Model(&key).UpdateColumn("LastUsedAt", now).Error
+		if err != nil {
+			logger.WithContext(ctx).WithError(err).
+				WithField("keyId", key.KeyID).
+				Warn("AuthenticateAPIKey: update last used failed")
+		}
+	}
+	return &key, nil
+}
+
+// APIKeyMiddleware authenticates the Authorization: ApiKey <key> header of
+// requests, and sets the Principal of the key into the context. The
+// scopes of the key are enforced on all the Crud operations of the
+// request (see controller.Restrict).
+//
+// Requests without an ApiKey header pass if they are authenticated
+// (e.g. by a JWT middleware with AuthConfig.Optional before), or if
+// optional, otherwise they are refused with 401. An invalid key is
+// always refused.
+func APIKeyMiddleware(optional bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plaintext, ok := apiKeyToken(c.GetHeader("Authorization"))
+		if !ok {
+			if _, authenticated := PrincipalFrom(c); authenticated || optional {
+				c.Next()
+				return
+			}
+			apiKeyUnauthorized(c, ErrMissingAPIKey)
+			return
+		}
+		key, err := AuthenticateAPIKey(c, plaintext)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Info("APIKeyMiddleware: authenticate failed")
+			apiKeyUnauthorized(c, err)
+			return
+		}
+		SetPrincipal(c, key.Principal())
+		controller.Restrict(c, func(_ *gin.Context, request enum.AuthorizeRequest) error {
+			if !key.Allows(request.Model, request.Operation) {
+				return fmt.Errorf("%w: %s:%s", ErrAPIKeyScope, request.Model, request.Operation)
+			}
+			return nil
+		})
+		c.Next()
+	}
+}
+
+func apiKeyUnauthorized(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", `ApiKey`)
+	c.Abort()
+	controller.ResponseError(c, controller.CodeUnauthorized, err)
+}
+
+func apiKeyToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "ApiKey") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func getAPIKey(ctx context.Context, id any) (*APIKey, error) {
+	var key APIKey
+	err := orm.DB.WithContext(ctx).Take(&key, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %v", ErrUnknownAPIKey, id)
+	}
+	return &key, err
+}
+
+// newSecret generates a secret with its hash.
+func newSecret() (secret, hash string, err error) {
+	secret, err = randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, hashSecret(secret), nil
+}
+
+// hashSecret hashes the secret. The secrets are random with 256 bits,
+// so a fast hash is enough.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes in base64url.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+var (
+	ErrMissingAPIKey = errors.New("missing api key")
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	ErrExpiredAPIKey = errors.New("api key expired")
+	ErrRevokedAPIKey = errors.New("api key revoked")
+	ErrUnknownAPIKey = errors.New("unknown api key")
+	ErrAPIKeyScope   = errors.New("out of api key scopes")
+)
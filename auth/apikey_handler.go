@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/orm"
+)
+
+// ListAPIKeysHandler handles
+//
+//	GET /apikeys
+//
+// The hashes are never responded.
+//
+// Response:
+//   - 200 OK: { APIKeys: [...] }
+//   - 422 Unprocessable Entity: { error: "..." }
+func ListAPIKeysHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var keys []APIKey
+		if err := orm.DB.WithContext(c).Order("id").Find(&keys).Error; err != nil {
+			controller.ResponseError(c, controller.CodeProcessFailed, err)
+			return
+		}
+		controller.ResponseSuccess(c, keys)
+	}
+}
+
+// CreateAPIKeyHandler handles
+//
+//	POST /apikeys
+//
+// creates an API key. The plaintext key is responded only this time.
+//
+// Request body:
+//   - { name: "ci", subject: "ci-bot", roles: "editor", tenantId: "acme",
+//     scopes: "Todo:read,Project:*", expiresAt: "2030-01-01T00:00:00Z" }
+//
+// Response:
+//   - 200 OK: { APIKey: {...}, key: "<keyId>.<secret>" }
+//   - 400 Bad Request: { error: "..." }
+//   - 422 Unprocessable Entity: { error: "..." }
+func CreateAPIKeyHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var key APIKey
+		if err := c.ShouldBindJSON(&key); err != nil {
+			controller.ResponseError(c, controller.CodeBadRequest, err)
+			return
+		}
+		plaintext, err := CreateAPIKey(c, &key)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("CreateAPIKeyHandler: create failed")
+			controller.ResponseError(c, controller.CodeProcessFailed, err)
+			return
+		}
+		controller.ResponseSuccess(c, &key, gin.H{"key": plaintext})
+	}
+}
+
+// RotateAPIKeyHandler handles
+//
+//	POST /apikeys/:idParam/rotate
+//
+// replaces the secret of the key. The old key stops working at once.
+//
+// Response:
+//   - 200 OK: { APIKey: {...}, key: "<keyId>.<secret>" }
+//   - 404 Not Found: { error: "unknown api key" }
+//   - 422 Unprocessable Entity: { error: "api key revoked" }
+func RotateAPIKeyHandler(idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, plaintext, err := RotateAPIKey(c, c.Param(idParam))
+		if err != nil {
+			apiKeyError(c, err)
+			return
+		}
+		logger.WithContext(c).WithField("keyId", key.KeyID).Info("API key rotated")
+		controller.ResponseSuccess(c, key, gin.H{"key": plaintext})
+	}
+}
+
+// RevokeAPIKeyHandler handles
+//
+//	DELETE /apikeys/:idParam
+//
+// revokes the key. Revoking a revoked key does nothing.
+//
+// Response:
+//   - 200 OK: { APIKey: {...} }
+//   - 404 Not Found: { error: "unknown api key" }
+//   - 422 Unprocessable Entity: { error: "..." }
+func RevokeAPIKeyHandler(idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, err := RevokeAPIKey(c, c.Param(idParam))
+		if err != nil {
+			apiKeyError(c, err)
+			return
+		}
+		logger.WithContext(c).WithField("keyId", key.KeyID).Info("API key revoked")
+		controller.ResponseSuccess(c, key)
+	}
+}
+
+func apiKeyError(c *gin.Context, err error) {
+	code := controller.CodeProcessFailed
+	if errors.Is(err, ErrUnknownAPIKey) {
+		code = controller.CodeNotFound
+	}
+	controller.ResponseError(c, code, err)
+}
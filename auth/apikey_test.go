@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+)
+
+func TestAPIKey(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file:apikey?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	key := &APIKey{Name: "ci", Roles: "editor", TenantID: "acme", Scopes: "Todo:read, Project:*"}
+	plaintext, err := CreateAPIKey(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", APIKeyMiddleware(false), func(c *gin.Context) {
+		principal, _ := PrincipalFrom(c)
+		c.String(http.StatusOK, principal.Subject+" "+principal.TenantID)
+	})
+	request := func(header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := request("ApiKey " + plaintext); w.Code != http.StatusOK || w.Body.String() != "apikey:"+key.KeyID+" acme" {
+		t.Errorf("valid key: %d %s", w.Code, w.Body)
+	}
+	for _, header := range []string{"", "ApiKey nope", "ApiKey " + key.KeyID + ".wrong", "Bearer " + plaintext} {
+		if w := request(header); w.Code != http.StatusUnauthorized {
+			t.Errorf("%q: want 401, got %d", header, w.Code)
+		}
+	}
+
+	stored, err := AuthenticateAPIKey(ctx, plaintext)
+	if err != nil || stored.LastUsedAt == nil {
+		t.Errorf("LastUsedAt not tracked: %v", err)
+	}
+
+	for _, c := range []struct {
+		model string
+		op    enum.Operation
+		want  bool
+	}{
+		{"Todo", enum.OpList, true},
+		{"Todo", enum.OpGet, true},
+		{"Todo", enum.OpDelete, false},
+		{"Project", enum.OpDelete, true},
+		{"User", enum.OpGet, false},
+	} {
+		if got := stored.Allows(c.model, c.op); got != c.want {
+			t.Errorf("Allows(%s, %s) = %v, want %v", c.model, c.op, got, c.want)
+		}
+	}
+
+	_, rotated, err := RotateAPIKey(ctx, key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AuthenticateAPIKey(ctx, plaintext); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("old key after rotate: %v", err)
+	}
+	if _, err := AuthenticateAPIKey(ctx, rotated); err != nil {
+		t.Errorf("rotated key: %v", err)
+	}
+
+	if _, err := RevokeAPIKey(ctx, key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AuthenticateAPIKey(ctx, rotated); !errors.Is(err, ErrRevokedAPIKey) {
+		t.Errorf("revoked key: %v", err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	expired := &APIKey{Name: "old", Scopes: "*:*", ExpiresAt: &past}
+	plaintext, err = CreateAPIKey(ctx, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AuthenticateAPIKey(ctx, plaintext); !errors.Is(err, ErrExpiredAPIKey) {
+		t.Errorf("expired key: %v", err)
+	}
+}
+
+type Note struct {
+	orm.BasicModel
+	Text string `json:"text"`
+}
+
+func TestAPIKeyScopeOnExport(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file:apikeyexport?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if err := orm.RegisterModel(Note{}); err != nil {
+		t.Fatal(err)
+	}
+	orm.DB.Create(&Note{Text: "secret"})
+	ctx := context.Background()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/notes/export", APIKeyMiddleware(false), controller.ExportHandler[Note](&enum.ExportOption{}))
+
+	for _, c := range []struct {
+		scopes string
+		want   int
+	}{
+		{"Note:get, Project:*", http.StatusForbidden},
+		{"Note:list", http.StatusOK},
+	} {
+		plaintext, err := CreateAPIKey(ctx, &APIKey{Name: c.scopes, Scopes: c.scopes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/notes/export?format=ndjson", nil)
+		req.Header.Set("Authorization", "ApiKey "+plaintext)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s: want %d, got %d %s", c.scopes, c.want, w.Code, w.Body)
+		}
+		if leaked := strings.Contains(w.Body.String(), "secret"); leaked != (c.want == http.StatusOK) {
+			t.Errorf("%s: body %s", c.scopes, w.Body)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+// Package auth authenticates requests, and carries the authenticated
+// Principal in the context for the controllers, services and hooks.
+//
+// The JWT middleware validates HS256 / RS256 / ES256 tokens in the
+// Authorization: Bearer header, with a shared secret or the public keys
+// in a local JWKS file, configured by config.AuthConfig:
+//
+//	authenticator, err := auth.NewJWT(cfg.Auth)
+//	if err != nil { ... }
+//	r := router.NewRouter(router.WithMiddleware(authenticator.Middleware()))
+//
+// And then get the Principal anywhere with the request ctx:
+//
+//	principal, ok := auth.PrincipalFrom(ctx)
+//
+// Requests without a valid token are refused with 401, unless
+// AuthConfig.Optional is set: in that case requests without a token pass
+// with no Principal (an invalid token is still refused).
+//
+// Machine clients can use API keys instead (see APIKey): the
+// APIKeyMiddleware authenticates the Authorization: ApiKey header into a
+// Principal as well, and limits the request to the scopes of the key.
+package auth
+
+import "github.com/tqrj/cd/log"
+
+var logger = log.ZoneLogger("crud/auth")
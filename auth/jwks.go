@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+)
+
+// jwk is a JSON Web Key (RFC 7517) of RSA, EC (P-256) or oct keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type verificationKey struct {
+	kid string
+	alg string // the method family: HS, RS, ES
+	key any
+}
+
+// keySet is the keys to verify tokens, selected by kid and alg.
+type keySet struct {
+	keys []verificationKey
+}
+
+func (s *keySet) empty() bool {
+	return len(s.keys) == 0
+}
+
+func (s *keySet) add(k jwk, key any) {
+	family := map[string]string{"oct": "HS", "RSA": "RS", "EC": "ES"}[k.Kty]
+	s.keys = append(s.keys, verificationKey{kid: k.Kid, alg: family, key: key})
+}
+
+// loadFile loads the keys in a JWKS file: { "keys": [...] }.
+func (s *keySet) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadJWK, err)
+	}
+	for _, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return fmt.Errorf("%w (kid=%q): %v", ErrBadJWK, k.Kid, err)
+		}
+		s.add(k, key)
+	}
+	return nil
+}
+
+// keyFunc is the jwt.Keyfunc: finds the key by the kid and alg of token.
+func (s *keySet) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	alg := token.Method.Alg()
+	for _, k := range s.keys {
+		if (kid == "" || k.kid == "" || k.kid == kid) && len(alg) > 2 && k.alg == alg[:2] {
+			return k.key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: kid=%q alg=%s", ErrUnknownKey, kid, alg)
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "oct":
+		return decodeBase64(k.K)
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := decodeBase64(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
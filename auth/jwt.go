@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tqrj/cd/config"
+	"github.com/tqrj/cd/controller"
+	"strings"
+)
+
+// JWT authenticates requests by JSON Web Tokens.
+type JWT struct {
+	cfg    config.AuthConfig
+	keys   *keySet
+	parser *jwt.Parser
+}
+
+// NewJWT creates a JWT authenticator by the config. It loads the secret
+// and the keys in the JWKS file.
+func NewJWT(cfg config.AuthConfig) (*JWT, error) {
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = []string{"HS256", "RS256", "ES256"}
+	}
+	for _, alg := range cfg.Algorithms {
+		if alg != "HS256" && alg != "RS256" && alg != "ES256" {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+		}
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	if cfg.TenantClaim == "" {
+		cfg.TenantClaim = "tenant_id"
+	}
+
+	keys := &keySet{}
+	if cfg.Secret != "" {
+		keys.add(jwk{Kty: "oct"}, []byte(cfg.Secret))
+	}
+	if cfg.JWKSFile != "" {
+		if err := keys.loadFile(cfg.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+	if keys.empty() {
+		return nil, ErrNoKeys
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(cfg.Algorithms),
+		jwt.WithLeeway(cfg.ClockSkew),
+		jwt.WithIssuedAt(),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	return &JWT{cfg: cfg, keys: keys, parser: jwt.NewParser(options...)}, nil
+}
+
+// Authenticate validates the token and returns its Principal.
+func (a *JWT) Authenticate(token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(token, claims, a.keys.keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	for _, claim := range a.cfg.RequiredClaims {
+		if _, ok := claims[claim]; !ok {
+			return nil, fmt.Errorf("%w: missing claim %s", ErrInvalidToken, claim)
+		}
+	}
+
+	principal := &Principal{Claims: claims}
+	principal.Subject, _ = claims.GetSubject()
+	principal.Roles = stringsClaim(claims[a.cfg.RolesClaim])
+	if tenant, ok := claims[a.cfg.TenantClaim]; ok && tenant != nil {
+		principal.TenantID = fmt.Sprint(tenant)
+	}
+	return principal, nil
+}
+
+// Middleware authenticates the Authorization: Bearer token of requests,
+// and sets the Principal into the context. It responds 401 for requests
+// without a valid token.
+func (a *JWT) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			if a.cfg.Optional {
+				c.Next()
+				return
+			}
+			Unauthorized(c, ErrMissingToken)
+			return
+		}
+		principal, err := a.Authenticate(token)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Info("JWT: authenticate failed")
+			Unauthorized(c, err)
+			return
+		}
+		SetPrincipal(c, principal)
+		c.Next()
+	}
+}
+
+// Unauthorized aborts the request with 401 in the error response body.
+func Unauthorized(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", `Bearer`)
+	c.Abort()
+	controller.ResponseError(c, controller.CodeUnauthorized, err)
+}
+
+func bearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// stringsClaim reads a claim of string array, or space separated string.
+func stringsClaim(claim any) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []any:
+		var result []string
+		for _, v := range claim {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	ErrNoKeys               = errors.New("no secret or keys for JWT")
+	ErrMissingToken         = errors.New("missing bearer token")
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrUnknownKey           = errors.New("unknown key")
+	ErrBadJWK               = errors.New("bad JWK")
+)
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tqrj/cd/config"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAuthenticate(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewJWT(config.AuthConfig{
+		Secret:         "s3cret",
+		JWKSFile:       path,
+		Issuer:         "crud",
+		ClockSkew:      time.Minute,
+		RequiredClaims: []string{"sub", "exp"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	valid := jwt.MapClaims{"sub": "alice", "iss": "crud", "exp": now.Add(time.Hour).Unix(), "roles": []string{"admin"}, "tenant_id": 7}
+	skewed := jwt.MapClaims{"sub": "alice", "iss": "crud", "exp": now.Add(-30 * time.Second).Unix()}
+	expired := jwt.MapClaims{"sub": "alice", "iss": "crud", "exp": now.Add(-2 * time.Minute).Unix()}
+	noSub := jwt.MapClaims{"iss": "crud", "exp": now.Add(time.Hour).Unix()}
+	badIss := jwt.MapClaims{"sub": "alice", "iss": "evil", "exp": now.Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"HS256", sign(t, jwt.SigningMethodHS256, []byte("s3cret"), "", valid), true},
+		{"RS256", sign(t, jwt.SigningMethodRS256, rsaKey, "rsa", valid), true},
+		{"ES256", sign(t, jwt.SigningMethodES256, ecKey, "ec", valid), true},
+		{"clock skew", sign(t, jwt.SigningMethodHS256, []byte("s3cret"), "", skewed), true},
+		{"expired", sign(t, jwt.SigningMethodHS256, []byte("s3cret"), "", expired), false},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, []byte("guess"), "", valid), false},
+		{"missing required claim", sign(t, jwt.SigningMethodHS256, []byte("s3cret"), "", noSub), false},
+		{"wrong issuer", sign(t, jwt.SigningMethodHS256, []byte("s3cret"), "", badIss), false},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, rsaKey, "other", valid), false},
+		{"disallowed alg", sign(t, jwt.SigningMethodHS384, []byte("s3cret"), "", valid), false},
+	}
+	for _, tt := range tests {
+		principal, err := a.Authenticate(tt.token)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: want ok=%v, got err %v", tt.name, tt.ok, err)
+			continue
+		}
+		if tt.ok && principal.Subject != "alice" {
+			t.Errorf("%s: want subject alice, got %q", tt.name, principal.Subject)
+		}
+	}
+
+	principal, _ := a.Authenticate(tests[0].token)
+	if !principal.HasRole("admin") || principal.TenantID != "7" {
+		t.Errorf("want role admin and tenant 7, got %v %q", principal.Roles, principal.TenantID)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a, _ := NewJWT(config.AuthConfig{Secret: "s3cret"})
+	r := gin.New()
+	r.GET("/", a.Middleware(), func(c *gin.Context) {
+		principal, ok := PrincipalFrom(c)
+		if !ok {
+			t.Error("no principal in context")
+		}
+		if p, _ := PrincipalFrom(c.Request.Context()); p != principal {
+			t.Error("principal not in request context")
+		}
+		c.String(http.StatusOK, principal.Subject)
+	})
+
+	token := sign(t, jwt.SigningMethodHS256, []byte("s3cret"), "", jwt.MapClaims{"sub": "bob"})
+	for header, want := range map[string]int{
+		"":                 http.StatusUnauthorized,
+		"Bearer bad":       http.StatusUnauthorized,
+		"Bearer " + token:  http.StatusOK,
+		"bearer  " + token: http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", header)
+		r.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("Authorization %q: want %d, got %d %s", header, want, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/enum"
+	"reflect"
+)
+
+// Policy decides whether the principal (nil if anonymous) can do the
+// request.
+type Policy func(principal *Principal, request enum.AuthorizeRequest) bool
+
+// Authorize adapts policies to the Authorize hook of the Crud options.
+// A request is allowed if any of the policies allows it, for example:
+//
+//	// only admins may delete projects
+//	opt.DelOption.Authorize = auth.Authorize(auth.RBAC(auth.Roles{
+//	    enum.OpDelete: {"admin"},
+//	}))
+//
+//	// users may update only their own todos, admins may update any
+//	opt.UpdateOption.Authorize = auth.Authorize(
+//	    auth.RBAC(auth.Roles{enum.OpUpdate: {"admin"}}),
+//	    auth.Owner("UserID"),
+//	)
+func Authorize(policies ...Policy) enum.Authorize {
+	return func(c *gin.Context, request enum.AuthorizeRequest) error {
+		principal, _ := PrincipalFrom(c)
+		for _, policy := range policies {
+			if policy(principal, request) {
+				return nil
+			}
+		}
+		if principal == nil {
+			return ErrAnonymous
+		}
+		return fmt.Errorf("%w: %s can not %s", ErrDenied, principal.Subject, request.Operation)
+	}
+}
+
+// Roles is an RBAC role map: the roles allowed to do each operation.
+// Role "*" allows any authenticated principal.
+type Roles map[enum.Operation][]string
+
+// RBAC allows principals having a role allowed for the operation.
+// Operations not in the map are not allowed by RBAC.
+func RBAC(roles Roles) Policy {
+	return func(principal *Principal, request enum.AuthorizeRequest) bool {
+		if principal == nil {
+			return false
+		}
+		for _, role := range roles[request.Operation] {
+			if role == "*" || principal.HasRole(role) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Owner allows principals to operate on the records they own: the field
+// (e.g. "UserID") of the record equals to the principal Subject.
+// For nested routes, the owner of the parent record is checked.
+// Lists and bulk writes have no record, so are not allowed by Owner.
+func Owner(field string) Policy {
+	return func(principal *Principal, request enum.AuthorizeRequest) bool {
+		record := request.Record
+		if request.Parent != nil {
+			record = request.Parent
+		}
+		if principal == nil || record == nil || request.Bulk {
+			return false
+		}
+		value := reflect.ValueOf(record)
+		for value.Kind() == reflect.Ptr && !value.IsNil() {
+			value = value.Elem()
+		}
+		if value.Kind() != reflect.Struct {
+			return false
+		}
+		owner := value.FieldByName(field)
+		return owner.IsValid() && fmt.Sprint(owner.Interface()) == principal.Subject
+	}
+}
+
+// Authenticated allows any authenticated principal.
+func Authenticated() Policy {
+	return func(principal *Principal, _ enum.AuthorizeRequest) bool {
+		return principal != nil
+	}
+}
+
+// All allows a request only if all the policies allow it.
+func All(policies ...Policy) Policy {
+	return func(principal *Principal, request enum.AuthorizeRequest) bool {
+		for _, policy := range policies {
+			if !policy(principal, request) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+var (
+	ErrAnonymous = errors.New("authentication required")
+	ErrDenied    = errors.New("permission denied")
+)
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/enum"
+)
+
+type todo struct {
+	ID     uint
+	UserID uint
+}
+
+func TestAuthorize(t *testing.T) {
+	authorize := Authorize(
+		RBAC(Roles{enum.OpList: {"*"}, enum.OpDelete: {"admin"}}),
+		Owner("UserID"),
+	)
+	admin := &Principal{Subject: "1", Roles: []string{"admin"}}
+	user := &Principal{Subject: "2", Roles: []string{"user"}}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		request   enum.AuthorizeRequest
+		err       error
+	}{
+		{"anonymous", nil, enum.AuthorizeRequest{Operation: enum.OpList}, ErrAnonymous},
+		{"any role", user, enum.AuthorizeRequest{Operation: enum.OpList}, nil},
+		{"admin", admin, enum.AuthorizeRequest{Operation: enum.OpDelete, Record: &todo{UserID: 2}}, nil},
+		{"owner", user, enum.AuthorizeRequest{Operation: enum.OpDelete, Record: &todo{UserID: 2}}, nil},
+		{"not owner", user, enum.AuthorizeRequest{Operation: enum.OpDelete, Record: &todo{UserID: 3}}, ErrDenied},
+		{"parent owner", user, enum.AuthorizeRequest{Operation: enum.OpCreate, Record: &todo{}, Parent: &todo{UserID: 2}}, nil},
+		{"bulk", user, enum.AuthorizeRequest{Operation: enum.OpDelete, Bulk: true}, ErrDenied},
+		// the values of a bulk update are not the records it writes
+		{"bulk update", user, enum.AuthorizeRequest{Operation: enum.OpUpdate, Record: &todo{UserID: 2}, Bulk: true}, ErrDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/", nil)
+			if tt.principal != nil {
+				SetPrincipal(c, tt.principal)
+			}
+			err := authorize(c, tt.request)
+			if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("authorize() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/orm"
+)
+
+// Principal is the authenticated client of a request.
+type Principal struct {
+	Subject  string         // who: the sub claim
+	Roles    []string       // AuthConfig.RolesClaim
+	TenantID string         // AuthConfig.TenantClaim
+	Claims   map[string]any // all claims of the token
+}
+
+// HasRole reports whether the principal has any of the roles.
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, have := range p.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// principalKey is the key of the Principal in gin.Context.Keys.
+const principalKey = "crud/auth.Principal"
+
+// principalCtxKey is the key of the Principal in context.Context.
+type principalCtxKey struct{}
+
+// WithPrincipal returns a ctx carrying the principal (and the actor).
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	ctx = orm.WithActor(ctx, principal.Subject)
+	return context.WithValue(ctx, principalCtxKey{}, principal)
+}
+
+// SetPrincipal stores the principal in the gin.Context, and in its request
+// context as well, for the ctx passed down without gin. The roles are set
+// for the field permissions (see controller.FieldPermissions), the TenantID
+// limits the tenants of the requests (see controller.TenantMiddleware), and
+// the Subject is the actor of the AuditedModel.
+func SetPrincipal(c *gin.Context, principal *Principal) {
+	c.Set(principalKey, principal)
+	c.Set(orm.ActorKey, principal.Subject)
+	controller.SetRoles(c, principal.Roles)
+	controller.SetPrincipalTenant(c, principal.TenantID)
+	c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), principal))
+}
+
+// PrincipalFrom returns the principal of the request in ctx,
+// which is a *gin.Context or a context.Context derived from the request.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	if c, ok := ctx.(*gin.Context); ok {
+		if value, ok := c.Get(principalKey); ok {
+			principal, ok := value.(*Principal)
+			return principal, ok
+		}
+		if c.Request == nil {
+			return nil, false
+		}
+		ctx = c.Request.Context()
+	}
+	if principal, ok := ctx.Value(principalCtxKey{}).(*Principal); ok {
+		return principal, true
+	}
+	// a context.Context derived from the gin.Context, e.g. in a Transaction
+	principal, ok := ctx.Value(principalKey).(*Principal)
+	return principal, ok
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/service"
+)
+
+// TenantScope scopes a model to the tenant of the principal:
+//
+//	service.Scope[Todo](auth.TenantScope("tenant_id"))
+//
+// means the principal can only read and write the Todos with
+// tenant_id = principal.TenantID, and the Todos created are stamped with
+// it. Service calls without a principal having a tenant are refused
+// (use service.Unscoped for the trusted code).
+func TenantScope(column string) service.ScopeFunc {
+	return func(ctx context.Context) (map[string]any, error) {
+		principal, ok := PrincipalFrom(ctx)
+		if !ok || principal == nil || principal.TenantID == "" {
+			return nil, ErrNoTenant
+		}
+		return map[string]any{column: principal.TenantID}, nil
+	}
+}
+
+// OwnerScope scopes a model to the records owned by the principal, i.e.
+// column = principal.Subject, e.g. service.Scope[Note](auth.OwnerScope("user_id")).
+func OwnerScope(column string) service.ScopeFunc {
+	return func(ctx context.Context) (map[string]any, error) {
+		principal, ok := PrincipalFrom(ctx)
+		if !ok || principal == nil || principal.Subject == "" {
+			return nil, ErrAnonymous
+		}
+		return map[string]any{column: principal.Subject}, nil
+	}
+}
+
+var ErrNoTenant = errors.New("no tenant")
+
+// TenantFromClaim resolves the tenant of requests from the TenantID of the
+// principal (i.e. the TenantClaim of the AuthConfig), for router.WithTenancy.
+// The JWT Middleware must run before.
+func TenantFromClaim() controller.TenantResolver {
+	return func(c *gin.Context) string {
+		principal, ok := PrincipalFrom(c)
+		if !ok || principal == nil {
+			return ""
+		}
+		return principal.TenantID
+	}
+}
@@ -0,0 +1,177 @@
+package changelog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/tqrj/cd/event"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+	"gorm.io/gorm"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Entry is a record of the change log table.
+type Entry struct {
+	Seq      uint64     `json:"seq" gorm:"primaryKey;autoIncrement"`
+	Model    string     `json:"model" gorm:"index:idx_change_log_model_seq,priority:1"` // type name, e.g. "Todo"
+	RecordID string     `json:"recordId" gorm:"index"`                                  // Identity value
+	Kind     event.Kind `json:"kind"`
+	// Data is the JSON snapshot of the model after the change
+	// (before the change for deletes).
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"createdAt" gorm:"index"`
+}
+
+// TableName of Entry.
+func (Entry) TableName() string {
+	return "change_log"
+}
+
+var enableOnce sync.Once
+
+// Enable migrates the change log table, and adds the write hook to record
+// changes of all models.
+//
+// The log is read from the base DB, so it can not be enabled with the
+// tenancy (see orm.RequireBaseDB).
+func Enable() error {
+	if err := orm.RequireBaseDB("changelog"); err != nil {
+		return err
+	}
+	if err := orm.RegisterModel(&Entry{}); err != nil {
+		return err
+	}
+	enableOnce.Do(func() {
+		service.AddWriteHook(writeEntry)
+		event.SubscribeAll(func(ctx context.Context, change event.Change) error {
+			defaultFeed.notify()
+			return nil
+		})
+	})
+	return nil
+}
+
+// excluded is the set of model types not to be recorded:
+// reflect.Type => struct{}.
+var excluded sync.Map
+
+// Exclude excludes the models (e.g. &webhook.Subscription{}) from the
+// change log, for the models holding secrets, or not to be synced.
+func Exclude(models ...any) {
+	for _, model := range models {
+		excluded.Store(elemType(reflect.TypeOf(model)), struct{}{})
+	}
+}
+
+// VisibilityWindow is how long a gap in Seq is waited for. With concurrent
+// transactions (on postgres, mysql), a Seq is taken at insert, and an
+// entry may be committed after the ones with greater Seqs. Readers do not
+// read past such a gap until the entries after it are older than the
+// window, then the gap is taken as a rolled back entry. It should be
+// longer than the write transactions.
+var VisibilityWindow = 5 * time.Second
+
+// ModelName returns the name of models T in the change log.
+func ModelName[T any]() string {
+	return typeName(reflect.TypeOf(*new(T)))
+}
+
+// Since returns at most limit entries of the model with Seq greater
+// than seq, in Seq order. limit <= 0 means no limit.
+//
+// Only the visible entries (up to Latest) are returned, so that a reader
+// resuming from the Seq of the last entry returned misses nothing.
+func Since(ctx context.Context, model string, seq uint64, limit int) ([]Entry, error) {
+	latest, err := Latest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	db := orm.DB.WithContext(ctx).
+		Where("model = ? AND seq > ? AND seq <= ?", model, seq, latest).Order("seq")
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+	err = db.Find(&entries).Error
+	return entries, err
+}
+
+// Latest returns the Seq of the latest visible entry, or 0 if the log is
+// empty: all the entries up to it are committed (or rolled back), see
+// VisibilityWindow.
+func Latest(ctx context.Context) (uint64, error) {
+	db := orm.DB.WithContext(ctx).Model(&Entry{})
+
+	// the entries older than the window are settled.
+	var settled uint64
+	err := db.Session(&gorm.Session{}).
+		Where("created_at <= ?", time.Now().Add(-VisibilityWindow)).
+		Select("COALESCE(MAX(seq), 0)").Scan(&settled).Error
+	if err != nil {
+		return 0, err
+	}
+	var recent []uint64
+	err = db.Session(&gorm.Session{}).
+		Where("seq > ?", settled).Order("seq").Pluck("seq", &recent).Error
+	return contiguous(settled, recent), err
+}
+
+// contiguous returns the last of the seqs (in order) following seq without
+// a gap.
+func contiguous(seq uint64, seqs []uint64) uint64 {
+	for _, s := range seqs {
+		if s != seq+1 {
+			break
+		}
+		seq = s
+	}
+	return seq
+}
+
+// writeEntry is the service.WriteHook inserting the Entry of the change,
+// in the transaction of the write.
+func writeEntry(ctx context.Context, change event.Change) error {
+	if _, ok := change.Model.(*Entry); ok {
+		return nil
+	}
+	if _, ok := excluded.Load(elemType(reflect.TypeOf(change.Model))); ok {
+		return nil
+	}
+	data, err := json.Marshal(change.Model)
+	if err != nil {
+		return err
+	}
+	entry := Entry{
+		Model:    typeName(reflect.TypeOf(change.Model)),
+		RecordID: recordID(change.Model),
+		Kind:     change.Kind,
+		Data:     data,
+	}
+	return service.DB(ctx).Create(&entry).Error
+}
+
+func typeName(t reflect.Type) string {
+	if t = elemType(t); t == nil {
+		return ""
+	}
+	return t.Name()
+}
+
+// elemType *T => T
+func elemType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func recordID(model any) string {
+	if m, ok := model.(orm.Model); ok {
+		_, id := m.Identity()
+		return fmt.Sprint(id)
+	}
+	return ""
+}
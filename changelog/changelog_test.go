@@ -0,0 +1,73 @@
+package changelog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tqrj/cd/event"
+	"github.com/tqrj/cd/orm"
+)
+
+func TestSinceWaitsForGaps(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file:changelog_gaps?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	if err := orm.RegisterModel(&Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+	// 3 is taken by a transaction not committed yet
+	for _, seq := range []uint64{1, 2, 4} {
+		orm.DB.Create(&Entry{Seq: seq, Model: "Todo", RecordID: "1", Kind: event.KindUpdated, CreatedAt: now})
+	}
+
+	entries, err := Since(ctx, "Todo", 0, 0)
+	if err != nil || len(entries) != 2 || entries[1].Seq != 2 {
+		t.Fatalf("want entries 1, 2 before the gap, got %v %v", entries, err)
+	}
+	if latest, _ := Latest(ctx); latest != 2 {
+		t.Errorf("Latest: got %d, want 2", latest)
+	}
+
+	// committed
+	orm.DB.Create(&Entry{Seq: 3, Model: "Todo", RecordID: "2", Kind: event.KindCreated, CreatedAt: now})
+	entries, _ = Since(ctx, "Todo", 2, 0)
+	if len(entries) != 2 || entries[0].Seq != 3 || entries[1].Seq != 4 {
+		t.Errorf("want entries 3, 4 after the gap filled, got %v", entries)
+	}
+
+	// 5 is rolled back: passed after the window
+	orm.DB.Create(&Entry{Seq: 6, Model: "Todo", RecordID: "1", Kind: event.KindUpdated, CreatedAt: now})
+	if entries, _ = Since(ctx, "Todo", 4, 0); len(entries) != 0 {
+		t.Errorf("read past a fresh gap: %v", entries)
+	}
+	orm.DB.Model(&Entry{}).Where("seq = 6").Update("created_at", now.Add(-2*VisibilityWindow))
+	if entries, _ = Since(ctx, "Todo", 4, 0); len(entries) != 1 || entries[0].Seq != 6 {
+		t.Errorf("want entry 6 after the window, got %v", entries)
+	}
+}
+
+type secret struct {
+	orm.BasicModel
+	Token string
+}
+
+func TestExclude(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file:changelog_exclude?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	if err := orm.RegisterModel(&Entry{}); err != nil {
+		t.Fatal(err)
+	}
+	Exclude(&secret{})
+	if err := writeEntry(context.Background(), event.Change{Kind: event.KindCreated, Model: &secret{Token: "t"}}); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	orm.DB.Model(&Entry{}).Count(&count)
+	if count != 0 {
+		t.Errorf("excluded model recorded: %d entries", count)
+	}
+}
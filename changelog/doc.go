@@ -0,0 +1,33 @@
+// Package changelog records the model changes made by the service writes
+// into a change log table, ordered by a monotonically increasing sequence
+// number (Entry.Seq).
+//
+// Entries are inserted in the same database transaction as the writes, so
+// the log never misses a committed change nor records a rolled back one.
+// The sequence numbers allow clients to resume a change feed (e.g. by the
+// Last-Event-ID of SSE) or to sync deltas since a known point.
+//
+//	if err := changelog.Enable(); err != nil { ... }
+//
+//	entries, err := changelog.Since(ctx, "Todo", lastSeq, 100)  // catch up
+//
+//	sub := changelog.Subscribe("Todo", 64)  // live entries
+//	defer sub.Close()
+//	for entry := range sub.C { ... }
+//
+// Bulk writes (UpdateMany, DeleteMany) record an entry for each affected
+// record.
+//
+// With concurrent transactions, entries may be committed out of Seq order.
+// Readers (Since, Latest, live subscribers) stop at a gap in Seq until it's
+// filled or older than VisibilityWindow, so that they never skip an entry
+// committed late. Live subscribers poll the log every PollInterval to catch
+// up the entries written by other processes.
+//
+// Models holding secrets must be excluded by Exclude: the log stores the
+// JSON of the models. The webhook package excludes its models.
+package changelog
+
+import "github.com/tqrj/cd/log"
+
+var logger = log.ZoneLogger("crud/changelog")
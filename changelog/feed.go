@@ -0,0 +1,166 @@
+package changelog
+
+import (
+	"context"
+	"github.com/tqrj/cd/orm"
+	"sync"
+	"time"
+)
+
+// PollInterval is how often live subscribers check the change log for
+// entries written by other processes. Entries written by this process
+// are delivered right after commit.
+var PollInterval = 2 * time.Second
+
+// Subscription receives the live entries of a model from C.
+//
+// C is closed when the subscription is closed, or when the subscriber is
+// too slow to receive (its buffer is full). Dropped tells the latter:
+// the subscriber may resume by Since with the last Seq it received.
+type Subscription struct {
+	C <-chan Entry
+
+	c       chan Entry
+	model   string
+	dropped bool
+	closed  bool
+}
+
+// Dropped reports whether the subscription is dropped for being slow.
+func (s *Subscription) Dropped() bool {
+	defaultFeed.mu.Lock()
+	defer defaultFeed.mu.Unlock()
+	return s.dropped
+}
+
+// Close closes the subscription.
+func (s *Subscription) Close() {
+	defaultFeed.remove(s)
+}
+
+// Subscribe subscribes to the live entries of the model (the name of the
+// type, see ModelName), with a buffer of size buffer.
+func Subscribe(model string, buffer int) *Subscription {
+	c := make(chan Entry, buffer)
+	s := &Subscription{C: c, c: c, model: model}
+	defaultFeed.add(s)
+	return s
+}
+
+// feed tails the change log, and fans entries out to the subscriptions.
+type feed struct {
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+	seq           uint64
+	wake          chan struct{}
+	stop          context.CancelFunc
+}
+
+var defaultFeed = &feed{
+	subscriptions: map[*Subscription]struct{}{},
+	wake:          make(chan struct{}, 1),
+}
+
+// notify wakes the feed up to read new entries.
+func (f *feed) notify() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *feed) add(s *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscriptions[s] = struct{}{}
+	if f.stop == nil { // the first subscription: start tailing
+		seq, err := Latest(context.Background())
+		if err != nil {
+			logger.WithError(err).Warn("feed: get latest seq failed")
+		}
+		f.seq = seq
+		var ctx context.Context
+		ctx, f.stop = context.WithCancel(context.Background())
+		go f.loop(ctx)
+	}
+}
+
+func (f *feed) remove(s *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.close(s)
+	if len(f.subscriptions) == 0 && f.stop != nil { // the last one: stop tailing
+		f.stop()
+		f.stop = nil
+	}
+}
+
+// close closes s, f.mu must be held.
+func (f *feed) close(s *Subscription) {
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+	delete(f.subscriptions, s)
+}
+
+func (f *feed) loop(ctx context.Context) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-f.wake:
+		}
+		f.poll(ctx)
+	}
+}
+
+// poll reads the new entries and fans them out.
+func (f *feed) poll(ctx context.Context) {
+	f.mu.Lock()
+	seq := f.seq
+	f.mu.Unlock()
+
+	// up to the latest visible one, not to skip the entries of the
+	// transactions yet to be committed.
+	latest, err := Latest(ctx)
+	var entries []Entry
+	if err == nil && latest > seq {
+		err = orm.DB.WithContext(ctx).
+			Where("seq > ? AND seq <= ?", seq, latest).Order("seq").Limit(1000).Find(&entries).Error
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.WithError(err).Warn("feed: read change log failed")
+		}
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, entry := range entries {
+		for s := range f.subscriptions {
+			if s.model != entry.Model {
+				continue
+			}
+			select {
+			case s.c <- entry:
+			default: // too slow: drop it
+				logger.WithField("model", s.model).
+					Warn("feed: subscriber is too slow, dropped")
+				s.dropped = true
+				f.close(s)
+			}
+		}
+	}
+	f.seq = entries[len(entries)-1].Seq
+	if len(entries) == 1000 {
+		f.notify() // more to read
+	}
+}
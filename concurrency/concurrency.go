@@ -0,0 +1,170 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limit is the bound of the requests in flight. MaxInFlight defaults to 1.
+// With zero MaxQueue or QueueTimeout, requests beyond MaxInFlight are
+// rejected at once.
+type Limit struct {
+	MaxInFlight  int
+	MaxQueue     int
+	QueueTimeout time.Duration
+}
+
+// Limiter bounds the requests in flight by a Limit.
+type Limiter struct {
+	limit Limit
+	name  string
+	slots chan struct{}
+
+	queued   atomic.Int64
+	rejected atomic.Uint64
+	timedOut atomic.Uint64
+
+	lastLog atomic.Int64 // unix nano of the last shedding log
+}
+
+// Option is an option to construct the Limiter.
+type Option func(l *Limiter)
+
+// WithName names the Limiter in the Stats and logs.
+func WithName(name string) Option {
+	return func(l *Limiter) {
+		l.name = name
+	}
+}
+
+// New creates a Limiter, which is reported by Stats.
+func New(limit Limit, options ...Option) *Limiter {
+	if limit.MaxInFlight <= 0 {
+		limit.MaxInFlight = 1
+	}
+	l := &Limiter{limit: limit}
+	for _, option := range options {
+		option(l)
+	}
+	l.slots = make(chan struct{}, limit.MaxInFlight)
+
+	limiters.Lock()
+	limiters.all = append(limiters.all, l)
+	limiters.Unlock()
+	return l
+}
+
+// Name returns the name of the Limiter.
+func (l *Limiter) Name() string {
+	return l.name
+}
+
+// RetryAfter is the time suggested to the rejected clients to retry:
+// the QueueTimeout, at least a second.
+func (l *Limiter) RetryAfter() time.Duration {
+	if l.limit.QueueTimeout < time.Second {
+		return time.Second
+	}
+	return l.limit.QueueTimeout
+}
+
+// Acquire takes a slot to run, waiting in the queue if all slots are
+// taken. It returns ErrQueueFull or ErrQueueTimeout if rejected, or the
+// error of ctx if it's done while waiting. The release func must be called
+// once the request is done.
+func (l *Limiter) Acquire(ctx context.Context) (release func(), err error) {
+	release = func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if l.queued.Add(1) > int64(l.limit.MaxQueue) || l.limit.QueueTimeout <= 0 {
+		l.queued.Add(-1)
+		l.reject(ErrQueueFull)
+		return nil, ErrQueueFull
+	}
+	defer l.queued.Add(-1)
+
+	timer := time.NewTimer(l.limit.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		l.timedOut.Add(1)
+		l.reject(ErrQueueTimeout)
+		return nil, ErrQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// reject counts and logs (at most once a second) a rejection.
+func (l *Limiter) reject(reason error) {
+	rejected := l.rejected.Add(1)
+	now := time.Now().UnixNano()
+	last := l.lastLog.Load()
+	if now-last < int64(time.Second) || !l.lastLog.CompareAndSwap(last, now) {
+		return
+	}
+	logger.WithField("limiter", l.name).
+		WithField("reason", reason).
+		WithField("inFlight", len(l.slots)).
+		WithField("queued", l.queued.Load()).
+		WithField("rejected", rejected).
+		Warn("concurrency: shedding load")
+}
+
+// Stats is a snapshot of a Limiter.
+type Stats struct {
+	Name        string
+	MaxInFlight int
+	MaxQueue    int
+	InFlight    int    // running
+	Queued      int    // waiting in the queue
+	Rejected    uint64 // total, including TimedOut
+	TimedOut    uint64 // total rejected by QueueTimeout
+}
+
+// Stats returns the snapshot of the Limiter.
+func (l *Limiter) Stats() Stats {
+	return Stats{
+		Name:        l.name,
+		MaxInFlight: l.limit.MaxInFlight,
+		MaxQueue:    l.limit.MaxQueue,
+		InFlight:    len(l.slots),
+		Queued:      int(l.queued.Load()),
+		Rejected:    l.rejected.Load(),
+		TimedOut:    l.timedOut.Load(),
+	}
+}
+
+var limiters struct {
+	sync.Mutex
+	all []*Limiter
+}
+
+// AllStats returns the Stats of all the Limiters created, by name.
+func AllStats() []Stats {
+	limiters.Lock()
+	all := append([]*Limiter{}, limiters.all...)
+	limiters.Unlock()
+
+	stats := make([]Stats, 0, len(all))
+	for _, l := range all {
+		stats = append(stats, l.Stats())
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+var (
+	ErrQueueFull    = errors.New("too many requests in flight")
+	ErrQueueTimeout = errors.New("timed out waiting in the queue")
+)
@@ -0,0 +1,69 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(Limit{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 50 * time.Millisecond}, WithName("test"))
+	ctx := context.Background()
+
+	release, err := l.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// waits in the queue, and gets the slot once released
+	acquired := make(chan error)
+	go func() {
+		r, err := l.Acquire(ctx)
+		if err == nil {
+			r()
+		}
+		acquired <- err
+	}()
+	for l.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the queue is full
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("want ErrQueueFull, got %v", err)
+	}
+	release()
+	if err := <-acquired; err != nil {
+		t.Errorf("queued: %v", err)
+	}
+
+	// times out in the queue
+	release, _ = l.Acquire(ctx)
+	if _, err := l.Acquire(ctx); !errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("want ErrQueueTimeout, got %v", err)
+	}
+	release()
+
+	stats := l.Stats()
+	if stats.InFlight != 0 || stats.Queued != 0 || stats.Rejected != 2 || stats.TimedOut != 1 {
+		t.Errorf("stats: %+v", stats)
+	}
+
+	found := false
+	for _, s := range AllStats() {
+		found = found || s.Name == "test"
+	}
+	if !found {
+		t.Errorf("limiter not in AllStats")
+	}
+}
+
+func TestLimiterNoQueue(t *testing.T) {
+	l := New(Limit{MaxInFlight: 1})
+	release, _ := l.Acquire(context.Background())
+	defer release()
+	if _, err := l.Acquire(context.Background()); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("want ErrQueueFull, got %v", err)
+	}
+}
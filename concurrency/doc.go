@@ -0,0 +1,26 @@
+// Package concurrency bounds the requests in flight, and sheds the load
+// beyond.
+//
+// A Limiter lets MaxInFlight requests run at a time. The others wait in
+// a queue of MaxQueue for QueueTimeout at most, and are rejected if the
+// queue is full or the wait times out:
+//
+//	heavy := concurrency.New(concurrency.Limit{
+//	    MaxInFlight:  8,
+//	    MaxQueue:     32,
+//	    QueueTimeout: time.Second,
+//	}, concurrency.WithName("todos.list"))
+//
+// Use it for a Crud group with router.LimitConcurrency, or for an
+// operation by the Concurrency of the Crud options (enum.Limits).
+// Rejected requests are responded 503 Service Unavailable with the
+// Retry-After header.
+//
+// The queue depth and the rejections of all the Limiters are reported by
+// Stats (see package metrics), and the shedding is logged at most once a
+// second per Limiter.
+package concurrency
+
+import "github.com/tqrj/cd/log"
+
+var logger = log.ZoneLogger("crud/concurrency")
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // DBConfig is the configurations for connecting database
 type DBConfig struct {
 	Driver string // db driver name: sqlite, mysql, postgres
@@ -14,9 +16,25 @@ type HTTPConfig struct {
 	//TLSKeyPath  string `json:"tls_key_path"`  // path to tls key file
 }
 
+// AuthConfig is the configurations for JWT authentication (package auth)
+type AuthConfig struct {
+	Algorithms []string      // allowed algorithms: HS256, RS256, ES256
+	Secret     string        // HS256 shared secret
+	JWKSFile   string        // path to a local JWKS file with the verification keys
+	Issuer     string        // required iss, if not empty
+	Audience   string        // required aud, if not empty
+	ClockSkew  time.Duration // leeway for exp, nbf, iat: "30s"
+	// RequiredClaims must be present in tokens, e.g. ["sub", "exp"]
+	RequiredClaims []string
+	RolesClaim     string // claim of the roles of the Principal, default "roles"
+	TenantClaim    string // claim of the tenant of the Principal, default "tenant_id"
+	Optional       bool   // let requests without a token pass as anonymous
+}
+
 // BaseConfig includes common config for services
 type BaseConfig struct {
 	DB       DBConfig   // database config
 	HTTP     HTTPConfig // http listen config
+	Auth     AuthConfig // authentication config
 	LogLevel string     // log level
 }
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Redacted replaces the values of secrets in Redact.
+const Redacted = "[REDACTED]"
+
+// secretNames are the (lowercase) parts of the names of secret fields.
+// "key" covers e.g. APIKey, PrivateKey and SigningKey.
+var secretNames = []string{"secret", "password", "passwd", "token", "key", "credential"}
+
+var (
+	// [scheme://]user:password@ in URLs and mysql DSNs, the password ends at
+	// the last @ before the path
+	dsnUserPassword = regexp.MustCompile(`^((?:[a-zA-Z][a-zA-Z0-9+.-]*://)?[^:/@\s]*):[^\s/?]*@`)
+	// password=... in postgres DSNs and query strings
+	dsnPasswordParam = regexp.MustCompile(`(?i)\b(password|pwd)=[^\s&;]*`)
+)
+
+// Redact returns the config (a struct, or a pointer to it) as a map of
+// field names to values, with the secrets redacted, e.g. to show the
+// effective config:
+//
+//   - fields named like secret, password, token, key (e.g. APIKey or
+//     SigningKey) or credential are replaced by "[REDACTED]" (if not
+//     empty);
+//   - the passwords in the other strings (e.g. DBConfig.DSN, or a
+//     RedisURL "redis://:pass@host") are replaced by "[REDACTED]" as
+//     RedactDSN does, leaving the rest of the value.
+//
+// Embedded structs are flattened, and durations are formatted as "30s".
+func Redact(config any) any {
+	return redactValue(reflect.ValueOf(config), "")
+}
+
+func redactValue(v reflect.Value, name string) any {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		return redactValue(v.Elem(), name)
+	}
+	if isSecretName(name) {
+		if v.IsZero() {
+			return v.Interface()
+		}
+		return Redacted
+	}
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		m := map[string]any{}
+		redactStruct(v, m)
+		return m
+	case reflect.Map:
+		m := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := toString(iter.Key())
+			m[key] = redactValue(iter.Value(), key)
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		s := make([]any, v.Len())
+		for i := range s {
+			s[i] = redactValue(v.Index(i), name)
+		}
+		return s
+	case reflect.String:
+		return RedactDSN(v.String())
+	}
+	return v.Interface()
+}
+
+// redactStruct puts the exported fields of struct v into m, flattening
+// the embedded structs.
+func redactStruct(v reflect.Value, m map[string]any) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			redactStruct(v.Field(i), m)
+			continue
+		}
+		m[field.Name] = redactValue(v.Field(i), field.Name)
+	}
+}
+
+// RedactDSN replaces the password in the dsn with "[REDACTED]":
+//
+//	user:pass@tcp(127.0.0.1:3306)/db => user:[REDACTED]@tcp(127.0.0.1:3306)/db
+//	host=localhost password=pass     => host=localhost password=[REDACTED]
+func RedactDSN(dsn string) string {
+	dsn = dsnUserPassword.ReplaceAllString(dsn, "$1:"+Redacted+"@")
+	return dsnPasswordParam.ReplaceAllString(dsn, "$1="+Redacted)
+}
+
+func isSecretName(name string) bool {
+	name = strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+	for _, secret := range secretNames {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func toString(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/enum"
+	"reflect"
+)
+
+// restrictKey is the key of the request-level Authorize hooks
+// in gin.Context.Keys.
+const restrictKey = "crud/controller.Restrict"
+
+// Restrict adds an Authorize hook to the request, which is checked before
+// the Authorize hooks of the options, on every operation of the request.
+// It's for the middlewares to limit what the client can do regardless of
+// the routes, e.g. the scopes of an API key (see auth.APIKeyMiddleware).
+func Restrict(c *gin.Context, hook enum.Authorize) {
+	hooks, _ := c.Value(restrictKey).([]enum.Authorize)
+	c.Set(restrictKey, append(hooks[:len(hooks):len(hooks)], hook))
+}
+
+// hasAuthorize reports whether there is any Authorize hook to check,
+// i.e. the hook or any added by Restrict.
+func hasAuthorize(c *gin.Context, hook enum.Authorize) bool {
+	hooks, _ := c.Value(restrictKey).([]enum.Authorize)
+	return hook != nil || len(hooks) > 0
+}
+
+// checkAuthorize calls the hooks added by Restrict and the Authorize hook
+// if any. A denial is returned as ErrForbidden.
+func checkAuthorize(c *gin.Context, hook enum.Authorize, request enum.AuthorizeRequest) error {
+	hooks, _ := c.Value(restrictKey).([]enum.Authorize)
+	if hook != nil {
+		hooks = append(hooks[:len(hooks):len(hooks)], hook)
+	}
+	for _, h := range hooks {
+		if err := h(c, request); err != nil {
+			return fmt.Errorf("%w: %v", ErrForbidden, err)
+		}
+	}
+	return nil
+}
+
+// CheckAuthorize checks the Restrict hooks in c and the Authorize hook as
+// the CRUD handlers do, for the handlers out of this package (e.g. the
+// background jobs doing CRUD). The error wraps ErrForbidden if denied.
+func CheckAuthorize(c *gin.Context, hook enum.Authorize, request enum.AuthorizeRequest) error {
+	return checkAuthorize(c, hook, request)
+}
+
+// authorize checks the Authorize hook, and responds 403 if denied.
+// The handler should return if it's false.
+func authorize(c *gin.Context, hook enum.Authorize, request enum.AuthorizeRequest) bool {
+	if err := checkAuthorize(c, hook, request); err != nil {
+		logger.WithContext(c).WithError(err).
+			WithField("model", request.Model).
+			WithField("operation", request.Operation).
+			Warn("authorize: denied")
+		ResponseError(c, CodeForbidden, err)
+		return false
+	}
+	return true
+}
+
+// modelTypeName returns the type name of model T for the AuthorizeRequest.
+func modelTypeName[T any]() string {
+	t := reflect.TypeOf(*new(T))
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/changelog"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/event"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// loadChange builds the view of a change log entry of model T:
+//
+//	{ id: "1", T: {...} }  // the T is omitted for deletes (a tombstone)
+//
+// The record is read by GetByID with options, as GET /T/:id would read it.
+// So it's not visible (ok == false) if it's not matched by the options,
+// or it has been deleted since. Deletes are matched by snapshotVisible,
+// with the filters and the closure (the result of the QueryOptionClosure
+// in the options, if any).
+func loadChange[T orm.Model](ctx context.Context, entry changelog.Entry, filters map[string]string, options []enum.QueryOption, closure enum.QueryOption) (view gin.H, ok bool) {
+	view = gin.H{"id": entry.RecordID}
+	if entry.Kind == event.KindDeleted {
+		return view, snapshotVisible[T](ctx, entry, filters, closure)
+	}
+	var model T
+	if err := service.GetByID[T](ctx, entry.RecordID, &model, options...); err != nil {
+		return nil, false
+	}
+	view[getResponseModelName(model)] = &model
+	return view, true
+}
+
+// snapshotVisible reports whether the record of the entry, which is
+// deleted or not visible any more, can be told to the client: the model
+// snapshot in the entry is in the scope (see service.Scope) and matched
+// by the filters, and the row is matched by the closure if any.
+//
+// The closure is a query, so it's matched with the row kept by the soft
+// deletes (gorm.DeletedAt). Rows deleted for good can't be matched: they
+// are not told with a closure, not to leak the ids hidden by it.
+func snapshotVisible[T orm.Model](ctx context.Context, entry changelog.Entry, filters map[string]string, closure enum.QueryOption) bool {
+	var model T
+	if err := json.Unmarshal(entry.Data, &model); err != nil {
+		return false
+	}
+	if inScope, _ := service.InScope(ctx, &model); !inScope || !matchFilters(&model, filters) {
+		return false
+	}
+	if closure == nil {
+		return true
+	}
+	if !softDeleted(&model) {
+		return false
+	}
+	var row T
+	unscoped := func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }
+	return service.GetByID[T](ctx, entry.RecordID, &row, closure, unscoped) == nil
+}
+
+// softDeleted reports whether the model (a pointer to struct) is soft
+// deleted, i.e. it has a gorm.DeletedAt field.
+func softDeleted(model any) bool {
+	s, err := schema.Parse(model, schemaCache, orm.DB.NamingStrategy)
+	if err != nil {
+		return false
+	}
+	for _, field := range s.Fields {
+		if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) {
+			return true
+		}
+	}
+	return false
+}
+
+// changeQueryOptions builds the options to read changed records, from the
+// filters, preload of the request and the omit, closure of the GET handler.
+// It returns the result of the closure as well (nil without a closure), to
+// match the deletes (see loadChange).
+func changeQueryOptions(c *gin.Context, request enum.GetRequestOptions, omit []string, closure enum.QueryOptionClosure) ([]enum.QueryOption, enum.QueryOption) {
+	options := buildFilterOptions(request.Filters, request.FiltersAt)
+	if len(omit) != 0 {
+		options = append(options, service.Omit(omit))
+	}
+	for _, field := range request.Preload {
+		if field != "" {
+			options = append(options, service.Preload(field))
+		}
+	}
+	if closure == nil {
+		return options, nil
+	}
+	scope := closure(c, request)
+	return append(options, scope), scope
+}
+
+var schemaCache = &sync.Map{}
+
+// matchFilters reports whether the model (a pointer to struct) matches the
+// filters[column]=value in memory, as the FilterBy in database would do.
+func matchFilters(model any, filters map[string]string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	s, err := schema.Parse(model, schemaCache, orm.DB.NamingStrategy)
+	if err != nil {
+		return false
+	}
+	value := reflect.ValueOf(model).Elem()
+	for name, want := range filters {
+		if name == "" || want == "" {
+			continue
+		}
+		field := s.LookUpField(name)
+		if field == nil {
+			return false
+		}
+		got, _ := field.ValueOf(context.Background(), value)
+		if !equalFilterValue(got, want) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalFilterValue(got any, want string) bool {
+	v := reflect.ValueOf(got)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return false
+	}
+	if v.Kind() == reflect.Bool {
+		b, err := strconv.ParseBool(want)
+		return err == nil && b == v.Bool()
+	}
+	return fmt.Sprint(v.Interface()) == want
+}
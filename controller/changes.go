@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/changelog"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+	"reflect"
+	"strconv"
+)
+
+// ChangesHandler handles
+//
+//	GET /T/changes?since=<token>
+//
+// It returns what changed in models T since the token, for clients syncing
+// an offline copy:
+//
+//	{
+//	    Ts: [{...}, ...],                     // created or updated records, current version
+//	    tombstones: [{id: "1", seq: 42}, ...], // deleted records
+//	    next: "43",                           // token for the next sync
+//	    hasMore: true                         // more changes after next
+//	}
+//
+// Records are read as GET /T reads them (with the Omit, Pretreat and
+// QueryOptionClosure of ListOption). Changed records that are deleted, or
+// are no longer matched by the filters, are returned as tombstones, so
+// that clients drop them. Tombstones are only returned for the records in
+// the scope (see service.Scope) of the client and matched by the
+// QueryOptionClosure, so the ids of others are never leaked. The closure
+// is matched with the rows kept by the soft deletes: with a closure, the
+// records deleted for good are not tombstoned (see snapshotVisible). Bulk
+// deletes (DELETE /T) are tombstoned as well.
+//
+// The changes are paged by limit (ChangesOption.LimitMax at most): keep
+// requesting with the next token until hasMore is false. The token never
+// passes a change not committed yet (see changelog.VisibilityWindow), so
+// no change is skipped by the clients resuming from it. Without since,
+// it returns no changes but the current token, to start syncing after a
+// full GET /T.
+//
+// QueryOptions (See ChangesRequestOptions for more details):
+//
+//	since, limit, filters, filters_at, preload.
+//
+// Response:
+//   - 200 OK: { Ts: [...], tombstones: [...], next: "...", hasMore: false }
+//   - 400 Bad Request: { error: "request band failed" }
+//   - 403 Forbidden: { error: "forbidden" }
+//   - 422 Unprocessable Entity: { error: "get process failed" }
+func ChangesHandler[T orm.Model](listOpt *enum.ListOption, opt *enum.ChangesOption) gin.HandlerFunc {
+	modelName := changelog.ModelName[T]()
+	idField, _ := (*new(T)).Identity()
+	limitMax := opt.LimitMax
+	if limitMax <= 0 {
+		limitMax = 100
+	}
+
+	return func(c *gin.Context) {
+		var request enum.ChangesRequestOptions
+		if err := c.ShouldBindQuery(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ChangesHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		request.Filters = c.QueryMap("filters")
+		if listOpt.Pretreat != nil {
+			var err error
+			request.GetRequestOptions, err = listOpt.Pretreat(c, request.GetRequestOptions)
+			if err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("ChangesHandler:Pretreat err")
+				ResponseError(c, CodeBadRequest, err)
+				return
+			}
+		}
+
+		if !authorize(c, listOpt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpList}) {
+			return
+		}
+		if !queryFieldsAllowed(c, reflect.TypeOf(*new(T)), request.GetRequestOptions) {
+			return
+		}
+
+		if request.Since == "" {
+			latest, err := changelog.Latest(c)
+			if err != nil {
+				ResponseError(c, CodeProcessFailed, err)
+				return
+			}
+			ResponseSuccess(c, []*T{}, gin.H{
+				"tombstones": []gin.H{},
+				"next":       strconv.FormatUint(latest, 10),
+				"hasMore":    false,
+			})
+			return
+		}
+		since, err := strconv.ParseUint(request.Since, 10, 64)
+		if err != nil {
+			ResponseError(c, CodeBadRequest, fmt.Errorf("%w: %s", ErrBadSyncToken, request.Since))
+			return
+		}
+		limit := request.Limit
+		if limit <= 0 || limit > limitMax {
+			limit = limitMax
+		}
+
+		entries, err := changelog.Since(c, modelName, since, limit)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ChangesHandler: read change log failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+
+		// the last change of each record in the page wins.
+		options, closure := changeQueryOptions(c, request.GetRequestOptions, listOpt.Omit, listOpt.QueryOptionClosure)
+		lastSeq := map[string]uint64{}
+		inScope := map[string]bool{}
+		var ids []string
+		for _, entry := range entries {
+			if _, ok := lastSeq[entry.RecordID]; !ok {
+				ids = append(ids, entry.RecordID)
+			}
+			lastSeq[entry.RecordID] = entry.Seq
+			if !inScope[entry.RecordID] {
+				inScope[entry.RecordID] = snapshotVisible[T](c, entry, nil, closure)
+			}
+		}
+
+		upserts := []*T{}
+		if len(ids) != 0 {
+			options = append(options, service.FilterBy(idField, ids))
+			if err := service.GetMany[T](c, &upserts, options...); err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("ChangesHandler: GetMany failed")
+				ResponseError(c, CodeProcessFailed, err)
+				return
+			}
+		}
+		visible := map[string]bool{}
+		for _, model := range upserts {
+			_, id := (*model).Identity()
+			visible[fmt.Sprint(id)] = true
+		}
+		tombstones := []gin.H{}
+		for _, id := range ids {
+			if !visible[id] && inScope[id] {
+				tombstones = append(tombstones, gin.H{"id": id, "seq": lastSeq[id]})
+			}
+		}
+
+		next := since
+		if len(entries) != 0 {
+			next = entries[len(entries)-1].Seq
+		}
+		ResponseSuccess(c, upserts, gin.H{
+			"tombstones": tombstones,
+			"next":       strconv.FormatUint(next, 10),
+			"hasMore":    len(entries) == limit,
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/concurrency"
+)
+
+// ConcurrencyMiddleware bounds the requests in flight by the limiter.
+// Requests rejected by it are aborted with 503 and the Retry-After header.
+// A nil limiter limits nothing.
+func ConcurrencyMiddleware(limiter *concurrency.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+		release, err := limiter.Acquire(c.Request.Context())
+		if err != nil {
+			c.Abort()
+			if !errors.Is(err, concurrency.ErrQueueFull) && !errors.Is(err, concurrency.ErrQueueTimeout) {
+				return // client gone
+			}
+			c.Header("Retry-After", ceilSeconds(limiter.RetryAfter()))
+			ResponseError(c, CodeServiceUnavailable, err)
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
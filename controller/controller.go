@@ -14,6 +14,14 @@
 //
 //   - DELETE /models/:id => DeleteHandler[Model] : to delete an existing model
 //
+//   - PATCH  /models?filters[...] => UpdateManyHandler[Model] : to update all matched models
+//
+//   - DELETE /models?filters[...] => DeleteManyHandler[Model] : to delete all matched models
+//
+//   - GET    /models/export?format=csv => ExportHandler[Model] : to export models as csv / ndjson / xlsx
+//
+//   - POST   /models/import => ImportHandler[Model] : to create models from an uploaded csv / ndjson file
+//
 //   - GET    /models/:id/field => GetFieldHandler[Model]     : to retrieve a field (nested model) of a model
 //
 //   - POST   /models/:id/field => CreateNestedHandler[Model] : to create a nested model (association)
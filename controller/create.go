@@ -20,6 +20,7 @@ import (
 // Response:
 //   - 200 OK: { T: {...} }
 //   - 400 Bad Request: { error: "request band failed" }
+//   - 403 Forbidden: { error: "forbidden" }
 //   - 422 Unprocessable Entity: { error: "create process failed" }
 func CreateHandler[T any](opt *enum.CreateOption) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -40,6 +41,15 @@ func CreateHandler[T any](opt *enum.CreateOption) gin.HandlerFunc {
 			}
 			model = res.(T)
 		}
+		if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpCreate, Record: &model}) {
+			return
+		}
+		if err := checkWritable(c, &model, nil); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("CreateHandler: write forbidden field")
+			ResponseError(c, CodeForbidden, err)
+			return
+		}
 		logger.WithContext(c).Tracef("CreateHandler: Create %#v", model)
 		err := service.Create(c, &model, opt, service.IfNotExist())
 		if err != nil {
@@ -48,7 +58,7 @@ func CreateHandler[T any](opt *enum.CreateOption) gin.HandlerFunc {
 			ResponseError(c, CodeProcessFailed, err)
 			return
 		}
-		c.JSON(200, SuccessResponseBody(model))
+		ResponseSuccess(c, model)
 	}
 }
 
@@ -69,6 +79,7 @@ func CreateHandler[T any](opt *enum.CreateOption) gin.HandlerFunc {
 // Response:
 //   - 200 OK: { P: {...} }
 //   - 400 Bad Request: { error: "request band failed" }
+//   - 403 Forbidden: { error: "forbidden" }
 //   - 422 Unprocessable Entity: { error: "create process failed" }
 func CreateNestedHandler[P orm.Model, T orm.Model](parentIDRouteParam string, field string, opt *enum.CreateOption) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -107,12 +118,23 @@ func CreateNestedHandler[P orm.Model, T orm.Model](parentIDRouteParam string, fi
 			return
 		}
 
-		logger.WithContext(c).
-			Tracef("CreateNestedHandler: Create %#v, parent=%#v", child, parent)
-
 		//field := strings.ToUpper(field)[:1] + field[1:]
 		field := nameToField(field, parent)
 
+		if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpCreate, Record: &child, Parent: &parent, Field: field}) {
+			return
+		}
+		if _, childID := child.Identity(); reflect.ValueOf(childID).IsZero() {
+			if err := checkWritable(c, &child, nil); err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("CreateNestedHandler: write forbidden field")
+				ResponseError(c, CodeForbidden, err)
+				return
+			}
+		}
+		logger.WithContext(c).
+			Tracef("CreateNestedHandler: Create %#v, parent=%#v", child, parent)
+
 		err := service.Create(c, &child, opt, service.NestInto(&parent, field, nil))
 		if err != nil {
 			logger.WithContext(c).WithError(err).
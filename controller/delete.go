@@ -1,11 +1,13 @@
 package controller
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/cast"
 	"github.com/tqrj/cd/enum"
 	"github.com/tqrj/cd/orm"
 	"github.com/tqrj/cd/service"
+	"reflect"
 )
 
 // DeleteHandler handles
@@ -19,6 +21,7 @@ import (
 // Response:
 //   - 200 OK: { deleted: true }
 //   - 400 Bad Request: { error: "missing id" }
+//   - 403 Forbidden: { error: "forbidden" }
 //   - 422 Unprocessable Entity: { error: "delete process failed" }
 func DeleteHandler[T orm.Model](idParam string, opt *enum.DelOption) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -49,6 +52,18 @@ func DeleteHandler[T orm.Model](idParam string, opt *enum.DelOption) gin.Handler
 				return
 			}
 		}
+		if hasAuthorize(c, opt.Authorize) {
+			var model T
+			if err := service.GetByID[T](c, id, &model); err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("DeleteHandler: GetByID failed")
+				ResponseError(c, CodeNotFound, err)
+				return
+			}
+			if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpDelete, Record: &model}) {
+				return
+			}
+		}
 		_, err := service.DeleteByID[T](c, id, opt)
 		if err != nil {
 			ResponseError(c, CodeProcessFailed, err)
@@ -58,6 +73,55 @@ func DeleteHandler[T orm.Model](idParam string, opt *enum.DelOption) gin.Handler
 	}
 }
 
+// DeleteManyHandler handles
+//
+//	DELETE /T?filters[field]=value
+//
+// Deletes all models T matched by the filters in a single DELETE statement.
+// Requests without any filter are refused unless confirm=true is given.
+//
+// Request body: none
+//
+// Response:
+//   - 200 OK: { rowsAffected: n }
+//   - 400 Bad Request: { error: "no filters" }
+//   - 403 Forbidden: { error: "forbidden" }
+//   - 422 Unprocessable Entity: { error: "delete process failed" }
+func DeleteManyHandler[T orm.Model](opt *enum.DelOption) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		options, err := buildBulkOptions(c, reflect.TypeOf(*new(T)), opt.QueryOptionClosure)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("DeleteManyHandler: build query failed")
+			code := CodeBadRequest
+			if errors.Is(err, ErrFieldForbidden) {
+				code = CodeForbidden
+			}
+			ResponseError(c, code, err)
+			return
+		}
+		if len(opt.LimitID) != 0 {
+			idField, _ := (*new(T)).Identity()
+			options = append(options, service.Exclude(idField, opt.LimitID))
+		}
+		if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpDelete, Bulk: true}) {
+			return
+		}
+
+		logger.WithContext(c).
+			Tracef("DeleteManyHandler: Delete %T", *new(T))
+
+		rowsAffected, err := service.DeleteMany[T](c, options...)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("DeleteManyHandler: DeleteMany failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		ResponseSuccess(c, nil, gin.H{"rowsAffected": rowsAffected})
+	}
+}
+
 // DeleteNestedHandler handles
 //
 //	DELETE /P/:parentIdParam/T/:childIdParam
@@ -73,8 +137,9 @@ func DeleteHandler[T orm.Model](idParam string, opt *enum.DelOption) gin.Handler
 // Response:
 //   - 200 OK: { deleted: true }
 //   - 400 Bad Request: { error: "missing id" }
+//   - 403 Forbidden: { error: "forbidden" }
 //   - 422 Unprocessable Entity: { error: "delete process failed" }
-func DeleteNestedHandler[P orm.Model, T orm.Model](parentIdParam string, field string, childIdParam string) gin.HandlerFunc {
+func DeleteNestedHandler[P orm.Model, T orm.Model](parentIdParam string, field string, childIdParam string, opt *enum.DelOption) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		parentId := c.Param(parentIdParam)
 		if parentId == "" {
@@ -95,6 +160,24 @@ func DeleteNestedHandler[P orm.Model, T orm.Model](parentIdParam string, field s
 		//field := strings.ToUpper(field)[:1] + field[1:]
 		field := nameToField(field, new(P))
 
+		if hasAuthorize(c, opt.Authorize) {
+			var parent P
+			var child T
+			err := service.GetByID[P](c, parentId, &parent)
+			if err == nil {
+				err = service.GetByID[T](c, childId, &child)
+			}
+			if err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("DeleteNestedHandler: GetByID failed")
+				ResponseError(c, CodeNotFound, err)
+				return
+			}
+			if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpDelete, Record: &child, Parent: &parent, Field: field}) {
+				return
+			}
+		}
+
 		logger.WithContext(c).
 			Tracef("DeleteNestedHandler: Delete %v of %v, parentId=%v, field=%v, childId=%v", *new(T), *new(P), parentId, field, childId)
 
@@ -0,0 +1,338 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ExportHandler handles
+//
+//	GET /T/export?format=csv
+//
+// It streams all models T matched by the filters to the client as a file
+// in the given format (csv, ndjson or xlsx; csv by default). Rows are
+// read from the database in batches, so the whole table is never held in
+// memory. Columns are the scalar fields of T, named by their json tags.
+//
+// QueryOptions (See ExportRequestOptions for more details):
+//
+//	format, fields, order_by, desc, filters, filters_at.
+//
+// Response:
+//   - 200 OK: the exported file
+//   - 400 Bad Request: { error: "request band failed" }
+//   - 403 Forbidden: { error: "forbidden" }
+//   - 422 Unprocessable Entity: { error: "export process failed" }
+func ExportHandler[T orm.Model](opt *enum.ExportOption) gin.HandlerFunc {
+	var columns []jsonColumn
+	for _, column := range jsonColumns(*new(T)) {
+		if !Contains(opt.Omit, column.Field) {
+			columns = append(columns, column)
+		}
+	}
+	batchSize := opt.BatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+
+	return func(c *gin.Context) {
+		var request enum.ExportRequestOptions
+		if err := c.ShouldBindQuery(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ExportHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		request.Filters = c.QueryMap("filters")
+
+		if opt.Pretreat != nil {
+			var err error
+			request.GetRequestOptions, err = opt.Pretreat(c, request.GetRequestOptions)
+			if err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("ExportHandler:Pretreat err")
+				ResponseError(c, CodeBadRequest, err)
+				return
+			}
+		}
+
+		if !queryFieldsAllowed(c, reflect.TypeOf(*new(T)), request.GetRequestOptions) {
+			return
+		}
+		if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpList}) {
+			return
+		}
+
+		format, ok := exportFormats[request.Format]
+		if !ok {
+			ResponseError(c, CodeBadRequest, fmt.Errorf("%w: %s", ErrUnknownFormat, request.Format))
+			return
+		}
+		readableColumns := make([]jsonColumn, 0, len(columns))
+		for _, column := range columns {
+			if readable(c, reflect.TypeOf(*new(T)), column.Field) {
+				readableColumns = append(readableColumns, column)
+			}
+		}
+		selected, err := selectColumns(readableColumns, request.Fields)
+		if err != nil {
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		options := buildFilterOptions(request.Filters, request.FiltersAt)
+		if len(opt.Omit) != 0 {
+			options = append(options, service.Omit(opt.Omit))
+		}
+		if opt.QueryOptionClosure != nil {
+			options = append(options, opt.QueryOptionClosure(c, request.GetRequestOptions))
+		}
+		find := service.FindInBatches[T]
+		if request.OrderBy != "" {
+			options = append(options, service.OrderBy(request.OrderBy, request.Descending))
+			find = service.FindInPages[T]
+		}
+
+		filename := strings.ToLower(reflect.TypeOf(*new(T)).Name()) + "s." + format.Extension
+		c.Header("Content-Type", format.ContentType)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		exporter := format.New(c.Writer)
+		err = exporter.Header(columnNames(selected))
+		if err == nil {
+			err = find(c, batchSize, func(batch []*T) error {
+				for _, model := range batch {
+					value := reflect.ValueOf(model).Elem()
+					row := make([]any, len(selected))
+					for i, column := range selected {
+						row[i] = columnValue(value, column)
+					}
+					if err := exporter.Row(row); err != nil {
+						return err
+					}
+				}
+				if err := exporter.Flush(); err != nil {
+					return err
+				}
+				c.Writer.Flush()
+				return nil
+			}, options...)
+		}
+		if err == nil {
+			err = exporter.Close()
+		}
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ExportHandler: export failed")
+			if !c.Writer.Written() {
+				c.Header("Content-Type", "")
+				c.Header("Content-Disposition", "")
+				ResponseError(c, CodeProcessFailed, err)
+				return
+			}
+			// it's too late to respond an error: the file is truncated.
+			_ = c.Error(err)
+		}
+	}
+}
+
+// selectColumns selects columns by json names in order.
+// All columns are selected if names is empty.
+func selectColumns(columns []jsonColumn, names []string) ([]jsonColumn, error) {
+	if len(names) == 0 {
+		return columns, nil
+	}
+	var selected []jsonColumn
+	for _, name := range names {
+		for _, name := range strings.Split(name, ",") {
+			column, ok := findColumn(columns, name)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrUnknownField, name)
+			}
+			selected = append(selected, column)
+		}
+	}
+	return selected, nil
+}
+
+func columnNames(columns []jsonColumn) []string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+// region exporters
+
+// exporter writes rows into a file of some format.
+type exporter interface {
+	Header(columns []string) error
+	Row(values []any) error
+	Flush() error // flushes buffered rows, called after each batch
+	Close() error // finishes the file
+}
+
+// exportFormat is a supported file format of ExportHandler.
+type exportFormat struct {
+	Extension   string
+	ContentType string
+	New         func(w io.Writer) exporter
+}
+
+var exportFormats = map[string]exportFormat{
+	"":       {"csv", "text/csv; charset=utf-8", newCSVExporter},
+	"csv":    {"csv", "text/csv; charset=utf-8", newCSVExporter},
+	"ndjson": {"ndjson", "application/x-ndjson", newNDJSONExporter},
+	"xlsx":   {"xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", newXLSXExporter},
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func newCSVExporter(w io.Writer) exporter {
+	return &csvExporter{w: csv.NewWriter(w)}
+}
+
+func (e *csvExporter) Header(columns []string) error {
+	return e.w.Write(columns)
+}
+
+func (e *csvExporter) Row(values []any) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		switch value := value.(type) {
+		case nil:
+			record[i] = ""
+		case time.Time:
+			record[i] = value.Format(time.RFC3339Nano)
+		case []byte:
+			record[i] = string(value)
+		default:
+			record[i] = fmt.Sprint(value)
+		}
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExporter) Close() error {
+	return e.Flush()
+}
+
+// ndjsonExporter writes a JSON object per line, keys in the column order.
+type ndjsonExporter struct {
+	w       *bufio.Writer
+	columns [][]byte // json encoded column names
+}
+
+func newNDJSONExporter(w io.Writer) exporter {
+	return &ndjsonExporter{w: bufio.NewWriter(w)}
+}
+
+func (e *ndjsonExporter) Header(columns []string) error {
+	for _, column := range columns {
+		name, err := json.Marshal(column)
+		if err != nil {
+			return err
+		}
+		e.columns = append(e.columns, name)
+	}
+	return nil
+}
+
+func (e *ndjsonExporter) Row(values []any) error {
+	_ = e.w.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			_ = e.w.WriteByte(',')
+		}
+		v, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, _ = e.w.Write(e.columns[i])
+		_ = e.w.WriteByte(':')
+		_, _ = e.w.Write(v)
+	}
+	_, err := e.w.WriteString("}\n")
+	return err
+}
+
+func (e *ndjsonExporter) Flush() error {
+	return e.w.Flush()
+}
+
+func (e *ndjsonExporter) Close() error {
+	return e.Flush()
+}
+
+// xlsxExporter writes rows by the excelize StreamWriter, which buffers
+// rows on disk. The file is written to w on Close.
+type xlsxExporter struct {
+	w      io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	row    int
+}
+
+func newXLSXExporter(w io.Writer) exporter {
+	return &xlsxExporter{w: w, file: excelize.NewFile()}
+}
+
+func (e *xlsxExporter) Header(columns []string) error {
+	stream, err := e.file.NewStreamWriter("Sheet1")
+	if err != nil {
+		return err
+	}
+	e.stream = stream
+
+	header := make([]any, len(columns))
+	for i, column := range columns {
+		header[i] = column
+	}
+	return e.Row(header)
+}
+
+func (e *xlsxExporter) Row(values []any) error {
+	e.row++
+	cell, err := excelize.CoordinatesToCellName(1, e.row)
+	if err != nil {
+		return err
+	}
+	return e.stream.SetRow(cell, values)
+}
+
+func (e *xlsxExporter) Flush() error {
+	return nil
+}
+
+func (e *xlsxExporter) Close() error {
+	defer e.file.Close()
+	if e.stream == nil {
+		return errors.New("xlsx: no header written")
+	}
+	if err := e.stream.Flush(); err != nil {
+		return err
+	}
+	return e.file.Write(e.w)
+}
+
+// endregion exporters
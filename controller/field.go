@@ -0,0 +1,405 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// FieldPermissions sets the field-level permissions of model T, which
+// override the crud tags of the fields:
+//
+//	type Employee struct {
+//	    orm.BasicModel
+//	    Name   string `json:"name"`
+//	    Salary int    `json:"salary" crud:"read=admin,hr;write=admin"`
+//	    Notes  string `json:"notes" crud:"read=admin;write="` // read only
+//	}
+//
+// is the same as
+//
+//	controller.FieldPermissions[Employee](enum.FieldPermissions{
+//	    "salary": {Read: []string{"admin", "hr"}, Write: []string{"admin"}},
+//	    "notes":  {Read: []string{"admin"}, Write: []string{}},
+//	})
+//
+// Fields are named by their json names (or the struct field names).
+// Unreadable fields are removed from the responses, and can not be used
+// to filter or sort. Writing an unwritable field is refused (403).
+// The roles of a request are set by SetRoles (auth.SetPrincipal does it).
+func FieldPermissions[T any](permissions enum.FieldPermissions) {
+	modelType := reflect.TypeOf(*new(T))
+	fieldOptions.Store(modelType, permissions)
+	fieldPermissionsCache.Delete(modelType)
+}
+
+// rolesKey is the key of the roles in gin.Context.Keys.
+const rolesKey = "crud/controller.Roles"
+
+// SetRoles sets the roles of the request, for the field permissions.
+func SetRoles(c *gin.Context, roles []string) {
+	c.Set(rolesKey, roles)
+}
+
+// rolesOf returns the roles set by SetRoles, ctx is the *gin.Context or a
+// context.Context derived from it.
+func rolesOf(ctx context.Context) []string {
+	roles, _ := ctx.Value(rolesKey).([]string)
+	return roles
+}
+
+var (
+	fieldOptions          sync.Map // reflect.Type => enum.FieldPermissions
+	fieldPermissionsCache sync.Map // reflect.Type => map[string]restrictedField
+)
+
+// restrictedField is a field of a model with permissions.
+type restrictedField struct {
+	Name   string // json name
+	Index  []int
+	Access enum.FieldAccess
+}
+
+// fieldPermissionsOf returns the restricted fields of the struct type t,
+// keyed by the struct field name. It's nil if there is none.
+func fieldPermissionsOf(t reflect.Type) map[string]restrictedField {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	if cached, ok := fieldPermissionsCache.Load(t); ok {
+		return cached.(map[string]restrictedField)
+	}
+
+	var options enum.FieldPermissions
+	if o, ok := fieldOptions.Load(t); ok {
+		options = o.(enum.FieldPermissions)
+	}
+	var fields map[string]restrictedField
+	var walk func(t reflect.Type, index []int)
+	walk = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, ok := jsonTag(field)
+			if !ok {
+				continue
+			}
+			fieldIndex := append(append([]int{}, index...), i)
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				walk(field.Type, fieldIndex)
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			access, ok := options[name]
+			if !ok {
+				access, ok = options[field.Name]
+			}
+			if !ok {
+				access, ok = parseCrudTag(field.Tag.Get("crud"))
+			}
+			if !ok {
+				continue
+			}
+			if fields == nil {
+				fields = map[string]restrictedField{}
+			}
+			fields[field.Name] = restrictedField{Name: name, Index: fieldIndex, Access: access}
+		}
+	}
+	walk(t, nil)
+	fieldPermissionsCache.Store(t, fields)
+	return fields
+}
+
+// parseCrudTag parses the crud tag `crud:"read=admin,hr;write=admin"`.
+// A missing read or write is unrestricted, while an empty list (write=)
+// allows nobody.
+func parseCrudTag(tag string) (access enum.FieldAccess, ok bool) {
+	for _, part := range strings.Split(tag, ";") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		roles := []string{}
+		for _, role := range strings.Split(value, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles = append(roles, role)
+			}
+		}
+		switch strings.TrimSpace(key) {
+		case "read":
+			access.Read, ok = roles, true
+		case "write":
+			access.Write, ok = roles, true
+		}
+	}
+	return access, ok
+}
+
+// allowed reports whether any of the roles is in allow,
+// nil allow means unrestricted.
+func allowed(roles []string, allow []string) bool {
+	if allow == nil {
+		return true
+	}
+	for _, role := range roles {
+		for _, a := range allow {
+			if role == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasRestrictedFields reports whether t or any type nested in it has
+// fields restricted to read.
+func hasRestrictedFields(t reflect.Type) bool {
+	return hasRestrictedFieldsIn(t, map[reflect.Type]bool{})
+}
+
+func hasRestrictedFieldsIn(t reflect.Type, visited map[reflect.Type]bool) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || visited[t] {
+		return false
+	}
+	visited[t] = true
+	for _, f := range fieldPermissionsOf(t) {
+		if f.Access.Read != nil {
+			return true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).IsExported() && hasRestrictedFieldsIn(t.Field(i).Type, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact returns the model with the fields unreadable by the roles
+// removed. The model is returned as is if it has no restricted fields,
+// otherwise it's converted to the JSON values (maps and slices).
+func redact(ctx context.Context, model any) any {
+	if model == nil || !hasRestrictedFields(reflect.TypeOf(model)) {
+		return model
+	}
+	data, err := json.Marshal(model)
+	if err != nil {
+		return model
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return model
+	}
+	redactValue(value, reflect.TypeOf(model), rolesOf(ctx))
+	return value
+}
+
+// redactValue removes the unreadable fields from the JSON value of type t.
+func redactValue(value any, t reflect.Type, roles []string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := value.(type) {
+	case []any:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for _, item := range v {
+				redactValue(item, t.Elem(), roles)
+			}
+		}
+	case map[string]any:
+		if t.Kind() == reflect.Map {
+			for _, item := range v {
+				redactValue(item, t.Elem(), roles)
+			}
+			return
+		}
+		if t.Kind() != reflect.Struct {
+			return
+		}
+		restricted := fieldPermissionsOf(t)
+		for name, item := range v {
+			fieldName, ok := jsonNameToFieldOf(name, t)
+			if !ok {
+				continue
+			}
+			if f, ok := restricted[fieldName]; ok && !allowed(roles, f.Access.Read) {
+				delete(v, name)
+				continue
+			}
+			if field, ok := t.FieldByName(fieldName); ok {
+				redactValue(item, field.Type, roles)
+			}
+		}
+	}
+}
+
+// redactView redacts the values of the gin.H view (e.g. of loadChange).
+func redactView(ctx context.Context, view gin.H) gin.H {
+	for k, v := range view {
+		view[k] = redact(ctx, v)
+	}
+	return view
+}
+
+// readable reports whether the field or column name of model T is
+// readable by the roles in ctx. Unknown names are readable.
+func readable(ctx context.Context, modelType reflect.Type, name string) bool {
+	restricted := fieldPermissionsOf(modelType)
+	if len(restricted) == 0 {
+		return true
+	}
+	roles := rolesOf(ctx)
+	for fieldName, f := range restricted {
+		if allowed(roles, f.Access.Read) {
+			continue
+		}
+		if strings.EqualFold(name, f.Name) || strings.EqualFold(name, fieldName) ||
+			strings.EqualFold(name, orm.DB.NamingStrategy.ColumnName("", fieldName)) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkQueryFields refuses the filters and ordering on fields unreadable
+// by the roles in ctx, which would leak the values (ErrFieldForbidden).
+// The filter keys and order_by must be the fields (or columns) of the
+// model, anything else (expressions, qualified names) is refused as
+// ErrUnknownField.
+func checkQueryFields(ctx context.Context, modelType reflect.Type, request enum.GetRequestOptions) error {
+	for name := range request.Filters {
+		if name == "" {
+			continue
+		}
+		field, err := queryField(modelType, name)
+		if err != nil {
+			return err
+		}
+		if !readable(ctx, modelType, field.Name) {
+			return fmt.Errorf("%w: can not filter by %s", ErrFieldForbidden, name)
+		}
+	}
+	// filters_at filters by created_at, see service.FilterAt
+	if len(request.FiltersAt) != 0 && !readable(ctx, modelType, "created_at") {
+		return fmt.Errorf("%w: can not filter by created_at", ErrFieldForbidden)
+	}
+	if request.OrderBy == "" {
+		return nil
+	}
+	for _, order := range strings.Split(request.OrderBy, ",") {
+		// name [asc|desc]
+		words := strings.Fields(order)
+		if len(words) == 0 || len(words) > 2 ||
+			len(words) == 2 && !strings.EqualFold(words[1], "asc") && !strings.EqualFold(words[1], "desc") {
+			return fmt.Errorf("%w: can not order by %q", ErrUnknownField, order)
+		}
+		field, err := queryField(modelType, words[0])
+		if err != nil {
+			return err
+		}
+		if !readable(ctx, modelType, field.Name) {
+			return fmt.Errorf("%w: can not order by %s", ErrFieldForbidden, words[0])
+		}
+	}
+	return nil
+}
+
+// queryField resolves the name of a filter or ordering to the field of
+// the model type, which must have a column.
+func queryField(modelType reflect.Type, name string) (*schema.Field, error) {
+	for modelType != nil && (modelType.Kind() == reflect.Ptr || modelType.Kind() == reflect.Slice || modelType.Kind() == reflect.Array) {
+		modelType = modelType.Elem()
+	}
+	if modelType == nil || modelType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownField, name)
+	}
+	s, err := schema.Parse(reflect.New(modelType).Interface(), schemaCache, orm.DB.NamingStrategy)
+	if err != nil {
+		return nil, err
+	}
+	field := s.LookUpField(name)
+	if field == nil || field.DBName == "" {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownField, name)
+	}
+	return field, nil
+}
+
+// queryFieldsAllowed checks the query fields by checkQueryFields, and
+// responds 403 if not allowed, or 400 for unknown fields.
+func queryFieldsAllowed(c *gin.Context, modelType reflect.Type, request enum.GetRequestOptions) bool {
+	if err := checkQueryFields(c, modelType, request); err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn("queryFieldsAllowed: query on forbidden or unknown field")
+		c.Abort()
+		code := CodeBadRequest
+		if errors.Is(err, ErrFieldForbidden) {
+			code = CodeForbidden
+		}
+		ResponseError(c, code, err)
+		return false
+	}
+	return true
+}
+
+// checkWritable refuses the changes on the fields unwritable by the roles
+// in ctx: the fields of model must equal to them of old (nil for creates,
+// i.e. the zero values).
+func checkWritable(ctx context.Context, model any, old any) error {
+	restricted := fieldPermissionsOf(reflect.TypeOf(model))
+	if len(restricted) == 0 {
+		return nil
+	}
+	roles := rolesOf(ctx)
+	value := reflect.Indirect(reflect.ValueOf(model))
+	var oldValue reflect.Value
+	if old != nil {
+		oldValue = reflect.Indirect(reflect.ValueOf(old))
+	}
+	for _, f := range restricted {
+		if allowed(roles, f.Access.Write) {
+			continue
+		}
+		got := value.FieldByIndex(f.Index)
+		if oldValue.IsValid() {
+			if reflect.DeepEqual(got.Interface(), oldValue.FieldByIndex(f.Index).Interface()) {
+				continue
+			}
+		} else if got.IsZero() {
+			continue
+		}
+		return fmt.Errorf("%w: can not write %s", ErrFieldForbidden, f.Name)
+	}
+	return nil
+}
+
+// checkWritableFields refuses the fields (struct field names) unwritable
+// by the roles in ctx.
+func checkWritableFields(ctx context.Context, model any, fields []string) error {
+	restricted := fieldPermissionsOf(reflect.TypeOf(model))
+	roles := rolesOf(ctx)
+	for _, field := range fields {
+		if f, ok := restricted[field]; ok && !allowed(roles, f.Access.Write) {
+			return fmt.Errorf("%w: can not write %s", ErrFieldForbidden, f.Name)
+		}
+	}
+	return nil
+}
@@ -19,9 +19,13 @@ import (
 //
 //	limit, offset, order_by, desc, filter_by, filter_value, preload, total.
 //
+// The order_by ("field [asc|desc], ...") and the filters[field] must name
+// the fields or columns of T.
+//
 // Response:
 //   - 200 OK: { Ts: [{...}, ...] }
-//   - 400 Bad Request: { error: "request band failed" }
+//   - 400 Bad Request: { error: "request band failed, unknown field or query too expensive" }
+//   - 403 Forbidden: { error: "forbidden" }
 //   - 422 Unprocessable Entity: { error: "get process failed" }
 func GetListHandler[T any](opt *enum.ListOption) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -44,12 +48,24 @@ func GetListHandler[T any](opt *enum.ListOption) gin.HandlerFunc {
 				return
 			}
 		}
+		if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpList}) {
+			return
+		}
+		if !queryFieldsAllowed(c, reflect.TypeOf(*new(T)), request) {
+			return
+		}
+		if !queryGuarded(c, opt.Guards, request) {
+			return
+		}
 		options := buildQueryOptions(request, opt.LimitMax, opt.Omit)
 		var queryOpt enum.QueryOption
 		if opt.QueryOptionClosure != nil {
 			queryOpt = opt.QueryOptionClosure(c, request)
 			options = append(options, queryOpt)
 		}
+		if !costGuarded[T](c, opt.Guards, options) {
+			return
+		}
 		var dest []*T
 		err := service.GetMany[T](c, &dest, options...)
 		if err != nil {
@@ -82,7 +98,8 @@ func GetListHandler[T any](opt *enum.ListOption) gin.HandlerFunc {
 //
 // Response:
 //   - 200 OK: { T: {...} }
-//   - 400 Bad Request: { error: "request band failed" }
+//   - 400 Bad Request: { error: "request band failed or query too expensive" }
+//   - 403 Forbidden: { error: "forbidden" }
 //   - 422 Unprocessable Entity: { error: "get process failed" }
 func GetByIDHandler[T orm.Model](idParam string, opt *enum.GetOption) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -104,6 +121,12 @@ func GetByIDHandler[T orm.Model](idParam string, opt *enum.GetOption) gin.Handle
 				return
 			}
 		}
+		if !queryFieldsAllowed(c, reflect.TypeOf(*new(T)), request) {
+			return
+		}
+		if !queryGuarded(c, opt.Guards, request) {
+			return
+		}
 		options := buildQueryOptions(request, 1, opt.Omit)
 		var queryOpt enum.QueryOption
 		if opt.QueryOptionClosure != nil {
@@ -117,6 +140,9 @@ func GetByIDHandler[T orm.Model](idParam string, opt *enum.GetOption) gin.Handle
 			ResponseError(c, CodeProcessFailed, err)
 			return
 		}
+		if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpGet, Record: dest}) {
+			return
+		}
 		ResponseSuccess(c, dest)
 	}
 }
@@ -135,12 +161,22 @@ func GetByIDHandler[T orm.Model](idParam string, opt *enum.GetOption) gin.Handle
 //
 // Preloads User.Order.Product instead of User.Product.
 //
+// The limit of a nested collection defaults to GetOption.Guards.NestedLimit
+// (10), and is capped by NestedLimitMax (100).
+//
 // Response:
-//   - 200 OK: { Fs: [{...}, ...] }  // field models
-//   - 400 Bad Request: { error: "request band failed" }
+//   - 200 OK: { Fs: [{...}, ...] }  // field models, a page of limit (10 by
+//     default) of them: use limit, offset (and total) to page through
+//     the collection.
+//   - 400 Bad Request: { error: "request band failed or query too expensive" }
+//   - 403 Forbidden: { error: "forbidden" }
 //   - 422 Unprocessable Entity: { error: "get process failed" }
 func GetFieldHandler[T orm.Model](idParam string, field string, opt *enum.GetOption) gin.HandlerFunc {
 	field = nameToField(field, *new(T))
+	var fieldType reflect.Type
+	if f, ok := reflect.TypeOf(*new(T)).FieldByName(field); ok {
+		fieldType = f.Type
+	}
 
 	return func(c *gin.Context) {
 		var request enum.GetRequestOptions
@@ -151,7 +187,14 @@ func GetFieldHandler[T orm.Model](idParam string, field string, opt *enum.GetOpt
 			return
 		}
 		request.Filters = c.QueryMap("filters")
-		options := buildQueryOptions(request, 1, opt.Omit)
+		if fieldType != nil && !queryFieldsAllowed(c, fieldType, request) {
+			return
+		}
+		if !queryGuarded(c, opt.Guards, request) {
+			return
+		}
+		request.Limit = nestedLimit(opt.Guards, request.Limit)
+		options := buildQueryOptions(request, request.Limit, opt.Omit)
 		var queryOpt enum.QueryOption
 		if opt.QueryOptionClosure != nil {
 			queryOpt = opt.QueryOptionClosure(c, request)
@@ -164,6 +207,9 @@ func GetFieldHandler[T orm.Model](idParam string, field string, opt *enum.GetOpt
 			ResponseError(c, CodeProcessFailed, err)
 			return
 		}
+		if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpGet, Parent: model, Field: field}) {
+			return
+		}
 
 		fieldValue := reflect.ValueOf(model).
 			Elem(). // because model is a pointer
@@ -200,15 +246,7 @@ func buildQueryOptions(request enum.GetRequestOptions, LimitMax int, omit []stri
 		options = append(options, service.OrderBy(request.OrderBy, request.Descending))
 	}
 
-	for FilterBy, FilterValue := range request.Filters {
-		if FilterBy != "" && FilterValue != "" {
-			options = append(options, service.FilterBy(FilterBy, FilterValue))
-		}
-	}
-
-	if len(request.FiltersAt) == 2 {
-		options = append(options, service.FilterAt(request.FiltersAt))
-	}
+	options = append(options, buildFilterOptions(request.Filters, request.FiltersAt)...)
 
 	for _, field := range request.Preload {
 		// logger.WithField("field", field).Debug("Preload field")
@@ -221,6 +259,48 @@ func buildQueryOptions(request enum.GetRequestOptions, LimitMax int, omit []stri
 	return options
 }
 
+// buildFilterOptions builds the WHERE conditions from filters[...] and
+// filters_at query parameters.
+func buildFilterOptions(filters map[string]string, filtersAt []string) []enum.QueryOption {
+	var options []enum.QueryOption
+	for filterBy, filterValue := range filters {
+		if filterBy != "" && filterValue != "" {
+			options = append(options, service.FilterBy(filterBy, filterValue))
+		}
+	}
+	if len(filtersAt) == 2 {
+		options = append(options, service.FilterAt(filtersAt))
+	}
+	return options
+}
+
+// buildBulkOptions builds the conditions for a bulk write (UpdateMany or
+// DeleteMany) from the request. It refuses to build an unscoped (table-wide)
+// write, unless the client explicitly confirms it by confirm=true,
+// or filters on the fields unreadable by the client (ErrFieldForbidden).
+func buildBulkOptions(c *gin.Context, modelType reflect.Type, closure enum.QueryOptionClosure) ([]enum.QueryOption, error) {
+	var request enum.GetRequestOptions
+	if err := c.ShouldBindQuery(&request); err != nil {
+		return nil, err
+	}
+	request.Filters = c.QueryMap("filters")
+	if err := checkQueryFields(c, modelType, request); err != nil {
+		return nil, err
+	}
+
+	options := buildFilterOptions(request.Filters, request.FiltersAt)
+	if len(options) == 0 {
+		if !request.Confirm {
+			return nil, ErrUnscopedBulkWrite
+		}
+		options = append(options, service.AllowGlobal())
+	}
+	if closure != nil {
+		options = append(options, closure(c, request))
+	}
+	return options, nil
+}
+
 // getModelByID gets idParam from url and get model from database
 func getModelByID[T orm.Model](c *gin.Context, idParam string, options ...enum.QueryOption) (*T, error) {
 	var model T
@@ -237,15 +317,7 @@ func getModelByID[T orm.Model](c *gin.Context, idParam string, options ...enum.Q
 }
 
 func getCount[T any](ctx context.Context, filters map[string]string, filterAt []string, option enum.QueryOption) (total int64, err error) {
-	var options []enum.QueryOption
-	for filterBy, filterValue := range filters {
-		if filterBy != "" && filterValue != "" {
-			options = append(options, service.FilterBy(filterBy, filterValue))
-		}
-	}
-	if len(filterAt) == 2 {
-		options = append(options, service.FilterAt(filterAt))
-	}
+	options := buildFilterOptions(filters, filterAt)
 	if option != nil {
 		options = append(options, option)
 	}
@@ -254,17 +326,7 @@ func getCount[T any](ctx context.Context, filters map[string]string, filterAt []
 }
 
 func getAssociationCount(ctx context.Context, model any, field string, filters map[string]string, filterAt []string, option enum.QueryOption) (total int64, err error) {
-	var options []enum.QueryOption
-
-	for filterBy, filterValue := range filters {
-		if filterBy != "" && filterValue != "" {
-			options = append(options, service.FilterBy(filterBy, filterValue))
-		}
-	}
-
-	if len(filterAt) == 2 {
-		options = append(options, service.FilterAt(filterAt))
-	}
+	options := buildFilterOptions(filters, filterAt)
 	if option != nil {
 		options = append(options, option)
 	}
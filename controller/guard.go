@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/service"
+	"strings"
+)
+
+// Default page sizes of the nested collections, see enum.QueryGuards.
+const (
+	defaultNestedLimit    = 10
+	defaultNestedLimitMax = 100
+)
+
+// QueryGuardError is the error of a request over a cap of the
+// enum.QueryGuards. It's responded with the guard, the limit and the
+// value of the request in the error body:
+//
+//	{ code: 400, error: "query_too_expensive", msg: "...", guard: "max_preloads", limit: 3, value: 5 }
+type QueryGuardError struct {
+	Guard string
+	Limit any
+	Value any
+}
+
+func (e *QueryGuardError) Error() string {
+	return fmt.Sprintf("%v: %s is %v, got %v", ErrQueryTooExpensive, e.Guard, e.Limit, e.Value)
+}
+
+func (e *QueryGuardError) Unwrap() error {
+	return ErrQueryTooExpensive
+}
+
+// Details returns the fields added to the error body.
+func (e *QueryGuardError) Details() gin.H {
+	return gin.H{"guard": e.Guard, "limit": e.Limit, "value": e.Value}
+}
+
+// checkQueryGuards checks the request against the guards.
+func checkQueryGuards(guards enum.QueryGuards, request enum.GetRequestOptions) error {
+	var preloads, depth int
+	for _, preload := range request.Preload {
+		if preload == "" {
+			continue
+		}
+		preloads++
+		if d := strings.Count(preload, ".") + 1; d > depth {
+			depth = d
+		}
+	}
+	filters := len(request.Filters)
+	if len(request.FiltersAt) == 2 {
+		filters++
+	}
+
+	for _, check := range []struct {
+		guard string
+		limit int
+		value int
+	}{
+		{"max_preloads", guards.MaxPreloads, preloads},
+		{"max_preload_depth", guards.MaxPreloadDepth, depth},
+		{"max_filters", guards.MaxFilters, filters},
+		{"max_offset", guards.MaxOffset, request.Offset},
+	} {
+		if check.limit > 0 && check.value > check.limit {
+			return &QueryGuardError{Guard: check.guard, Limit: check.limit, Value: check.value}
+		}
+	}
+	return nil
+}
+
+// queryGuarded checks the request by checkQueryGuards, and responds 400
+// if refused.
+func queryGuarded(c *gin.Context, guards enum.QueryGuards, request enum.GetRequestOptions) bool {
+	if err := checkQueryGuards(guards, request); err != nil {
+		logger.WithContext(c).WithError(err).
+			Warn("queryGuarded: query refused")
+		ResponseError(c, CodeBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// costGuarded refuses the list query of T estimated to cost more than
+// guards.MaxCost, responding 400. Queries are let through if the cost
+// can not be estimated.
+func costGuarded[T any](c *gin.Context, guards enum.QueryGuards, options []enum.QueryOption) bool {
+	if guards.MaxCost <= 0 {
+		return true
+	}
+	cost, err := service.EstimateCost[T](c, options...)
+	if err != nil {
+		if !errors.Is(err, service.ErrExplainUnsupported) {
+			logger.WithContext(c).WithError(err).
+				Warn("costGuarded: estimate cost failed, query let through")
+		}
+		return true
+	}
+	if cost > guards.MaxCost {
+		err := &QueryGuardError{Guard: "max_cost", Limit: guards.MaxCost, Value: cost}
+		logger.WithContext(c).WithError(err).
+			Warn("costGuarded: query refused")
+		ResponseError(c, CodeBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// nestedLimit returns the page size of a nested collection for the
+// limit parameter.
+func nestedLimit(guards enum.QueryGuards, limit int) int {
+	def, max := guards.NestedLimit, guards.NestedLimitMax
+	if def <= 0 {
+		def = defaultNestedLimit
+	}
+	if max <= 0 {
+		max = defaultNestedLimitMax
+	}
+	if def > max {
+		def = max
+	}
+	switch {
+	case limit <= 0:
+		return def
+	case limit > max:
+		return max
+	}
+	return limit
+}
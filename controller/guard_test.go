@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/enum"
+)
+
+func TestCheckQueryGuards(t *testing.T) {
+	guards := enum.QueryGuards{MaxPreloads: 2, MaxPreloadDepth: 2, MaxFilters: 2, MaxOffset: 100}
+	tests := []struct {
+		name    string
+		request enum.GetRequestOptions
+		guard   string
+		value   int
+	}{
+		{"within", enum.GetRequestOptions{Preload: []string{"A.B", "C"}, Filters: map[string]string{"a": "1"}, Offset: 100}, "", 0},
+		{"preloads", enum.GetRequestOptions{Preload: []string{"A", "B", "C"}}, "max_preloads", 3},
+		{"empty preloads", enum.GetRequestOptions{Preload: []string{"A", "", "B", ""}}, "", 0},
+		{"depth", enum.GetRequestOptions{Preload: []string{"A", "B.C.D"}}, "max_preload_depth", 3},
+		{"filters", enum.GetRequestOptions{Filters: map[string]string{"a": "1", "b": "2", "c": "3"}}, "max_filters", 3},
+		{"filters_at", enum.GetRequestOptions{Filters: map[string]string{"a": "1", "b": "2"}, FiltersAt: []string{"x", "y"}}, "max_filters", 3},
+		{"offset", enum.GetRequestOptions{Offset: 101}, "max_offset", 101},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQueryGuards(guards, tt.request)
+			if tt.guard == "" {
+				if err != nil {
+					t.Errorf("want nil, got %v", err)
+				}
+				return
+			}
+			var guardErr *QueryGuardError
+			if !errors.As(err, &guardErr) || guardErr.Guard != tt.guard || guardErr.Value != tt.value {
+				t.Errorf("want %s %d, got %v", tt.guard, tt.value, err)
+			}
+			if !errors.Is(err, ErrQueryTooExpensive) {
+				t.Errorf("%v is not ErrQueryTooExpensive", err)
+			}
+		})
+	}
+
+	if err := checkQueryGuards(enum.QueryGuards{}, tests[1].request); err != nil {
+		t.Errorf("zero guards should be unlimited, got %v", err)
+	}
+}
+
+type guardErrorBody struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+	Guard string `json:"guard"`
+	Limit int    `json:"limit"`
+	Value int    `json:"value"`
+}
+
+func TestQueryGuardedResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	request := enum.GetRequestOptions{Preload: []string{"A.B.C"}}
+	if queryGuarded(c, enum.QueryGuards{MaxPreloadDepth: 2}, request) {
+		t.Fatal("request over the guard is let through")
+	}
+	var body guardErrorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	want := guardErrorBody{http.StatusBadRequest, "query_too_expensive", "max_preload_depth", 2, 3}
+	if w.Code != http.StatusBadRequest || body != want {
+		t.Errorf("want %d %+v, got %d %s", http.StatusBadRequest, want, w.Code, w.Body)
+	}
+}
+
+func TestNestedLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		guards enum.QueryGuards
+		limit  int
+		want   int
+	}{
+		{"default", enum.QueryGuards{}, 0, defaultNestedLimit},
+		{"requested", enum.QueryGuards{}, 42, 42},
+		{"default max", enum.QueryGuards{}, 1000, defaultNestedLimitMax},
+		{"negative", enum.QueryGuards{}, -1, defaultNestedLimit},
+		{"NestedLimit", enum.QueryGuards{NestedLimit: 5}, 0, 5},
+		{"NestedLimitMax", enum.QueryGuards{NestedLimitMax: 20}, 50, 20},
+		{"NestedLimit over NestedLimitMax", enum.QueryGuards{NestedLimit: 50, NestedLimitMax: 20}, 0, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nestedLimit(tt.guards, tt.limit); got != tt.want {
+				t.Errorf("nestedLimit(%+v, %d) = %d, want %d", tt.guards, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthCheck checks a dependency of the service for the readiness,
+// e.g. the database, a cache or a downstream API.
+type HealthCheck struct {
+	Name    string
+	Check   func(ctx context.Context) error
+	Timeout time.Duration // of the Check, default 2s
+}
+
+// defaultHealthCheckTimeout is the timeout of HealthChecks without one.
+const defaultHealthCheckTimeout = 2 * time.Second
+
+// ReadinessError is the error of the failed HealthChecks.
+type ReadinessError struct {
+	Checks map[string]string // name => "ok" or the error
+}
+
+func (e *ReadinessError) Error() string {
+	var failed []string
+	for name, result := range e.Checks {
+		if result != "ok" {
+			failed = append(failed, name+": "+result)
+		}
+	}
+	sort.Strings(failed)
+	return fmt.Sprintf("%v: %s", ErrNotReady, strings.Join(failed, "; "))
+}
+
+func (e *ReadinessError) Unwrap() error {
+	return ErrNotReady
+}
+
+// Details are added to the error response body.
+func (e *ReadinessError) Details() gin.H {
+	return gin.H{"checks": e.Checks}
+}
+
+// HealthHandler handles the liveness probe:
+//
+//	GET /healthz
+//
+// It responds 200 as long as the process serves requests.
+//
+// Response:
+//   - 200 OK: { status: "ok" }
+func HealthHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ResponseSuccess(c, nil, gin.H{"status": "ok"})
+	}
+}
+
+// ReadinessHandler handles the readiness probe:
+//
+//	GET /readyz
+//
+// It runs the checks concurrently, each with its timeout, and responds 503
+// if any of them fails.
+//
+// Response:
+//   - 200 OK: { status: "ready", checks: { db: "ok", ... } }
+//   - 503 Service Unavailable: { msg: "not ready: db: ...", checks: { db: "...", ... } }
+func ReadinessHandler(checks ...HealthCheck) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		results, ok := runHealthChecks(c.Request.Context(), checks)
+		if !ok {
+			err := &ReadinessError{Checks: results}
+			logger.WithContext(c).WithError(err).Warn("ReadinessHandler: not ready")
+			ResponseError(c, CodeServiceUnavailable, err)
+			return
+		}
+		ResponseSuccess(c, nil, gin.H{"status": "ready", "checks": results})
+	}
+}
+
+// runHealthChecks runs the checks concurrently, and returns the results
+// by names, and whether all of them pass.
+func runHealthChecks(ctx context.Context, checks []HealthCheck) (map[string]string, bool) {
+	results := make(map[string]string, len(checks))
+	ok := true
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+			err := runHealthCheck(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				results[check.Name], ok = err.Error(), false
+			} else {
+				results[check.Name] = "ok"
+			}
+		}(check)
+	}
+	wg.Wait()
+	return results, ok
+}
+
+// runHealthCheck runs the check with its timeout. A check that does not
+// return in time fails with ErrHealthCheckTimeout, even if it ignores
+// the ctx.
+func runHealthCheck(ctx context.Context, check HealthCheck) error {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- check.Check(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ErrHealthCheckTimeout
+	}
+}
+
+var (
+	ErrNotReady           = errors.New("not ready")
+	ErrHealthCheckTimeout = errors.New("health check timeout")
+)
@@ -1,8 +1,10 @@
 package controller
 
 import (
+	"database/sql/driver"
 	"reflect"
 	"strings"
+	"time"
 )
 
 // nameToField converts name to the right field name in the structure.
@@ -38,3 +40,135 @@ func nameToField(name string, structure any) string {
 
 	return name
 }
+
+// jsonNameToField finds the field of the structure that is encoded as name
+// in JSON, i.e. by its json tag, or by its field name if there is no tag.
+// Fields of embedded structs (e.g. orm.BasicModel) are looked up as well.
+// It reports false if no such field.
+func jsonNameToField(name string, structure any) (string, bool) {
+	return jsonNameToFieldOf(name, reflect.TypeOf(structure))
+}
+
+func jsonNameToFieldOf(name string, reflectType reflect.Type) (string, bool) {
+	if reflectType.Kind() == reflect.Ptr {
+		reflectType = reflectType.Elem()
+	}
+	if reflectType.Kind() != reflect.Struct {
+		return "", false
+	}
+
+	for i := 0; i < reflectType.NumField(); i++ {
+		field := reflectType.Field(i)
+		tag, ok := jsonTag(field)
+		if !ok {
+			continue
+		}
+		if field.Anonymous && tag == "" {
+			if found, ok := jsonNameToFieldOf(name, field.Type); ok {
+				return found, true
+			}
+			continue
+		}
+		if tag == name || (tag == "" && strings.EqualFold(field.Name, name)) {
+			return field.Name, true
+		}
+	}
+	return "", false
+}
+
+// jsonColumn is a scalar (i.e. not an association) field of a model,
+// named as it is encoded in JSON.
+type jsonColumn struct {
+	Name  string // json name
+	Field string // struct field name
+	Index []int  // for reflect.Value.FieldByIndex
+}
+
+// jsonColumns lists the scalar fields of the structure in order,
+// including the fields of embedded structs.
+func jsonColumns(structure any) []jsonColumn {
+	return jsonColumnsOf(reflect.TypeOf(structure), nil)
+}
+
+func jsonColumnsOf(reflectType reflect.Type, index []int) []jsonColumn {
+	if reflectType.Kind() == reflect.Ptr {
+		reflectType = reflectType.Elem()
+	}
+	if reflectType.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var columns []jsonColumn
+	for i := 0; i < reflectType.NumField(); i++ {
+		field := reflectType.Field(i)
+		tag, ok := jsonTag(field)
+		if !ok {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			columns = append(columns, jsonColumnsOf(field.Type, fieldIndex)...)
+			continue
+		}
+		if !isScalarType(field.Type) {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		columns = append(columns, jsonColumn{Name: tag, Field: field.Name, Index: fieldIndex})
+	}
+	return columns
+}
+
+// columnValue gets the value of column in the model (a struct value):
+// nil, time.Time or a basic type (string, number, bool, []byte).
+func columnValue(model reflect.Value, column jsonColumn) any {
+	value := model.FieldByIndex(column.Index)
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if valuer, ok := value.Interface().(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil {
+			return nil
+		}
+		return v
+	}
+	return value.Interface()
+}
+
+// jsonTag returns the name in the json tag of the field,
+// reports false if the field is not encoded in JSON.
+func jsonTag(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return tag, tag != "-"
+}
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// isScalarType reports whether a field of type t is a column value,
+// rather than an association.
+func isScalarType(t reflect.Type) bool {
+	if t == timeType || t.Implements(valuerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return isScalarType(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return t.Elem().Kind() == reflect.Uint8
+	case reflect.Struct, reflect.Map, reflect.Interface, reflect.Chan, reflect.Func:
+		return false
+	}
+	return true
+}
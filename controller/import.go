@@ -0,0 +1,413 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+	"gorm.io/gorm"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ImportHandler handles
+//
+//	POST /T/import
+//
+// It creates models T from the rows of an uploaded csv or ndjson file
+// (a multipart form field "file"). Columns (csv headers or ndjson keys)
+// are mapped to the fields of T by json tags. Each row is validated and
+// pretreated (CreateOption.Pretreat) as POST /T does.
+//
+// With upsert=true, a row of an existing record (by the primary key)
+// updates the record as PUT /T/:id does: the row is applied onto the
+// stored record, pretreated by UpdateOption.Pretreat, authorized by
+// UpdateOption.Authorize, and only the columns in the row are written.
+//
+// Rows are committed in one transaction, or in transactions of
+// ImportOption.ChunkSize rows. A transaction containing any failed row
+// is rolled back as a whole, its other rows are still checked (each row
+// is written in a savepoint) to report all the failed rows.
+//
+// QueryOptions (See ImportRequestOptions for more details):
+//
+//	format, dry_run, upsert.
+//
+// Response:
+//   - 200 OK: { imported: n }
+//   - 400 Bad Request: { error: "request band failed" }
+//   - 403 Forbidden: { imported: n, errors: [...] }  // any row not allowed to write
+//   - 422 Unprocessable Entity: { imported: n, errors: [{line: 1, error: "..."}] }
+func ImportHandler[T orm.Model](createOpt *enum.CreateOption, updateOpt *enum.UpdateOption, opt *enum.ImportOption) gin.HandlerFunc {
+	columns := jsonColumns(*new(T))
+
+	return func(c *gin.Context) {
+		var request enum.ImportRequestOptions
+		if err := c.ShouldBindQuery(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ImportHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ImportHandler: read file failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		format := request.Format
+		if format == "" {
+			format = strings.TrimPrefix(filepath.Ext(fileHeader.Filename), ".")
+		}
+		newReader, ok := importFormats[format]
+		if !ok {
+			ResponseError(c, CodeBadRequest, fmt.Errorf("%w: %s", ErrUnknownFormat, format))
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		defer file.Close()
+		reader, err := newReader(file, columns, reflect.TypeOf(*new(T)))
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("ImportHandler: read file failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+
+		var (
+			imported  int
+			rowErrors []gin.H
+			forbidden bool // any row refused by the permissions
+			eof       bool
+		)
+		for !eof && err == nil {
+			var (
+				created int
+				failed  bool
+			)
+			err = service.Transaction(c, func(ctx context.Context) error {
+				for n := 0; opt.ChunkSize <= 0 || n < opt.ChunkSize; n++ {
+					line, row, err := reader.Next()
+					if err == io.EOF {
+						eof = true
+						break
+					}
+					if err == nil {
+						// each row in a savepoint: a failed statement
+						// does not abort the transaction (on postgres),
+						// so the rows after it are still checked.
+						err = service.Transaction(ctx, func(ctx context.Context) error {
+							model, fields, err := importRow[T](c, ctx, row, request.Upsert, createOpt, updateOpt)
+							if err != nil || failed {
+								// no more writes after a failure, rows are validated only.
+								return err
+							}
+							mode := service.IfNotExist()
+							if request.Upsert {
+								// the columns absent from the row are kept
+								mode = service.Upsert(fields...)
+							}
+							return service.Create(ctx, model, createOpt, mode)
+						})
+					} else if !errors.As(err, new(rowError)) {
+						return err
+					}
+					if err != nil {
+						failed = true
+						forbidden = forbidden || errors.Is(err, ErrForbidden) || errors.Is(err, ErrFieldForbidden)
+						rowErrors = append(rowErrors, gin.H{"line": line, "error": err.Error()})
+						continue
+					}
+					created++
+				}
+				if failed || request.DryRun {
+					return errImportRollback
+				}
+				return nil
+			})
+			if err == errImportRollback {
+				err = nil
+				if failed {
+					continue
+				}
+			}
+			if err == nil {
+				// in dry run: rows that would be imported
+				imported += created
+			}
+		}
+
+		addition := gin.H{"imported": imported, "dryRun": request.DryRun}
+		if err != nil || len(rowErrors) != 0 {
+			code := CodeProcessFailed
+			if err == nil {
+				err = ErrImportFailed
+				if forbidden {
+					code, err = CodeForbidden, fmt.Errorf("%w: %v", ErrForbidden, err)
+				}
+			}
+			logger.WithContext(c).WithError(err).
+				WithField("imported", imported).
+				WithField("failed", len(rowErrors)).
+				Warn("ImportHandler: import failed")
+			body := ErrorResponseBody(err, code)
+			body["errors"] = rowErrors
+			for k, v := range addition {
+				body[k] = v
+			}
+			c.JSON(code, body)
+			return
+		}
+		ResponseSuccess(c, nil, addition)
+	}
+}
+
+// errImportRollback rolls back the import transaction.
+var errImportRollback = errors.New("import: rollback")
+
+// importRow decodes a row into a model T to import, and checks that the
+// client can write it: as a new record, or if upsert and the record
+// exists, as an update of the stored record.
+func importRow[T orm.Model](c *gin.Context, ctx context.Context, row []byte, upsert bool, createOpt *enum.CreateOption, updateOpt *enum.UpdateOption) (*T, []string, error) {
+	model, fields, err := decodeRow[T](c, row, nil, createOpt.Pretreat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, id := (*model).Identity(); upsert && id != nil && !reflect.ValueOf(id).IsZero() {
+		var old T
+		err := service.GetByID[T](ctx, id, &old)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, err
+		}
+		if err == nil {
+			base := old
+			model, fields, err = decodeRow[T](c, row, &base, updateOpt.Pretreat)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := checkAuthorize(c, updateOpt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpUpdate, Record: &old}); err != nil {
+				return nil, nil, err
+			}
+			return model, fields, checkWritable(c, model, &old)
+		}
+	}
+
+	if err := checkAuthorize(c, createOpt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpCreate, Record: model}); err != nil {
+		return nil, nil, err
+	}
+	return model, fields, checkWritable(c, model, nil)
+}
+
+// decodeRow decodes a row (JSON object) into model T (a copy of the base
+// if not nil), validates and pretreats it. It returns the names of the
+// struct fields present in the row as well.
+func decodeRow[T any](c *gin.Context, row []byte, base *T, pretreat enum.Pretreat) (*T, []string, error) {
+	var model T
+	if base != nil {
+		model = *base
+	}
+	decoder := json.NewDecoder(bytes.NewReader(row))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&model); err != nil {
+		return nil, nil, err
+	}
+	if err := binding.Validator.ValidateStruct(&model); err != nil {
+		return nil, nil, err
+	}
+	if pretreat != nil {
+		res, err := pretreat(c, model)
+		if err != nil {
+			return nil, nil, err
+		}
+		model = res.(T)
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(row, &values); err != nil {
+		return nil, nil, err
+	}
+	fields := make([]string, 0, len(values))
+	for name := range values {
+		if field, ok := jsonNameToField(name, &model); ok {
+			fields = append(fields, field)
+		}
+	}
+	return &model, fields, nil
+}
+
+// region importers
+
+// importReader reads rows from a file of some format.
+type importReader interface {
+	// Next reads the next row as a JSON object, and the line number of the
+	// row in the file. It returns io.EOF if there are no more rows, and a
+	// rowError if the row is malformed (which fails the row only).
+	Next() (line int, row []byte, err error)
+}
+
+// rowError is an error that fails a row, but not the whole import.
+type rowError struct {
+	error
+}
+
+var importFormats = map[string]func(r io.Reader, columns []jsonColumn, model reflect.Type) (importReader, error){
+	"csv":    newCSVImporter,
+	"ndjson": newNDJSONImporter,
+	"jsonl":  newNDJSONImporter,
+}
+
+// csvImporter converts each csv record into a JSON object by the header.
+// Empty cells are omitted, i.e. leaving the zero values. The cells of
+// number and bool columns must be numbers and bools (as strconv parses
+// them), the others are quoted as JSON strings.
+type csvImporter struct {
+	r        *csv.Reader
+	columns  [][]byte       // json encoded column names
+	literals []reflect.Kind // reflect.Bool or reflect.Float64 for the JSON literal columns, or reflect.String
+}
+
+func newCSVImporter(r io.Reader, columns []jsonColumn, model reflect.Type) (importReader, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	importer := &csvImporter{r: reader}
+	for _, name := range header {
+		column, ok := findColumn(columns, strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownField, name)
+		}
+		encoded, _ := json.Marshal(column.Name)
+		importer.columns = append(importer.columns, encoded)
+		importer.literals = append(importer.literals, jsonLiteralKind(model.FieldByIndex(column.Index).Type))
+	}
+	return importer, nil
+}
+
+func (i *csvImporter) Next() (int, []byte, error) {
+	record, err := i.r.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return parseErr.StartLine, nil, rowError{err}
+		}
+		return 0, nil, err
+	}
+	line, _ := i.r.FieldPos(0)
+
+	var row bytes.Buffer
+	row.WriteByte('{')
+	for j, cell := range record {
+		if cell == "" {
+			continue
+		}
+		if row.Len() > 1 {
+			row.WriteByte(',')
+		}
+		value, err := i.cellValue(j, cell)
+		if err != nil {
+			return line, nil, rowError{err}
+		}
+		row.Write(i.columns[j])
+		row.WriteByte(':')
+		row.Write(value)
+	}
+	row.WriteByte('}')
+	return line, row.Bytes(), nil
+}
+
+// cellValue encodes the cell of column j as a JSON value. Cells of the
+// literal columns are checked, not to inject JSON into the row.
+func (i *csvImporter) cellValue(j int, cell string) ([]byte, error) {
+	switch i.literals[j] {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(cell))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %q is not a bool", ErrInvalidCell, i.columns[j], cell)
+		}
+		return strconv.AppendBool(nil, b), nil
+	case reflect.Float64:
+		cell = strings.TrimSpace(cell)
+		// ParseFloat refuses the other JSON, json.Valid the numbers not
+		// in JSON (e.g. Inf or 0x1F).
+		if _, err := strconv.ParseFloat(cell, 64); err != nil || !json.Valid([]byte(cell)) {
+			return nil, fmt.Errorf("%w: %s: %q is not a number", ErrInvalidCell, i.columns[j], cell)
+		}
+		return []byte(cell), nil
+	}
+	return json.Marshal(cell)
+}
+
+// ndjsonImporter reads a JSON object per line, blank lines are skipped.
+type ndjsonImporter struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONImporter(r io.Reader, _ []jsonColumn, _ reflect.Type) (importReader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &ndjsonImporter{scanner: scanner}, nil
+}
+
+func (i *ndjsonImporter) Next() (int, []byte, error) {
+	for i.scanner.Scan() {
+		i.line++
+		row := bytes.TrimSpace(i.scanner.Bytes())
+		if len(row) != 0 {
+			return i.line, row, nil
+		}
+	}
+	if err := i.scanner.Err(); err != nil {
+		return i.line, nil, err
+	}
+	return i.line, nil, io.EOF
+}
+
+func findColumn(columns []jsonColumn, name string) (jsonColumn, bool) {
+	for _, column := range columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return jsonColumn{}, false
+}
+
+// jsonLiteralKind returns how values of type t are encoded in JSON:
+// reflect.Bool for booleans, reflect.Float64 for numbers, and
+// reflect.String for the others (as strings).
+func jsonLiteralKind(t reflect.Type) reflect.Kind {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return reflect.Bool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return reflect.String
+}
+
+// endregion importers
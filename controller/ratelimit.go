@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/ratelimit"
+	"math"
+	"strconv"
+	"time"
+)
+
+// RateLimitMiddleware limits the requests by the limiter. It sets the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and
+// aborts the requests out of tokens with 429 and the Retry-After header.
+// A nil limiter limits nothing.
+//
+// Requests are let through if the Store fails, with a warning logged.
+func RateLimitMiddleware(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+		result, ok, err := limiter.Take(c)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("RateLimitMiddleware: take token failed, request let through")
+		}
+		if !ok {
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", ceilSeconds(result.Reset))
+		if !result.Allowed {
+			logger.WithContext(c).
+				WithField("retryAfter", result.RetryAfter).
+				Info("RateLimitMiddleware: too many requests")
+			c.Header("Retry-After", ceilSeconds(result.RetryAfter))
+			c.Abort()
+			ResponseError(c, CodeTooManyRequests, ErrTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
+
+// ceilSeconds formats d in whole seconds, rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
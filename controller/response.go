@@ -4,18 +4,37 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/concurrency"
+	"gorm.io/gorm"
 	"net/http"
 	"reflect"
+	"strings"
 )
 
 // ErrorResponseBody builds the error response body:
 //
-//	{ error: "error message" }
-func ErrorResponseBody(err error) gin.H {
-	return gin.H{
-		"code": http.StatusBadRequest,
-		"msg":  err.Error(),
+//	{ code: 504, error: "timeout", msg: "error message" }
+//
+// where code is the HTTP status (400 if not given), and error is the
+// stable, machine-readable code of the error (see ErrorCode).
+// Errors with details (e.g. QueryGuardError) add them to the body.
+func ErrorResponseBody(err error, status ...int) gin.H {
+	code := http.StatusBadRequest
+	if len(status) > 0 {
+		code = status[0]
+	}
+	body := gin.H{
+		"code":  code,
+		"error": ErrorCode(err, code),
+		"msg":   err.Error(),
+	}
+	var detailed interface{ Details() gin.H }
+	if errors.As(err, &detailed) {
+		for k, v := range detailed.Details() {
+			body[k] = v
+		}
 	}
+	return body
 }
 
 // SuccessResponseBody builds the success response body:
@@ -45,6 +64,40 @@ func SuccessResponseBody(model any, addition ...gin.H) gin.H {
 	return res
 }
 
+// errorCodes are the codes of the errors for ErrorCode, in order.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrTimeout, "timeout"},
+	{ErrTooManyRequests, "rate_limited"},
+	{concurrency.ErrQueueFull, "overloaded"},
+	{concurrency.ErrQueueTimeout, "overloaded"},
+	{ErrNotReady, "not_ready"},
+	{ErrQueryTooExpensive, "query_too_expensive"},
+	{ErrFieldForbidden, "field_forbidden"},
+	{ErrForbidden, "forbidden"},
+	{ErrUnscopedBulkWrite, "unscoped_bulk_write"},
+	{ErrMissingTenant, "missing_tenant"},
+	{gorm.ErrRecordNotFound, "not_found"},
+}
+
+// ErrorCode returns the stable, machine-readable code of err for the
+// clients, e.g. "timeout" for ErrTimeout. Errors without a code are coded
+// by the HTTP status, e.g. "unprocessable_entity" for 422.
+func ErrorCode(err error, status int) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ToLower(strings.ReplaceAll(strings.ReplaceAll(text, "-", "_"), " ", "_"))
+}
+
 // get a human-readable model name
 func getResponseModelName(model any) string {
 	var reflectType = reflect.TypeOf(model)
@@ -74,21 +127,35 @@ func getResponseModelName(model any) string {
 	}
 }
 
-// ResponseError writes an error response to client in JSON.
+// ResponseError writes an error response to client in JSON, with the
+// code as the HTTP status (and the code in the body).
+// Errors caused by the request timeout (see TimeoutMiddleware) are
+// responded 504 as ErrTimeout, whatever the code is.
 func ResponseError(c *gin.Context, code int, err error) {
-	c.JSON(code, ErrorResponseBody(err))
+	if timedOut(c, err) {
+		code, err = CodeGatewayTimeout, timeoutError(err)
+	}
+	c.JSON(code, ErrorResponseBody(err, code))
 }
 
 // ResponseSuccess writes a success response to client in JSON.
+// Fields unreadable by the roles of the request are removed
+// (see FieldPermissions).
 func ResponseSuccess(c *gin.Context, model any, addition ...gin.H) {
-	c.JSON(http.StatusOK, SuccessResponseBody(model, addition...))
+	c.JSON(http.StatusOK, redactView(c, SuccessResponseBody(model, addition...)))
 }
 
 const (
 	CodeSuccess       = http.StatusOK
 	CodeNotFound      = http.StatusNotFound
 	CodeBadRequest    = http.StatusBadRequest
+	CodeUnauthorized  = http.StatusUnauthorized
+	CodeForbidden     = http.StatusForbidden
 	CodeProcessFailed = http.StatusUnprocessableEntity
+
+	CodeTooManyRequests    = http.StatusTooManyRequests
+	CodeServiceUnavailable = http.StatusServiceUnavailable
+	CodeGatewayTimeout     = http.StatusGatewayTimeout
 )
 
 var (
@@ -96,4 +163,33 @@ var (
 	ErrMissingID       = errors.New("missing id")
 	ErrMissingParentID = errors.New("missing parent id")
 	ErrUpdateID        = errors.New("id can not be updated")
+	ErrUnknownField    = errors.New("unknown field")
+	ErrUnknownFormat   = errors.New("unknown format")
+	ErrImportFailed    = errors.New("import failed")
+	ErrInvalidCell     = errors.New("invalid csv cell")
+
+	ErrUnscopedBulkWrite = errors.New("bulk write requires filters or confirm=true")
+
+	ErrBadEventID    = errors.New("bad event id")
+	ErrStreamDropped = errors.New("client is too slow, reconnect to resume")
+	ErrShuttingDown  = errors.New("server is shutting down, reconnect to resume")
+
+	ErrUnknownMessage   = errors.New("unknown message type")
+	ErrMissingSub       = errors.New("missing sub")
+	ErrRecordNotVisible = errors.New("record not found")
+
+	ErrBadSyncToken = errors.New("bad sync token")
+
+	ErrForbidden = errors.New("forbidden")
+
+	ErrMissingTenant  = errors.New("missing tenant")
+	ErrTenantMismatch = errors.New("not the tenant of the principal")
+
+	ErrFieldForbidden = errors.New("field forbidden")
+
+	ErrTooManyRequests = errors.New("too many requests")
+
+	ErrTimeout = errors.New("request timeout")
+
+	ErrQueryTooExpensive = errors.New("query too expensive")
 )
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	"sync"
+)
+
+// Shutdown ends the long-lived requests (GET /T/stream and /T/ws) when
+// the server shuts down: http.Server.Shutdown waits for the in-flight
+// requests, which the streams never finish by themselves, and does not
+// wait for the hijacked WebSocket connections at all.
+//
+// Serve the requests with the context of Shutdown.Context (as the
+// http.Server.BaseContext), and call Start when the shutdown starts
+// (http.Server.RegisterOnShutdown): the streams return, and the
+// WebSocket sessions are closed (1001 going away). Wait waits for the
+// sessions before the database is closed. app.App.Run does all of it.
+type Shutdown struct {
+	ctx    context.Context // done when the shutdown starts
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	sessions sync.WaitGroup
+}
+
+// shutdownKey is the key of the Shutdown in the request context.
+type shutdownKey struct{}
+
+// NewShutdown returns a Shutdown not started.
+func NewShutdown() *Shutdown {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Shutdown{ctx: ctx, cancel: cancel}
+}
+
+// Context returns the ctx carrying the Shutdown, to serve the requests
+// with. The requests are not canceled by the Shutdown, so the ones in
+// flight are drained as usual.
+func (s *Shutdown) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, shutdownKey{}, s)
+}
+
+// Start starts the shutdown: the streams return, and the WebSocket
+// sessions are closed. New sessions are refused.
+func (s *Shutdown) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancel()
+}
+
+// Wait waits for the WebSocket sessions to end after Start, or for the
+// ctx to be done.
+func (s *Shutdown) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// done returns a channel closed when the shutdown starts, or nil (never
+// closed) without a Shutdown.
+func (s *Shutdown) done() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.ctx.Done()
+}
+
+// track adds a WebSocket session to wait for, which calls the release
+// when it ends. It returns false if the shutdown has started.
+func (s *Shutdown) track() (release func(), ok bool) {
+	if s == nil {
+		return func() {}, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return nil, false
+	}
+	s.sessions.Add(1)
+	return s.sessions.Done, true
+}
+
+// shutdownFrom returns the Shutdown the request is served with, or nil.
+func shutdownFrom(ctx context.Context) *Shutdown {
+	s, _ := ctx.Value(shutdownKey{}).(*Shutdown)
+	return s
+}
@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/changelog"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// StreamHandler handles
+//
+//	GET /T/stream
+//
+// It pushes the changes of models T to the client as Server-Sent Events:
+//
+//	id: 42                       // sequence number in the change log
+//	event: updated               // created, updated, deleted...
+//	data: {"id":"1","T":{...}}   // T is omitted for deletes
+//
+// Records are read as GET /T/:id reads them (with the Omit, Pretreat and
+// QueryOptionClosure of ListOption). Only changes of records matched by
+// the filters are pushed. Deletes are pushed if the deleted record was
+// matched by the closure as well: with a closure, only the soft deleted
+// ones can be matched.
+//
+// A client reconnecting with the Last-Event-ID header (or last_event_id
+// query) gets the changes it missed first. A client falling behind more
+// than StreamOption.Buffer events is dropped with an "error" event, and it
+// may reconnect to resume.
+//
+// QueryOptions (See GetRequestOptions for more details):
+//
+//	filters, filters_at, preload.
+//
+// Response:
+//   - 200 OK: text/event-stream
+//   - 400 Bad Request: { error: "request band failed" }
+//   - 403 Forbidden: { error: "forbidden" }
+func StreamHandler[T orm.Model](listOpt *enum.ListOption, opt *enum.StreamOption) gin.HandlerFunc {
+	modelName := changelog.ModelName[T]()
+	buffer := opt.Buffer
+	if buffer <= 0 {
+		buffer = 64
+	}
+	heartbeat := opt.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+
+	return func(c *gin.Context) {
+		var request enum.GetRequestOptions
+		if err := c.ShouldBindQuery(&request); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("StreamHandler: bind request failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		request.Filters = c.QueryMap("filters")
+		if listOpt.Pretreat != nil {
+			var err error
+			request, err = listOpt.Pretreat(c, request)
+			if err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("StreamHandler:Pretreat err")
+				ResponseError(c, CodeBadRequest, err)
+				return
+			}
+		}
+		if !authorize(c, listOpt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpList}) {
+			return
+		}
+		if !queryFieldsAllowed(c, reflect.TypeOf(*new(T)), request) {
+			return
+		}
+		lastSeq, resume, err := lastEventID(c)
+		if err != nil {
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		options, closure := changeQueryOptions(c, request, listOpt.Omit, listOpt.QueryOptionClosure)
+
+		// subscribe before replaying, not to miss anything in between.
+		subscription := changelog.Subscribe(modelName, buffer)
+		defer subscription.Close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(CodeSuccess)
+		c.Writer.Flush()
+
+		send := func(entry changelog.Entry) {
+			lastSeq = entry.Seq
+			view, ok := loadChange[T](c, entry, request.Filters, options, closure)
+			if !ok {
+				return
+			}
+			c.Render(-1, sse.Event{
+				Id:    strconv.FormatUint(entry.Seq, 10),
+				Event: string(entry.Kind),
+				Data:  redactView(c, view),
+			})
+			c.Writer.Flush()
+		}
+
+		for resume {
+			entries, err := changelog.Since(c, modelName, lastSeq, 100)
+			if err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("StreamHandler: replay failed")
+				c.SSEvent("error", ErrorResponseBody(err, CodeProcessFailed))
+				return
+			}
+			for _, entry := range entries {
+				send(entry)
+			}
+			resume = len(entries) == 100
+		}
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		shutdown := shutdownFrom(c.Request.Context()).done()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-shutdown: // the client reconnects to another instance, resuming
+				return
+			case <-ticker.C:
+				_, _ = fmt.Fprint(c.Writer, ": ping\n\n")
+				c.Writer.Flush()
+			case entry, ok := <-subscription.C:
+				if !ok {
+					if subscription.Dropped() {
+						logger.WithContext(c).Warn("StreamHandler: client dropped for being slow")
+						c.SSEvent("error", ErrorResponseBody(ErrStreamDropped, CodeServiceUnavailable))
+						c.Writer.Flush()
+					}
+					return
+				}
+				if entry.Seq > lastSeq { // not replayed
+					send(entry)
+				}
+			}
+		}
+	}
+}
+
+// lastEventID reads the Last-Event-ID header or the last_event_id query.
+func lastEventID(c *gin.Context) (seq uint64, ok bool, err error) {
+	id := c.GetHeader("Last-Event-ID")
+	if id == "" {
+		id = c.Query("last_event_id")
+	}
+	if id == "" {
+		return 0, false, nil
+	}
+	seq, err = strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: %s", ErrBadEventID, id)
+	}
+	return seq, true, nil
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/orm"
+	"net"
+	"strings"
+)
+
+// TenantResolver resolves the tenant id of a request,
+// empty if it's not given.
+type TenantResolver func(c *gin.Context) string
+
+// TenantFromHeader resolves the tenant from the request header,
+// e.g. TenantFromHeader("X-Tenant-Id").
+func TenantFromHeader(header string) TenantResolver {
+	return func(c *gin.Context) string {
+		return c.GetHeader(header)
+	}
+}
+
+// TenantFromSubdomain resolves the tenant from the subdomain of the
+// domain, e.g. with TenantFromSubdomain("example.com"), the tenant of
+// acme.example.com is acme.
+func TenantFromSubdomain(domain string) TenantResolver {
+	suffix := "." + strings.TrimPrefix(domain, ".")
+	return func(c *gin.Context) string {
+		host := c.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.HasSuffix(host, suffix) {
+			return ""
+		}
+		return strings.TrimSuffix(host, suffix)
+	}
+}
+
+// principalTenantKey is the key of the tenant of the principal in
+// gin.Context.Keys.
+const principalTenantKey = "crud/controller.PrincipalTenant"
+
+// SetPrincipalTenant sets the tenant the authenticated principal of the
+// request belongs to (auth.SetPrincipal does it). TenantMiddleware refuses
+// the requests to other tenants.
+func SetPrincipalTenant(c *gin.Context, tenantID string) {
+	c.Set(principalTenantKey, tenantID)
+}
+
+// TenantMiddleware resolves the tenant of requests by the resolvers (the
+// first non-empty one wins), and puts it into the context, so that the
+// services work on the DB of the tenant (see orm.EnableTenancy).
+//
+// Requests without tenant are refused (400) if required, otherwise they
+// work on the base DB. Unknown tenants are refused (404).
+//
+// The header and the subdomain are given by the client: if the request is
+// authenticated (see SetPrincipalTenant), a tenant other than the one of
+// the principal is refused (403). So the auth middleware must run before.
+func TenantMiddleware(required bool, resolvers ...TenantResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tenantID string
+		for _, resolve := range resolvers {
+			if tenantID = resolve(c); tenantID != "" {
+				break
+			}
+		}
+		if principalTenant, ok := c.Get(principalTenantKey); ok && tenantID != "" && tenantID != principalTenant {
+			err := fmt.Errorf("%w: %v %q", ErrForbidden, ErrTenantMismatch, tenantID)
+			logger.WithContext(c).WithError(err).
+				WithField("principalTenant", principalTenant).
+				Warn("TenantMiddleware: not the tenant of the principal")
+			c.Abort()
+			ResponseError(c, CodeForbidden, err)
+			return
+		}
+		if tenantID == "" {
+			if required {
+				logger.WithContext(c).Warn("TenantMiddleware: missing tenant")
+				c.Abort()
+				ResponseError(c, CodeBadRequest, ErrMissingTenant)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		ok, err := orm.TenantExists(c, tenantID)
+		if err != nil || !ok {
+			if err == nil {
+				err = orm.ErrUnknownTenant
+			}
+			logger.WithContext(c).WithError(err).
+				WithField("tenant", tenantID).
+				Warn("TenantMiddleware: bad tenant")
+			code := CodeNotFound
+			if errors.Is(err, orm.ErrBadTenantID) {
+				code = CodeBadRequest
+			}
+			c.Abort()
+			ResponseError(c, code, err)
+			return
+		}
+		c.Set(orm.TenantKey, tenantID)
+		c.Request = c.Request.WithContext(orm.WithTenant(c.Request.Context(), tenantID))
+		c.Next()
+	}
+}
+
+// ListTenantsHandler handles
+//
+//	GET /tenants
+//
+// Response:
+//   - 200 OK: { Tenants: [...] }
+//   - 422 Unprocessable Entity: { error: "..." }
+func ListTenantsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenants, err := orm.Tenants(c)
+		if err != nil {
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		ResponseSuccess(c, tenants)
+	}
+}
+
+// ProvisionTenantHandler handles
+//
+//	POST /tenants
+//
+// creates the tenant, or re-runs its migrations if it exists.
+//
+// Request body:
+//   - { id: "acme" }
+//
+// Response:
+//   - 200 OK: { Tenant: {...} }
+//   - 400 Bad Request: { error: "bad tenant id" }
+//   - 422 Unprocessable Entity: { error: "..." }
+func ProvisionTenantHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tenant orm.Tenant
+		if err := c.ShouldBindJSON(&tenant); err != nil {
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		if err := orm.ProvisionTenant(c, tenant.ID); err != nil {
+			code := CodeProcessFailed
+			if errors.Is(err, orm.ErrBadTenantID) {
+				code = CodeBadRequest
+			}
+			ResponseError(c, code, err)
+			return
+		}
+		ResponseSuccess(c, tenant)
+	}
+}
+
+// DropTenantHandler handles
+//
+//	DELETE /tenants/:idParam
+//
+// drops the tenant and all its data.
+//
+// Response:
+//   - 200 OK: { deleted: true }
+//   - 404 Not Found: { error: "unknown tenant" }
+//   - 422 Unprocessable Entity: { error: "..." }
+func DropTenantHandler(idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := orm.DropTenant(c, c.Param(idParam))
+		switch {
+		case errors.Is(err, orm.ErrUnknownTenant) || errors.Is(err, orm.ErrBadTenantID):
+			ResponseError(c, CodeNotFound, err)
+		case err != nil:
+			ResponseError(c, CodeProcessFailed, err)
+		default:
+			ResponseSuccess(c, nil, gin.H{"deleted": true})
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTenantMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if tenant, ok := c.Request.Header["X-Principal-Tenant"]; ok {
+			SetPrincipalTenant(c, tenant[0])
+		}
+	})
+	r.Use(TenantMiddleware(false, TenantFromHeader("X-Tenant-Id")))
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+
+	request := func(header ...string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := request("X-Principal-Tenant", "a", "X-Tenant-Id", "b"); code != http.StatusForbidden {
+		t.Errorf("principal of a to tenant b: want 403, got %d", code)
+	}
+	if code := request("X-Principal-Tenant", "", "X-Tenant-Id", "b"); code != http.StatusForbidden {
+		t.Errorf("principal of no tenant to tenant b: want 403, got %d", code)
+	}
+	if code := request("X-Principal-Tenant", "a"); code != http.StatusOK {
+		t.Errorf("principal without tenant resolved: want 200, got %d", code)
+	}
+	// the tenant of the principal is allowed: refused later for the
+	// tenancy is not enabled in the test, but not 403.
+	if code := request("X-Principal-Tenant", "a", "X-Tenant-Id", "a"); code == http.StatusForbidden {
+		t.Errorf("principal of a to tenant a: got 403")
+	}
+}
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+// timeoutBaseKey is the key of the request context before any timeout,
+// in gin.Context.Keys.
+const timeoutBaseKey = "crud/controller.TimeoutBase"
+
+// TimeoutMiddleware sets a deadline of timeout to the request context.
+// The services see it through the ctx (see orm.QueryContext), so the
+// queries running past it are cancelled, and the request is responded
+// 504 Gateway Timeout.
+//
+// A later TimeoutMiddleware replaces the timeout of the earlier one
+// (e.g. the per-operation Timeout replaces the router default), and a
+// negative timeout removes it. Zero does nothing.
+func TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout == 0 || c.Request == nil {
+			c.Next()
+			return
+		}
+		base, ok := c.Value(timeoutBaseKey).(context.Context)
+		if !ok {
+			base = c.Request.Context()
+			c.Set(timeoutBaseKey, base)
+		}
+		if timeout < 0 {
+			c.Request = c.Request.WithContext(base)
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(base, timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			logger.WithContext(c).WithField("timeout", timeout).
+				WithField("path", c.FullPath()).
+				Warn("TimeoutMiddleware: request timed out")
+			if !c.Writer.Written() {
+				ResponseError(c, CodeGatewayTimeout, ErrTimeout)
+			}
+		}
+	}
+}
+
+// timedOut reports whether the err is caused by the timeout of the
+// request, i.e. the deadline set by TimeoutMiddleware is exceeded.
+func timedOut(c *gin.Context, err error) bool {
+	if errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	return c.Request != nil && errors.Is(c.Request.Context().Err(), context.DeadlineExceeded)
+}
+
+// timeoutError wraps err as ErrTimeout.
+func timeoutError(err error) error {
+	if errors.Is(err, ErrTimeout) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrTimeout, err)
+}
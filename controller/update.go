@@ -1,12 +1,16 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/cast"
 	"github.com/tqrj/cd/enum"
 	"github.com/tqrj/cd/log"
 	"github.com/tqrj/cd/orm"
 	"github.com/tqrj/cd/service"
+	"reflect"
 )
 
 // UpdateHandler handles
@@ -21,6 +25,7 @@ import (
 // Response:
 //   - 200 OK: { updated: true }
 //   - 400 Bad Request: { error: "missing id or bind fields failed" }
+//   - 403 Forbidden: { error: "forbidden" }
 //   - 404 Not Found: { error: "record with id not found" }
 //   - 422 Unprocessable Entity: { error: "update process failed" }
 func UpdateHandler[T orm.Model](idParam string, opt *enum.UpdateOption) gin.HandlerFunc {
@@ -47,6 +52,9 @@ func UpdateHandler[T orm.Model](idParam string, opt *enum.UpdateOption) gin.Hand
 			ResponseError(c, CodeNotFound, err)
 			return
 		}
+		if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpUpdate, Record: &model}) {
+			return
+		}
 
 		var updatedModel = model
 		if err := c.ShouldBindJSON(&updatedModel); err != nil {
@@ -79,6 +87,13 @@ func UpdateHandler[T orm.Model](idParam string, opt *enum.UpdateOption) gin.Hand
 			return
 		}
 
+		if err := checkWritable(c, &updatedModel, &model); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("UpdateHandler: write forbidden field")
+			ResponseError(c, CodeForbidden, err)
+			return
+		}
+
 		_, err := service.Update(c, &updatedModel, opt)
 		if err != nil {
 			logger.WithContext(c).WithError(err).
@@ -89,3 +104,113 @@ func UpdateHandler[T orm.Model](idParam string, opt *enum.UpdateOption) gin.Hand
 		ResponseSuccess(c, &updatedModel)
 	}
 }
+
+// UpdateManyHandler handles
+//
+//	PATCH /T?filters[field]=value
+//
+// Updates the given fields of all models T matched by the filters in a
+// single UPDATE statement. Requests without any filter are refused unless
+// confirm=true is given.
+//
+// Request body:
+//   - {"field": "new_value", ...}   // fields to update
+//
+// Response:
+//   - 200 OK: { rowsAffected: n }
+//   - 400 Bad Request: { error: "no filters or bind fields failed" }
+//   - 403 Forbidden: { error: "forbidden" }
+//   - 422 Unprocessable Entity: { error: "update process failed" }
+func UpdateManyHandler[T orm.Model](opt *enum.UpdateOption) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		options, err := buildBulkOptions(c, reflect.TypeOf(*new(T)), opt.QueryOptionClosure)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("UpdateManyHandler: build query failed")
+			code := CodeBadRequest
+			if errors.Is(err, ErrFieldForbidden) {
+				code = CodeForbidden
+			}
+			ResponseError(c, code, err)
+			return
+		}
+
+		var model T
+		fields, err := bindFields(c, &model)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("UpdateManyHandler: Bind failed")
+			ResponseError(c, CodeBadRequest, err)
+			return
+		}
+		idField, _ := model.Identity()
+		if Contains(fields, idField) {
+			ResponseError(c, CodeBadRequest, ErrUpdateID)
+			return
+		}
+		if err := checkWritableFields(c, &model, fields); err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("UpdateManyHandler: write forbidden field")
+			ResponseError(c, CodeForbidden, err)
+			return
+		}
+		if opt.Pretreat != nil {
+			res, err := opt.Pretreat(c, model)
+			if err != nil {
+				logger.WithContext(c).WithError(err).
+					Warn("UpdateManyHandler:Pretreat err")
+				ResponseError(c, CodeBadRequest, err)
+				return
+			}
+			model = res.(T)
+		}
+
+		if len(opt.Omit) != 0 {
+			options = append(options, service.Omit(opt.Omit))
+		}
+		if len(opt.LimitID) != 0 {
+			options = append(options, service.Exclude(idField, opt.LimitID))
+		}
+		if !authorize(c, opt.Authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpUpdate, Bulk: true}) {
+			return
+		}
+
+		logger.WithContext(c).
+			Tracef("UpdateManyHandler: Update %v of %#v", fields, model)
+
+		rowsAffected, err := service.UpdateMany[T](c, &model, fields, options...)
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("UpdateManyHandler: UpdateMany failed")
+			ResponseError(c, CodeProcessFailed, err)
+			return
+		}
+		ResponseSuccess(c, nil, gin.H{"rowsAffected": rowsAffected})
+	}
+}
+
+// bindFields binds the JSON request body into model, and returns the names
+// of struct fields that present in the body.
+func bindFields(c *gin.Context, model any) ([]string, error) {
+	body, err := c.GetRawData()
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(body, &values); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, model); err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(values))
+	for name := range values {
+		field, ok := jsonNameToField(name, model)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownField, name)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
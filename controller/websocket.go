@@ -0,0 +1,399 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/tqrj/cd/changelog"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/event"
+	"github.com/tqrj/cd/orm"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// WebSocketHandler handles
+//
+//	GET /T/ws
+//
+// It upgrades the connection to a WebSocket, on which the client subscribes
+// to a record or to a list query of models T, and receives the changes.
+//
+// Client messages:
+//
+//	{ "type": "subscribe", "sub": "a", "id": "1" }              // record T 1
+//	{ "type": "subscribe", "sub": "b", "filters": {"done": "0"} } // list query, "preload" is optional
+//	{ "type": "unsubscribe", "sub": "a" }
+//
+// where sub is a client chosen name of the subscription. Server messages:
+//
+//	{ "type": "subscribed", "sub": "a", "data": {...} }  // the record, for record subscriptions
+//	{ "type": "change", "sub": "a", "seq": 42, "kind": "updated", "id": "1", "patch": {...} }
+//	{ "type": "error", "sub": "a", "error": "..." }
+//
+// A change carries the whole record in "data" the first time the record is
+// sent in the subscription, and a JSON merge patch (RFC 7386) against the
+// previous version in "patch" after that. Deletes are tombstones (no data),
+// and kind "removed" means the record leaves the list query.
+//
+// Subscriptions are authorized by the same hooks as the GET handlers:
+// they are checked by the Authorize, and records are read with the Omit,
+// Pretreat and QueryOptionClosure of GetOption (record subscriptions) or
+// ListOption (list queries), so a client only sees what it could GET.
+// A record subscription is authorized again on each change of the record,
+// and it's sent "removed" once the client can not GET the record any
+// more. The change log (package changelog) must be enabled.
+func WebSocketHandler[T orm.Model](getOpt *enum.GetOption, listOpt *enum.ListOption, opt *enum.WebSocketOption) gin.HandlerFunc {
+	modelName := changelog.ModelName[T]()
+	buffer := opt.Buffer
+	if buffer <= 0 {
+		buffer = 64
+	}
+	heartbeat := opt.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = 30 * time.Second
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: opt.CheckOrigin}
+
+	return func(c *gin.Context) {
+		// the hijacked connection is not waited by the server shutdown
+		shutdown := shutdownFrom(c.Request.Context())
+		release, ok := shutdown.track()
+		if !ok {
+			ResponseError(c, CodeServiceUnavailable, ErrShuttingDown)
+			return
+		}
+		defer release()
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// the upgrader has responded the error.
+			logger.WithContext(c).WithError(err).
+				Warn("WebSocketHandler: upgrade failed")
+			return
+		}
+		defer conn.Close()
+
+		session := &wsSession[T]{
+			c:       c,
+			conn:    conn,
+			getOpt:  getOpt,
+			listOpt: listOpt,
+			subs:    map[string]*wsSubscription{},
+		}
+		changes := changelog.Subscribe(modelName, buffer)
+		defer changes.Close()
+
+		_ = conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+		})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			session.readLoop()
+		}()
+		defer func() {
+			_ = conn.Close() // stops the readLoop
+			<-done
+		}()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-shutdown.done():
+				_ = session.write(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ErrShuttingDown.Error()))
+				return
+			case <-ticker.C:
+				if err := session.write(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			case entry, ok := <-changes.C:
+				if !ok {
+					if changes.Dropped() {
+						logger.WithContext(c).Warn("WebSocketHandler: client dropped for being slow")
+						_ = session.send(wsMessage{Type: "error", Error: ErrStreamDropped.Error()})
+					}
+					return
+				}
+				if err := session.dispatch(entry); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// wsRequest is a message from the client.
+type wsRequest struct {
+	Type    string            `json:"type"` // subscribe, unsubscribe
+	Sub     string            `json:"sub"`
+	ID      string            `json:"id"` // subscribe to a record
+	Filters map[string]string `json:"filters"`
+	Preload []string          `json:"preload"`
+}
+
+// wsMessage is a message to the client.
+type wsMessage struct {
+	Type  string          `json:"type"` // subscribed, change, error
+	Sub   string          `json:"sub,omitempty"`
+	Seq   uint64          `json:"seq,omitempty"`
+	Kind  string          `json:"kind,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Patch json.RawMessage `json:"patch,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// wsSubscription is a record or list query subscribed by the client.
+type wsSubscription struct {
+	id        string // record id, or empty for list queries
+	filters   map[string]string
+	options   []enum.QueryOption
+	closure   enum.QueryOption  // the QueryOptionClosure result in the options, or nil
+	authorize enum.Authorize    // the OpGet hook of record subscriptions
+	seen      map[string][]byte // the last sent version of records
+}
+
+// wsSession is the state of a WebSocket connection.
+type wsSession[T orm.Model] struct {
+	c       *gin.Context
+	conn    *websocket.Conn
+	getOpt  *enum.GetOption
+	listOpt *enum.ListOption
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	subs    map[string]*wsSubscription
+}
+
+func (s *wsSession[T]) readLoop() {
+	for {
+		var request wsRequest
+		if err := s.conn.ReadJSON(&request); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				logger.WithContext(s.c).WithError(err).
+					Debug("WebSocketHandler: read failed")
+			}
+			return
+		}
+		var err error
+		switch request.Type {
+		case "subscribe":
+			err = s.subscribe(request)
+		case "unsubscribe":
+			s.mu.Lock()
+			delete(s.subs, request.Sub)
+			s.mu.Unlock()
+		default:
+			err = fmt.Errorf("%w: %q", ErrUnknownMessage, request.Type)
+		}
+		if err != nil {
+			err = s.send(wsMessage{Type: "error", Sub: request.Sub, Error: err.Error()})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// subscribe authorizes the request by the GET hooks, and adds the
+// subscription. For a record, the record is sent in the response.
+func (s *wsSession[T]) subscribe(request wsRequest) error {
+	if request.Sub == "" {
+		return ErrMissingSub
+	}
+	get := enum.GetRequestOptions{Filters: request.Filters, Preload: request.Preload}
+	omit, closure, pretreat, authorize := s.listOpt.Omit, s.listOpt.QueryOptionClosure, s.listOpt.Pretreat, s.listOpt.Authorize
+	if request.ID != "" {
+		omit, closure, pretreat, authorize = s.getOpt.Omit, s.getOpt.QueryOptionClosure, s.getOpt.Pretreat, s.getOpt.Authorize
+	}
+	if pretreat != nil {
+		var err error
+		get, err = pretreat(s.c, get)
+		if err != nil {
+			return err
+		}
+	}
+	if err := checkQueryFields(s.c, reflect.TypeOf(*new(T)), get); err != nil {
+		return err
+	}
+	sub := &wsSubscription{
+		id:        request.ID,
+		filters:   get.Filters,
+		authorize: authorize,
+		seen:      map[string][]byte{},
+	}
+	sub.options, sub.closure = changeQueryOptions(s.c, get, omit, closure)
+
+	response := wsMessage{Type: "subscribed", Sub: request.Sub}
+	if sub.id == "" {
+		if err := checkAuthorize(s.c, authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpList}); err != nil {
+			return err
+		}
+	} else {
+		view, ok := loadChange[T](s.c, changelog.Entry{RecordID: sub.id}, nil, sub.options, sub.closure)
+		if !ok {
+			return ErrRecordNotVisible
+		}
+		record := view[getResponseModelName(*new(T))]
+		if err := checkAuthorize(s.c, authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpGet, Record: record}); err != nil {
+			return err
+		}
+		data, err := json.Marshal(redact(s.c, record))
+		if err != nil {
+			return err
+		}
+		sub.seen[sub.id] = data
+		response.Data = data
+	}
+
+	s.mu.Lock()
+	s.subs[request.Sub] = sub
+	s.mu.Unlock()
+	return s.send(response)
+}
+
+// dispatch sends the entry to the matching subscriptions.
+func (s *wsSession[T]) dispatch(entry changelog.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, sub := range s.subs {
+		if sub.id != "" && sub.id != entry.RecordID {
+			continue
+		}
+		message, ok := s.change(sub, entry)
+		if !ok {
+			continue
+		}
+		message.Sub = name
+		if err := s.send(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// change builds the change message of the entry for the subscription,
+// ok is false if there is nothing to send.
+func (s *wsSession[T]) change(sub *wsSubscription, entry changelog.Entry) (message wsMessage, ok bool) {
+	message = wsMessage{Type: "change", Seq: entry.Seq, Kind: string(entry.Kind), ID: entry.RecordID}
+	_, seen := sub.seen[entry.RecordID]
+
+	view, visible := loadChange[T](s.c, entry, sub.filters, sub.options, sub.closure)
+	// the client has the record: its delete tells nothing more
+	visible = visible || seen && entry.Kind == event.KindDeleted
+	if visible && sub.id != "" && entry.Kind != event.KindDeleted {
+		// the owner or the permissions may have changed since subscribe.
+		record := view[getResponseModelName(*new(T))]
+		err := checkAuthorize(s.c, sub.authorize, enum.AuthorizeRequest{Model: modelTypeName[T](), Operation: enum.OpGet, Record: record})
+		visible = err == nil
+	}
+	switch {
+	case !visible && seen && entry.Kind != event.KindDeleted:
+		message.Kind = "removed"
+		delete(sub.seen, entry.RecordID)
+		return message, true
+	case !visible:
+		return message, false
+	case entry.Kind == event.KindDeleted:
+		delete(sub.seen, entry.RecordID)
+		return message, true
+	}
+
+	var data []byte
+	for k, v := range view {
+		if k != "id" {
+			data, _ = json.Marshal(redact(s.c, v))
+		}
+	}
+	if last, ok := sub.seen[entry.RecordID]; ok {
+		patch, err := mergePatch(last, data)
+		if err == nil {
+			if bytes.Equal(patch, []byte("{}")) {
+				return message, false // nothing visible changed
+			}
+			message.Patch = patch
+		}
+	}
+	if message.Patch == nil {
+		message.Data = data
+	}
+	sub.seen[entry.RecordID] = data
+	return message, true
+}
+
+func (s *wsSession[T]) send(message wsMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return s.write(websocket.TextMessage, data)
+}
+
+func (s *wsSession[T]) write(messageType int, data []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_ = s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return s.conn.WriteMessage(messageType, data)
+}
+
+// mergePatch returns the JSON merge patch (RFC 7386) from JSON a to b.
+func mergePatch(a, b []byte) ([]byte, error) {
+	var x, y any
+	if err := json.Unmarshal(a, &x); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		return nil, err
+	}
+	return json.Marshal(diffJSON(x, y))
+}
+
+func diffJSON(a, b any) any {
+	x, ok1 := a.(map[string]any)
+	y, ok2 := b.(map[string]any)
+	if !ok1 || !ok2 {
+		return b
+	}
+	patch := map[string]any{}
+	for k, v := range y {
+		old, ok := x[k]
+		if !ok {
+			patch[k] = v
+			continue
+		}
+		if old, ok := old.(map[string]any); ok {
+			if v, ok := v.(map[string]any); ok {
+				if d := diffJSON(old, v).(map[string]any); len(d) != 0 {
+					patch[k] = d
+				}
+				continue
+			}
+		}
+		if !jsonEqual(old, v) {
+			patch[k] = v
+		}
+	}
+	for k := range x {
+		if _, ok := y[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	return patch
+}
+
+func jsonEqual(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return bytes.Equal(x, y)
+}
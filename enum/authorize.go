@@ -0,0 +1,49 @@
+package enum
+
+import "github.com/gin-gonic/gin"
+
+// Operation is a CRUD operation to authorize.
+type Operation string
+
+// Operations.
+const (
+	OpList   Operation = "list"
+	OpGet    Operation = "get"
+	OpCreate Operation = "create"
+	OpUpdate Operation = "update"
+	OpDelete Operation = "delete"
+)
+
+// AuthorizeRequest is what an Authorize hook decides on.
+type AuthorizeRequest struct {
+	// Model is the type name of the model to operate, e.g. "Todo".
+	// It's the child for the nested routes, except GET /P/:id/field.
+	Model     string
+	Operation Operation
+	// Record is the record to operate: the loaded one for get, update and
+	// delete, the new one for create. It's nil for list and bulk writes.
+	Record any
+	// Bulk is true for bulk writes: PATCH /T and DELETE /T.
+	Bulk bool
+	// Parent and Field are set for the nested routes /P/:id/field,
+	// where Parent is the loaded parent record.
+	Parent any
+	Field  string
+}
+
+// Authorize decides whether the request can do the operation.
+// Returning an error denies it with 403 Forbidden.
+//
+// See package auth for RBAC and ownership policies.
+type Authorize func(c *gin.Context, request AuthorizeRequest) error
+
+// FieldAccess is the roles allowed to read and write a field.
+// A nil list is unrestricted, and an empty list allows nobody.
+type FieldAccess struct {
+	Read  []string
+	Write []string
+}
+
+// FieldPermissions maps the fields (json names or struct field names) of a
+// model to their FieldAccess. See controller.FieldPermissions.
+type FieldPermissions map[string]FieldAccess
@@ -2,14 +2,48 @@ package enum
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/concurrency"
+	"github.com/tqrj/cd/ratelimit"
+	"net/http"
+	"time"
 )
 
+// Limits are the limits of the requests to the routes of an operation.
+// Nil limiters limit nothing.
+type Limits struct {
+	RateLimit   *ratelimit.Limiter   // throttles the requests, 429
+	Concurrency *concurrency.Limiter // bounds the requests in flight, 503
+	// Timeout of the requests, 504. It replaces the router default
+	// (router.WithTimeout) if not zero, and negative means no timeout.
+	Timeout time.Duration
+}
+
+// QueryGuards caps the cost of the queries of GET requests. Requests over
+// the caps are refused with 400. Zero values are unlimited.
+type QueryGuards struct {
+	MaxPreloads     int // number of preload parameters
+	MaxPreloadDepth int // levels of a preload, e.g. preload=A.B.C is 3
+	MaxFilters      int // number of filters, filters_at counts one
+	MaxOffset       int
+	// NestedLimit is the default page size of the nested collections
+	// (GET /P/:id/field), default 10. NestedLimitMax caps the limit
+	// parameter for them, default 100.
+	NestedLimit    int
+	NestedLimitMax int
+	// MaxCost refuses the list queries estimated (by EXPLAIN) to cost
+	// more than it. Only on postgres.
+	MaxCost float64
+}
+
 type ListOption struct {
 	Enable             bool
 	Omit               []string
 	LimitMax           int
 	QueryOptionClosure QueryOptionClosure
 	Pretreat           GetPretreat
+	Authorize          Authorize
+	Guards             QueryGuards
+	Limits
 }
 
 type GetOption struct {
@@ -17,6 +51,9 @@ type GetOption struct {
 	Omit               []string
 	QueryOptionClosure QueryOptionClosure
 	Pretreat           GetPretreat
+	Authorize          Authorize
+	Guards             QueryGuards // MaxCost does not apply
+	Limits
 }
 
 type UpdateOption struct {
@@ -24,18 +61,96 @@ type UpdateOption struct {
 	Omit     []string
 	Pretreat Pretreat
 	LimitID  []int64
+	// Bulk enables PATCH /T?filters[...] to update all matched records.
+	Bulk               bool
+	QueryOptionClosure QueryOptionClosure // scopes the bulk update
+	Authorize          Authorize
+	Limits
 }
 
 type CreateOption struct {
-	Enable   bool
-	Omit     []string
-	Pretreat Pretreat
+	Enable    bool
+	Omit      []string
+	Pretreat  Pretreat
+	Authorize Authorize
+	Limits
 }
 
 type DelOption struct {
 	Enable   bool
 	Pretreat DeletePretreat
 	LimitID  []int64
+	// Bulk enables DELETE /T?filters[...] to delete all matched records.
+	Bulk               bool
+	QueryOptionClosure QueryOptionClosure // scopes the bulk delete
+	Authorize          Authorize
+	Limits
+}
+
+// ExportOption is the option of GET /T/export.
+// Crud defaults the Omit, QueryOptionClosure, Pretreat and Authorize not
+// set to the ones of ListOption, so that the export shows what GET /T
+// shows.
+type ExportOption struct {
+	Enable             bool
+	Omit               []string
+	BatchSize          int // rows fetched from database at a time, default 500
+	QueryOptionClosure QueryOptionClosure
+	Pretreat           GetPretreat
+	// Authorize authorizes the export as an OpList.
+	Authorize Authorize
+	// Limits of the export. Crud defaults the RateLimit to the one of
+	// ListOption, and the Timeout to -1 (never timed out, as /stream):
+	// a big export outlasts the timeout of the list queries.
+	Limits
+}
+
+// ImportOption is the option of POST /T/import.
+// Rows are validated, pretreated and authorized by CreateOption, or by
+// UpdateOption for the rows updating existing records (upsert=true).
+type ImportOption struct {
+	Enable bool
+	// ChunkSize is the number of rows committed in a transaction.
+	// Zero means the whole file is imported in one transaction.
+	ChunkSize int
+}
+
+// StreamOption is the option of GET /T/stream, the SSE change feed.
+// Records are read with the Omit, Pretreat and QueryOptionClosure of
+// ListOption, so that the feed shows what GET /T shows.
+// The change log (package changelog) must be enabled.
+type StreamOption struct {
+	Enable bool
+	// Buffer is the number of events buffered for a client, a client
+	// falling behind more than this is dropped. Default 64.
+	Buffer int
+	// Heartbeat is the interval of keep-alive comments. Default 15s.
+	Heartbeat time.Duration
+}
+
+// WebSocketOption is the option of GET /T/ws, the WebSocket subscriptions
+// to records and list queries. Records are read with the hooks of
+// GetOption and ListOption. The change log (package changelog) must be
+// enabled.
+type WebSocketOption struct {
+	Enable bool
+	// Buffer is the number of changes buffered for a connection, a client
+	// falling behind more than this is disconnected. Default 64.
+	Buffer int
+	// Heartbeat is the interval of pings. Default 30s.
+	Heartbeat time.Duration
+	// CheckOrigin checks the Origin header of the upgrade request.
+	// Default: only the same origin is allowed.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// ChangesOption is the option of GET /T/changes, the delta sync from the
+// change log (package changelog, which must be enabled). Records are read
+// with the Omit, Pretreat and QueryOptionClosure of ListOption.
+type ChangesOption struct {
+	Enable bool
+	// LimitMax is the max number of changes in a page. Default 100.
+	LimitMax int
 }
 
 // CrudGroup is options to construct the router group.
@@ -52,4 +167,9 @@ type CurdOption struct {
 	UpdateOption
 	CreateOption
 	DelOption
+	ExportOption
+	ImportOption
+	StreamOption
+	WebSocketOption
+	ChangesOption
 }
@@ -7,9 +7,11 @@ package enum
 //	filter_by=name&filter_value=John&  # filtering
 //	total=true&                        # return total count (all available records under the filter, ignoring pagination)
 //	preload=Product&preload=Product.Manufacturer  # preloading: loads nested models as well
+//	confirm=true                       # allow a bulk write (PATCH/DELETE /T) without filters
 //
-// It is used in GetListHandler, GetByIDHandler and GetFieldHandler, to bind
-// the query parameters in the GET request url.
+// It is used in GetListHandler, GetByIDHandler, GetFieldHandler, and the bulk
+// UpdateManyHandler and DeleteManyHandler, to bind the query parameters in
+// the request url.
 type GetRequestOptions struct {
 	Limit      int               `form:"limit"`
 	Offset     int               `form:"offset"`
@@ -19,4 +21,39 @@ type GetRequestOptions struct {
 	FiltersAt  []string          `form:"filters_at"`
 	Preload    []string          `form:"preload"` // fields to preload
 	Total      bool              `form:"total"`   // return total count ?
+	Confirm    bool              `form:"confirm"` // confirm an unfiltered bulk write
+}
+
+// ExportRequestOptions is the query options for GET /T/export:
+//
+//	format=csv                         # csv, ndjson or xlsx
+//	fields=title&fields=done           # sparse fields: columns to export (default all)
+//
+// as well as the ordering and filtering options in GetRequestOptions.
+type ExportRequestOptions struct {
+	GetRequestOptions
+	Format string   `form:"format"`
+	Fields []string `form:"fields"`
+}
+
+// ImportRequestOptions is the query options for POST /T/import:
+//
+//	format=csv                         # csv or ndjson, default by the file extension
+//	dry_run=true                       # validate only, nothing is written
+//	upsert=true                        # update existing records (by primary key), only the columns in the row
+type ImportRequestOptions struct {
+	Format string `form:"format"`
+	DryRun bool   `form:"dry_run"`
+	Upsert bool   `form:"upsert"`
+}
+
+// ChangesRequestOptions is the query options for GET /T/changes:
+//
+//	since=42                           # the token from the last sync
+//	limit=100                          # max changes in a page
+//
+// as well as the filtering and preloading options in GetRequestOptions.
+type ChangesRequestOptions struct {
+	GetRequestOptions
+	Since string `form:"since"`
 }
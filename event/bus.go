@@ -0,0 +1,234 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"time"
+)
+
+// bus is the in-process event bus.
+type bus struct {
+	mu     sync.RWMutex
+	typed  map[key][]*subscriber
+	all    []*subscriber // SubscribeAll
+	nextID int
+}
+
+var defaultBus = &bus{typed: map[key][]*subscriber{}}
+
+// subscriber is a handler subscribed to some changes.
+type subscriber struct {
+	id     int
+	handle func(ctx context.Context, change Change) error
+
+	async  bool
+	buffer int
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan asyncChange
+}
+
+type asyncChange struct {
+	ctx    context.Context
+	change Change
+}
+
+// SubscribeOption is an option of Subscribe.
+type SubscribeOption func(s *subscriber)
+
+// Async runs the subscriber in its own goroutine, so that it does not
+// block the publisher. Events are delivered in order through a channel of
+// buffer size; the publisher blocks when the buffer is full.
+//
+// Async subscribers get a detached ctx: it's never canceled, and it's safe
+// to use after the request is done.
+func Async(buffer int) SubscribeOption {
+	return func(s *subscriber) {
+		s.async = true
+		s.buffer = buffer
+	}
+}
+
+// Subscribe subscribes the handler to the typed event E, for example:
+//
+//	unsubscribe := Subscribe(func(ctx context.Context, e Updated[Todo]) error {
+//	    ...
+//	})
+//
+// Call the returned unsubscribe function to unsubscribe.
+func Subscribe[E Event](handler func(ctx context.Context, e E) error, options ...SubscribeOption) (unsubscribe func()) {
+	var e E
+	return defaultBus.subscribe(e.key(), func(ctx context.Context, change Change) error {
+		return handler(ctx, e.from(change).(E))
+	}, options...)
+}
+
+// SubscribeAll subscribes the handler to all changes of all models.
+func SubscribeAll(handler func(ctx context.Context, change Change) error, options ...SubscribeOption) (unsubscribe func()) {
+	return defaultBus.subscribe(key{}, handler, options...)
+}
+
+// Publish publishes a typed event. It returns the (joined) errors of the
+// synchronous subscribers, if any.
+func Publish[E Event](ctx context.Context, e E) error {
+	return defaultBus.publish(ctx, e.change())
+}
+
+// PublishChange publishes an untyped change, it's what the service
+// package calls after writes.
+func PublishChange(ctx context.Context, change Change) error {
+	return defaultBus.publish(ctx, change)
+}
+
+// Subscribed reports whether there are any subscribers to the kind of
+// changes to the model (*T), so that publishers can skip preparing events
+// (e.g. loading the old value for an Updated event) nobody cares.
+func Subscribed(kind Kind, model any) bool {
+	defaultBus.mu.RLock()
+	defer defaultBus.mu.RUnlock()
+	if len(defaultBus.all) != 0 {
+		return true
+	}
+	for k, subscribers := range defaultBus.typed {
+		if k.kind == kind && k.model == elemType(model) && len(subscribers) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *bus) subscribe(k key, handle func(ctx context.Context, change Change) error, options ...SubscribeOption) func() {
+	s := &subscriber{handle: handle}
+	for _, option := range options {
+		option(s)
+	}
+	if s.async {
+		s.queue = make(chan asyncChange, s.buffer)
+		go s.loop()
+	}
+
+	b.mu.Lock()
+	b.nextID++
+	s.id = b.nextID
+	if k == (key{}) {
+		b.all = append(b.all, s)
+	} else {
+		b.typed[k] = append(b.typed[k], s)
+	}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			if k == (key{}) {
+				b.all = remove(b.all, s)
+			} else {
+				b.typed[k] = remove(b.typed[k], s)
+			}
+			b.mu.Unlock()
+			s.close()
+		})
+	}
+}
+
+func remove(subscribers []*subscriber, s *subscriber) []*subscriber {
+	var result []*subscriber
+	for _, sub := range subscribers {
+		if sub != s {
+			result = append(result, sub)
+		}
+	}
+	return result
+}
+
+func (b *bus) publish(ctx context.Context, change Change) error {
+	b.mu.RLock()
+	subscribers := append(append([]*subscriber{}, b.typed[change.key()]...), b.all...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, s := range subscribers {
+		if s.async {
+			s.enqueue(detach(ctx), change)
+			continue
+		}
+		if err := s.call(ctx, change); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// call calls the handler, recovering panics.
+func (s *subscriber) call(ctx context.Context, change Change) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("event subscriber panic: %v", r)
+		}
+		if err != nil {
+			logger.WithContext(ctx).WithError(err).
+				WithField("subscriber", s.id).
+				WithField("kind", change.Kind).
+				WithField("model", fmt.Sprintf("%T", change.Model)).
+				Warn("event subscriber failed")
+		}
+	}()
+	return s.handle(ctx, change)
+}
+
+func (s *subscriber) enqueue(ctx context.Context, change Change) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.closed {
+		s.queue <- asyncChange{ctx: ctx, change: change}
+	}
+}
+
+func (s *subscriber) loop() {
+	for c := range s.queue {
+		_ = s.call(c.ctx, c.change)
+	}
+}
+
+func (s *subscriber) close() {
+	if !s.async {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	close(s.queue)
+}
+
+// detach returns a ctx that is safe to use after the request is done.
+func detach(ctx context.Context) context.Context {
+	if c, ok := ctx.(*gin.Context); ok {
+		return c.Copy()
+	}
+	// a ctx derived from a gin.Context, e.g. with the span of a service
+	// call: the gin.Context is reused after the request, so only its copy
+	// and the span are kept.
+	if c, ok := ctx.Value(gin.ContextKey).(*gin.Context); ok {
+		return trace.ContextWithSpan(c.Copy(), trace.SpanFromContext(ctx))
+	}
+	return detachedContext{ctx}
+}
+
+// detachedContext keeps the values of the parent, but never be canceled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+func (c detachedContext) Value(key any) any { return c.parent.Value(key) }
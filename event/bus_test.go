@@ -0,0 +1,97 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type todo struct {
+	Title string
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx := context.Background()
+	var got []string
+	unsubscribe := Subscribe(func(ctx context.Context, e Created[todo]) error {
+		got = append(got, e.Model.Title)
+		return nil
+	})
+
+	if err := Publish(ctx, Created[todo]{Model: &todo{Title: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	_ = Publish(ctx, Deleted[todo]{Model: &todo{Title: "other kind"}})
+	if len(got) != 1 || got[0] != "a" {
+		t.Errorf("sync subscriber got %v, want [a]", got)
+	}
+	if !Subscribed(KindCreated, &todo{}) || Subscribed(KindDeleted, &todo{}) {
+		t.Errorf("Subscribed: want created only")
+	}
+
+	unsubscribe()
+	_ = Publish(ctx, Created[todo]{Model: &todo{Title: "b"}})
+	if len(got) != 1 {
+		t.Errorf("unsubscribed subscriber got %v", got)
+	}
+}
+
+func TestAsync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan string, 3)
+	unsubscribe := Subscribe(func(ctx context.Context, e Updated[todo]) error {
+		if ctx.Err() != nil {
+			t.Errorf("async subscriber got a canceled ctx")
+		}
+		received <- e.Old.Title + ">" + e.New.Title
+		return nil
+	}, Async(3))
+	defer unsubscribe()
+
+	for _, title := range []string{"b", "c", "d"} {
+		_ = Publish(ctx, Updated[todo]{Old: &todo{Title: "a"}, New: &todo{Title: title}})
+	}
+	cancel() // the request is done
+
+	for _, want := range []string{"a>b", "a>c", "a>d"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("got %q, want %q: events out of order", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("async event %q not delivered", want)
+		}
+	}
+}
+
+func TestSubscriberIsolation(t *testing.T) {
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+	calls := 0
+	defer Subscribe(func(ctx context.Context, e Deleted[todo]) error {
+		calls++
+		return errFailed
+	})()
+	defer Subscribe(func(ctx context.Context, e Deleted[todo]) error {
+		calls++
+		panic("boom")
+	})()
+	defer SubscribeAll(func(ctx context.Context, change Change) error {
+		calls++
+		return nil
+	})()
+
+	err := Publish(ctx, Deleted[todo]{Model: &todo{}})
+	if calls != 3 {
+		t.Errorf("%d subscribers called, want all 3", calls)
+	}
+	if !errors.Is(err, errFailed) {
+		t.Errorf("want the error of the failing subscriber, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "panic: boom") {
+		t.Errorf("want the panic as an error, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+// Package event implements an in-process bus for model change events.
+//
+// The service package publishes a Change after each successful write
+// (after the commit, if it's in a service.Transaction):
+//
+//   - Created[T]: service.Create(..., IfNotExist() / Upsert())
+//   - Updated[T]: service.Update, service.UpdateField, service.UpdateMany,
+//     with old and new values
+//   - Deleted[T]: service.Delete, service.DeleteByID, service.DeleteMany
+//   - Associated[P, C]: service.Create(..., NestInto(parent, field))
+//   - Dissociated[P, C]: service.DeleteNested, service.DeleteNestedByID
+//
+// Bulk writes (service.UpdateMany, service.DeleteMany) publish an event
+// for each affected model.
+//
+// Subscribe to the events of a model type from your application code:
+//
+//	event.Subscribe(func(ctx context.Context, e event.Created[Todo]) error {
+//	    return notify(e.Model.Title)
+//	})
+//
+// Subscribers run synchronously by default, or in a goroutine with the
+// Async option. A failing (or panicking) subscriber is logged, and does
+// not affect the others, nor the write that has been committed.
+package event
+
+import "github.com/tqrj/cd/log"
+
+var logger = log.ZoneLogger("crud/event")
@@ -0,0 +1,140 @@
+package event
+
+import "reflect"
+
+// Kind is the kind of change.
+type Kind string
+
+// Kinds of changes.
+const (
+	KindCreated     Kind = "created"
+	KindUpdated     Kind = "updated"
+	KindDeleted     Kind = "deleted"
+	KindAssociated  Kind = "associated"
+	KindDissociated Kind = "dissociated"
+)
+
+// Change is an untyped model change event.
+// The typed events (Created[T], Updated[T]...) are views of a Change.
+type Change struct {
+	Kind  Kind
+	Model any    // *T: the created, updated (new) or deleted model; the parent of (dis)associations
+	Old   any    // *T: the model before update
+	Field string // (dis)associated field of the parent
+	Child any    // *C: the (dis)associated child
+}
+
+// key of subscribers: a kind of changes to a model type.
+type key struct {
+	kind  Kind
+	model reflect.Type
+	child reflect.Type
+}
+
+func (c Change) key() key {
+	k := key{kind: c.Kind, model: elemType(c.Model)}
+	if c.Child != nil {
+		k.child = elemType(c.Child)
+	}
+	return k
+}
+
+// elemType *T => T
+func elemType(v any) reflect.Type {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Event is a typed view of a Change: Created[T], Updated[T], Deleted[T],
+// Associated[P, C] or Dissociated[P, C].
+type Event interface {
+	key() key
+	change() Change
+	from(change Change) Event
+}
+
+// Created is published after a model T is created.
+type Created[T any] struct {
+	Model *T
+}
+
+func (Created[T]) key() key { return key{kind: KindCreated, model: typeOf[T]()} }
+
+func (e Created[T]) change() Change { return Change{Kind: KindCreated, Model: e.Model} }
+
+func (Created[T]) from(c Change) Event { return Created[T]{Model: c.Model.(*T)} }
+
+// Updated is published after a model T is updated.
+// Old is nil if the model was not loaded before the update.
+type Updated[T any] struct {
+	Old *T
+	New *T
+}
+
+func (Updated[T]) key() key { return key{kind: KindUpdated, model: typeOf[T]()} }
+
+func (e Updated[T]) change() Change { return Change{Kind: KindUpdated, Model: e.New, Old: e.Old} }
+
+func (Updated[T]) from(c Change) Event {
+	e := Updated[T]{New: c.Model.(*T)}
+	e.Old, _ = c.Old.(*T)
+	return e
+}
+
+// Deleted is published after a model T is deleted.
+type Deleted[T any] struct {
+	Model *T
+}
+
+func (Deleted[T]) key() key { return key{kind: KindDeleted, model: typeOf[T]()} }
+
+func (e Deleted[T]) change() Change { return Change{Kind: KindDeleted, Model: e.Model} }
+
+func (Deleted[T]) from(c Change) Event { return Deleted[T]{Model: c.Model.(*T)} }
+
+// Associated is published after a child C is added to the Field of a
+// parent P.
+type Associated[P any, C any] struct {
+	Parent *P
+	Field  string
+	Child  *C
+}
+
+func (Associated[P, C]) key() key {
+	return key{kind: KindAssociated, model: typeOf[P](), child: typeOf[C]()}
+}
+
+func (e Associated[P, C]) change() Change {
+	return Change{Kind: KindAssociated, Model: e.Parent, Field: e.Field, Child: e.Child}
+}
+
+func (Associated[P, C]) from(c Change) Event {
+	return Associated[P, C]{Parent: c.Model.(*P), Field: c.Field, Child: c.Child.(*C)}
+}
+
+// Dissociated is published after a child C is removed from the Field of
+// a parent P.
+type Dissociated[P any, C any] struct {
+	Parent *P
+	Field  string
+	Child  *C
+}
+
+func (Dissociated[P, C]) key() key {
+	return key{kind: KindDissociated, model: typeOf[P](), child: typeOf[C]()}
+}
+
+func (e Dissociated[P, C]) change() Change {
+	return Change{Kind: KindDissociated, Model: e.Parent, Field: e.Field, Child: e.Child}
+}
+
+func (Dissociated[P, C]) from(c Change) Event {
+	return Dissociated[P, C]{Parent: c.Model.(*P), Field: c.Field, Child: c.Child.(*C)}
+}
@@ -0,0 +1,209 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/auth"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// enqueueAuthorize checks whether the client of c can enqueue a job of
+// the kind, see EnqueueHandler.
+type enqueueAuthorize func(c *gin.Context) error
+
+// RegisterExport registers a kind of jobs exporting model T as
+// controller.ExportHandler does, into the result file of the job.
+//
+// The payload is the query of GET /T/export, e.g.
+//
+//	{ "format": "csv", "filters[done]": "0", "order_by": "id" }
+//
+// The export runs as the principal and in the tenant that enqueued the
+// job: the opt.Authorize and the field permissions are checked when it
+// runs, and when it's enqueued by EnqueueHandler. The Restrict hooks of
+// the routes are only checked by EnqueueHandler, on the POST /jobs route.
+func RegisterExport[T orm.Model](q *Queue, kind string, opt *enum.ExportOption) {
+	handler := controller.ExportHandler[T](opt)
+	q.register(kind, func(ctx context.Context, task *Task) error {
+		var query map[string]string
+		if err := task.Bind(&query); err != nil {
+			return err
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, "/?"+encodeQuery(query), nil)
+		if err != nil {
+			return err
+		}
+		return replay(replayEngine(http.MethodGet, handler), task, request)
+	}, func(c *gin.Context) error {
+		return controller.CheckAuthorize(c, opt.Authorize, enum.AuthorizeRequest{
+			Model: modelTypeName[T](), Operation: enum.OpList,
+		})
+	})
+}
+
+// RegisterImport registers a kind of jobs importing model T as
+// controller.ImportHandler does, from the input file of the job
+// (see EnqueueInput and EnqueueHandler). The result file is the JSON
+// report of the import: { imported, errors }, also when the import fails
+// for the rows (4xx), which is not retried: the chunks committed would
+// be imported again.
+//
+// The payload is the query of POST /T/import, e.g. { "format": "csv" }.
+//
+// The import runs as the principal and in the tenant that enqueued the
+// job, checking the permissions of each row when it runs. The Restrict
+// hooks of the routes, and the createOpt.Authorize (without a record) are
+// checked by EnqueueHandler, on the POST /jobs route.
+func RegisterImport[T orm.Model](q *Queue, kind string, createOpt *enum.CreateOption, updateOpt *enum.UpdateOption, opt *enum.ImportOption) {
+	handler := controller.ImportHandler[T](createOpt, updateOpt, opt)
+	q.register(kind, func(ctx context.Context, task *Task) error {
+		if task.Input == "" {
+			return ErrNoInput
+		}
+		var query map[string]string
+		if err := task.Bind(&query); err != nil {
+			return err
+		}
+		input, err := os.Open(task.Input)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+
+		// stream the input file as a multipart upload.
+		body, pipe := io.Pipe()
+		form := multipart.NewWriter(pipe)
+		go func() {
+			part, err := form.CreateFormFile("file", filepath.Base(task.Input))
+			if err == nil {
+				_, err = io.Copy(part, input)
+			}
+			if err == nil {
+				err = form.Close()
+			}
+			_ = pipe.CloseWithError(err)
+		}()
+		defer body.Close()
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, "/?"+encodeQuery(query), body)
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", form.FormDataContentType())
+		return replay(replayEngine(http.MethodPost, handler), task, request)
+	}, func(c *gin.Context) error {
+		return controller.CheckAuthorize(c, createOpt.Authorize, enum.AuthorizeRequest{
+			Model: modelTypeName[T](), Operation: enum.OpCreate,
+		})
+	})
+}
+
+// replayEngine routes the method on "/" to the handler, as the principal
+// and in the tenant of the running job. An engine serves a single run:
+// the queries of the handler may still read its gin.Context (as the ctx
+// done with the job) after it returns, so it's not to be reused.
+func replayEngine(method string, handler gin.HandlerFunc) *gin.Engine {
+	engine := gin.New()
+	engine.ContextWithFallback = true // the job ctx is done when it's canceled
+	engine.Use(func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if tenantID, ok := orm.TenantFrom(ctx); ok {
+			c.Set(orm.TenantKey, tenantID)
+		}
+		if principal, ok := auth.PrincipalFrom(ctx); ok {
+			auth.SetPrincipal(c, principal)
+		}
+	})
+	engine.Handle(method, "/", handler)
+	return engine
+}
+
+// replay serves the request by the engine, writing the response body into
+// the result of the task. A response other than 200 fails the job: a 4xx
+// one fails it permanently (ErrPermanent), with the response body (e.g.
+// the report of an import) as the result.
+func replay(engine *gin.Engine, task *Task, request *http.Request) error {
+	writer := &replayWriter{header: http.Header{}, result: task.Result}
+	engine.ServeHTTP(writer, request)
+	status := writer.status()
+	switch {
+	case status == http.StatusOK:
+		return nil
+	case status >= 400 && status < 500:
+		if _, err := task.Result.Write(writer.failure.Bytes()); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %w: %d %s", ErrPermanent, ErrReplayFailed, status, bytes.TrimSpace(writer.failure.Bytes()))
+	default:
+		return fmt.Errorf("%w: %d %s", ErrReplayFailed, status, bytes.TrimSpace(writer.failure.Bytes()))
+	}
+}
+
+// replayWriter is the http.ResponseWriter of replay. It writes a 200
+// response into the result, and keeps others as the failure.
+type replayWriter struct {
+	header  http.Header
+	code    int
+	result  io.Writer
+	failure bytes.Buffer
+}
+
+func (w *replayWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *replayWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *replayWriter) Write(data []byte) (int, error) {
+	if w.status() != http.StatusOK {
+		return w.failure.Write(data)
+	}
+	return w.result.Write(data)
+}
+
+func (w *replayWriter) Flush() {}
+
+func (w *replayWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func encodeQuery(query map[string]string) string {
+	values := url.Values{}
+	for key, value := range query {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+// modelTypeName returns the type name of model T for the AuthorizeRequest.
+func modelTypeName[T any]() string {
+	t := reflect.TypeOf(*new(T))
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+var (
+	ErrNoInput      = errors.New("job has no input file")
+	ErrReplayFailed = errors.New("job request failed")
+)
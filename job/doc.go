@@ -0,0 +1,51 @@
+// Package job implements a small database backed queue to run long tasks
+// (e.g. big exports or imports) in background, out of the HTTP requests.
+//
+// Jobs are stored in the jobs table (the Job model) of orm.DB, and executed
+// by the worker goroutines of a Queue. A failed job is retried with an
+// exponential backoff, until it runs out of attempts, or its handler fails
+// with ErrPermanent. A job can be canceled
+// when it is pending or running. A running job holds a lease renewed by its
+// worker (see WithLease): when the worker is gone (e.g. the process
+// crashed), the job is claimed again as another attempt.
+//
+// A job is owned by the auth.Principal enqueued it, and the handler runs as
+// the principal. Only the owner, in the tenant of the job, can query,
+// download or cancel it by the HTTP APIs.
+//
+// With the tenancy (orm.EnableTenancy), jobs are stored in the base DB, and
+// the handler runs in the tenant the job is enqueued in: the services
+// called with its ctx work on the DB of the tenant.
+//
+// Register a Handler for each kind of jobs, and start the Queue:
+//
+//	queue := job.NewQueue(job.WithWorkers(4), job.WithResultDir("./results"))
+//	queue.Register("export-todos", func(ctx context.Context, task *job.Task) error {
+//	    var filter map[string]string
+//	    if err := task.Bind(&filter); err != nil {
+//	        return err
+//	    }
+//	    ...                      // write the result file into task.Result
+//	    return task.SetProgress(50)
+//	})
+//	queue.Start()
+//	defer queue.Stop()
+//
+// RegisterExport and RegisterImport register the kinds running the export
+// and import of a model as the CRUD routes do, in background:
+//
+//	job.RegisterExport[Todo](queue, "export-todos", &enum.ExportOption{})
+//	job.RegisterImport[Todo](queue, "import-todos", &enum.CreateOption{}, &enum.UpdateOption{}, &enum.ImportOption{})
+//
+// And router.Jobs adds the HTTP APIs to enqueue jobs and query them:
+//
+//	POST   /jobs             # { "kind": "export-todos", "payload": {"format": "csv"} }
+//	POST   /jobs             # multipart: kind=import-todos, file=todos.csv
+//	GET    /jobs/:JobID       # status, progress, and a download link
+//	GET    /jobs/:JobID/result
+//	DELETE /jobs/:JobID       # cancel
+package job
+
+import "github.com/tqrj/cd/log"
+
+var logger = log.ZoneLogger("crud/job")
@@ -0,0 +1,207 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/auth"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+// EnqueueRequest is the request body of POST /jobs.
+type EnqueueRequest struct {
+	Kind    string          `json:"kind" binding:"required"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// EnqueueHandler handles
+//
+//	POST /jobs
+//
+// Request body:
+//   - { "kind": "...", "payload": {...} }
+//   - or a multipart form with the kind, payload (JSON) and file fields,
+//     where the file is the input of the job (see Queue.EnqueueInput).
+//
+// The job is owned by the principal of the request (see auth.SetPrincipal),
+// and runs in the tenant of the request: only the owner in the tenant can
+// get, download or cancel it.
+//
+// Response:
+//   - 200 OK: { Job: {...} }
+//   - 400 Bad Request: { error: "unknown job kind" }
+//   - 403 Forbidden: { error: "forbidden" }, by the kinds of RegisterExport and RegisterImport
+//   - 422 Unprocessable Entity: { error: "create process failed" }
+func EnqueueHandler(queue *Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request EnqueueRequest
+		var err error
+		multipartForm := strings.HasPrefix(c.ContentType(), gin.MIMEMultipartPOSTForm)
+		if multipartForm {
+			request.Kind = c.PostForm("kind")
+			if payload := c.PostForm("payload"); payload != "" {
+				request.Payload = json.RawMessage(payload)
+			}
+			if request.Kind == "" {
+				err = ErrNoKind
+			}
+		} else {
+			err = c.ShouldBindJSON(&request)
+		}
+		if err != nil {
+			controller.ResponseError(c, controller.CodeBadRequest, err)
+			return
+		}
+		if _, ok := queue.handler(request.Kind); !ok {
+			controller.ResponseError(c, controller.CodeBadRequest, ErrUnknownKind)
+			return
+		}
+		if request.Payload == nil {
+			request.Payload = json.RawMessage("null")
+		} else if !json.Valid(request.Payload) {
+			controller.ResponseError(c, controller.CodeBadRequest, ErrBadPayload)
+			return
+		}
+		if authorize := queue.authorize(request.Kind); authorize != nil {
+			if err := authorize(c); err != nil {
+				logger.WithContext(c).WithError(err).
+					WithField("kind", request.Kind).
+					Warn("EnqueueHandler: denied")
+				controller.ResponseError(c, controller.CodeForbidden, err)
+				return
+			}
+		}
+
+		var job *Job
+		if multipartForm {
+			job, err = enqueueInput(c, queue, request)
+		} else {
+			job, err = queue.Enqueue(c, request.Kind, request.Payload)
+		}
+		if err != nil {
+			controller.ResponseError(c, controller.CodeProcessFailed, err)
+			return
+		}
+		controller.ResponseSuccess(c, job)
+	}
+}
+
+// enqueueInput enqueues the job with the uploaded file field as its input.
+func enqueueInput(c *gin.Context, queue *Queue, request EnqueueRequest) (*Job, error) {
+	fileHeader, err := c.FormFile("file")
+	if errors.Is(err, http.ErrMissingFile) {
+		return queue.Enqueue(c, request.Kind, request.Payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return queue.EnqueueInput(c, request.Kind, request.Payload, file, fileHeader.Filename)
+}
+
+// GetHandler handles
+//
+//	GET /jobs/:idParam
+//
+// Response:
+//   - 200 OK: { Job: {..., status, progress, download} }
+//   - 404 Not Found: { error: "record not found" }, also for jobs of others
+func GetHandler(queue *Queue, idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := getOwned(c, queue, idParam)
+		if err != nil {
+			responseGetError(c, err)
+			return
+		}
+		if job.Result != "" && (job.Status == StatusSucceeded || job.Status == StatusFailed) {
+			job.Download = strings.TrimSuffix(c.Request.URL.Path, "/") + "/result"
+		}
+		controller.ResponseSuccess(c, job)
+	}
+}
+
+// ResultHandler handles
+//
+//	GET /jobs/:idParam/result
+//
+// Response:
+//   - 200 OK: the result file
+//   - 404 Not Found: { error: "no result" }, or jobs of others
+func ResultHandler(queue *Queue, idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := getOwned(c, queue, idParam)
+		if err != nil {
+			responseGetError(c, err)
+			return
+		}
+		path := queue.ResultPath(job)
+		if path == "" || (job.Status != StatusSucceeded && job.Status != StatusFailed) {
+			controller.ResponseError(c, controller.CodeNotFound, ErrNoResult)
+			return
+		}
+		c.FileAttachment(path, job.Kind+"-"+job.Result)
+	}
+}
+
+// CancelHandler handles
+//
+//	DELETE /jobs/:idParam
+//
+// Response:
+//   - 200 OK: { canceled: true }
+//   - 404 Not Found: { error: "record not found" }, also for jobs of others
+//   - 422 Unprocessable Entity: { error: "job is not pending or running" }
+func CancelHandler(queue *Queue, idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := getOwned(c, queue, idParam)
+		if err != nil {
+			responseGetError(c, err)
+			return
+		}
+		if err := queue.Cancel(c, job.ID); err != nil {
+			controller.ResponseError(c, controller.CodeProcessFailed, err)
+			return
+		}
+		controller.ResponseSuccess(c, nil, gin.H{"canceled": true})
+	}
+}
+
+// getOwned gets the job of idParam, if it's owned by the principal of the
+// request in its tenant. Jobs of others are not found.
+func getOwned(c *gin.Context, queue *Queue, idParam string) (*Job, error) {
+	job, err := queue.Get(c, c.Param(idParam))
+	if err != nil {
+		return nil, err
+	}
+	var owner string
+	if principal, ok := auth.PrincipalFrom(c); ok && principal != nil {
+		owner = principal.Subject
+	}
+	tenantID, _ := orm.TenantFrom(c)
+	if job.Owner != owner || job.Tenant != tenantID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return job, nil
+}
+
+func responseGetError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		controller.ResponseError(c, controller.CodeNotFound, err)
+		return
+	}
+	controller.ResponseError(c, controller.CodeProcessFailed, err)
+}
+
+var (
+	ErrNoResult   = errors.New("job has no result")
+	ErrNoKind     = errors.New("job kind is required")
+	ErrBadPayload = errors.New("job payload is not valid JSON")
+)
@@ -0,0 +1,269 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/auth"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file:job?mode=memory&cache=shared"); err != nil {
+		panic(err)
+	}
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// startQueue starts a fast queue with the kinds registered by register.
+func startQueue(t *testing.T, register func(q *Queue)) *Queue {
+	q := NewQueue(WithPollInterval(10*time.Millisecond), WithLease(time.Second),
+		WithBackoff(func(int) time.Duration { return 0 }), WithResultDir(t.TempDir()))
+	register(q)
+	if err := q.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(q.Stop)
+	return q
+}
+
+// jobRouter routes the job APIs, as the principal in the X-User header.
+func jobRouter(q *Queue) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user != "" {
+			auth.SetPrincipal(c, &auth.Principal{Subject: user, Roles: []string{c.GetHeader("X-Role")}})
+		}
+	})
+	r.POST("/jobs", EnqueueHandler(q))
+	r.GET("/jobs/:id", GetHandler(q, "id"))
+	r.GET("/jobs/:id/result", ResultHandler(q, "id"))
+	r.DELETE("/jobs/:id", CancelHandler(q, "id"))
+	return r
+}
+
+func serve(h http.Handler, req *http.Request, user string) *httptest.ResponseRecorder {
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// enqueue posts the job, and returns its id.
+func enqueue(t *testing.T, h http.Handler, user, body string) uint {
+	req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := serve(h, req, user)
+	var response struct{ Job Job }
+	if err := json.Unmarshal(w.Body.Bytes(), &response); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("enqueue %s: %d %s", body, w.Code, w.Body)
+	}
+	return response.Job.ID
+}
+
+// wait waits for the job to finish.
+func wait(t *testing.T, id uint) *Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var job Job
+		if err := orm.DB.First(&job, id).Error; err == nil && job.Finished() {
+			return &job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %d not finished", id)
+	return nil
+}
+
+func TestOwnership(t *testing.T) {
+	q := startQueue(t, func(q *Queue) {
+		q.Register("echo", func(ctx context.Context, task *Task) error {
+			principal, _ := auth.PrincipalFrom(ctx)
+			_, err := fmt.Fprintf(task.Result, "%s by %s", task.Job.Payload, principal.Subject)
+			return err
+		})
+	})
+	r := jobRouter(q)
+
+	id := enqueue(t, r, "alice", `{"kind":"echo","payload":"hi"}`)
+	if job := wait(t, id); job.Status != StatusSucceeded || job.Owner != "alice" {
+		t.Fatalf("job: %+v", job)
+	}
+
+	path := fmt.Sprintf("/jobs/%d", id)
+	w := serve(r, httptest.NewRequest(http.MethodGet, path+"/result", nil), "alice")
+	if w.Code != http.StatusOK || w.Body.String() != `"hi" by alice` {
+		t.Errorf("result of alice: %d %s", w.Code, w.Body)
+	}
+
+	for _, user := range []string{"bob", ""} {
+		for _, req := range []*http.Request{
+			httptest.NewRequest(http.MethodGet, path, nil),
+			httptest.NewRequest(http.MethodGet, path+"/result", nil),
+			httptest.NewRequest(http.MethodDelete, path, nil),
+		} {
+			if w := serve(r, req, user); w.Code != http.StatusNotFound {
+				t.Errorf("%s %s by %q: want 404, got %d %s", req.Method, req.URL, user, w.Code, w.Body)
+			}
+		}
+	}
+}
+
+func TestLease(t *testing.T) {
+	var runs []uint
+	done := make(chan struct{}, 1)
+	startQueue(t, func(q *Queue) {
+		q.Register("crashed", func(ctx context.Context, task *Task) error {
+			runs = append(runs, task.Job.ID)
+			done <- struct{}{}
+			return nil
+		})
+	})
+
+	// left running by a crashed worker
+	expired := time.Now().Add(-time.Minute)
+	retry := Job{Kind: "crashed", Status: StatusRunning, Attempts: 1, MaxAttempts: 3, LeaseUntil: expired}
+	exhausted := Job{Kind: "crashed", Status: StatusRunning, Attempts: 3, MaxAttempts: 3, LeaseUntil: expired}
+	leased := Job{Kind: "crashed", Status: StatusRunning, Attempts: 1, MaxAttempts: 3, LeaseUntil: time.Now().Add(time.Hour)}
+	for _, job := range []*Job{&retry, &exhausted, &leased} {
+		if err := orm.DB.Create(job).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if job := wait(t, retry.ID); job.Status != StatusSucceeded || job.Attempts != 2 {
+		t.Errorf("expired job: want succeeded at attempt 2, got %s %d", job.Status, job.Attempts)
+	}
+	if job := wait(t, exhausted.ID); job.Status != StatusFailed || job.Error != ErrLeaseExpired.Error() {
+		t.Errorf("expired job out of attempts: want failed, got %s %q", job.Status, job.Error)
+	}
+	<-done
+	time.Sleep(50 * time.Millisecond)
+	var job Job
+	orm.DB.First(&job, leased.ID)
+	if job.Status != StatusRunning || job.Attempts != 1 || len(runs) != 1 {
+		t.Errorf("job of a live lease is taken: %+v, runs %v", job, runs)
+	}
+}
+
+type jobTodo struct {
+	orm.BasicModel
+	Title string `json:"title"`
+}
+
+func TestExportImport(t *testing.T) {
+	if err := orm.RegisterModel(jobTodo{}); err != nil {
+		t.Fatal(err)
+	}
+	orm.DB.Exec("DELETE FROM job_todos")
+	q := startQueue(t, func(q *Queue) {
+		RegisterExport[jobTodo](q, "export-todos", &enum.ExportOption{
+			Authorize: auth.Authorize(auth.RBAC(auth.Roles{enum.OpList: {"admin"}})),
+		})
+		RegisterImport[jobTodo](q, "import-todos",
+			&enum.CreateOption{Authorize: auth.Authorize(auth.Authenticated())},
+			&enum.UpdateOption{}, &enum.ImportOption{})
+	})
+	r := jobRouter(q)
+
+	t.Run("import", func(t *testing.T) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		_ = form.WriteField("kind", "import-todos")
+		file, _ := form.CreateFormFile("file", "todos.ndjson")
+		_, _ = io.WriteString(file, `{"title":"a"}`+"\n"+`{"title":"b"}`)
+		_ = form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/jobs", bytes.NewReader(body.Bytes()))
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		if w := serve(r, req, ""); w.Code != http.StatusForbidden {
+			t.Errorf("anonymous import: want 403, got %d %s", w.Code, w.Body)
+		}
+
+		req = httptest.NewRequest(http.MethodPost, "/jobs", bytes.NewReader(body.Bytes()))
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := serve(r, req, "alice")
+		var response struct{ Job Job }
+		if err := json.Unmarshal(w.Body.Bytes(), &response); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("import: %d %s", w.Code, w.Body)
+		}
+		job := wait(t, response.Job.ID)
+		report, _ := os.ReadFile(q.ResultPath(job))
+		if job.Status != StatusSucceeded || !strings.Contains(string(report), `"imported":2`) {
+			t.Errorf("import: %s %q %s", job.Status, job.Error, report)
+		}
+		if _, err := os.Stat(q.resultDir + "/" + job.Input); !os.IsNotExist(err) {
+			t.Errorf("input file of the finished job is kept: %v", err)
+		}
+	})
+
+	t.Run("export", func(t *testing.T) {
+		body := `{"kind":"export-todos","payload":{"format":"ndjson","order_by":"title"}}`
+		req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if w := serve(r, req, "bob"); w.Code != http.StatusForbidden {
+			t.Errorf("export by bob: want 403, got %d %s", w.Code, w.Body)
+		}
+
+		req = httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Role", "admin")
+		w := serve(r, req, "root")
+		var response struct{ Job Job }
+		if err := json.Unmarshal(w.Body.Bytes(), &response); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("export: %d %s", w.Code, w.Body)
+		}
+		job := wait(t, response.Job.ID)
+		result, _ := os.ReadFile(q.ResultPath(job))
+		if job.Status != StatusSucceeded || strings.Count(string(result), `"title"`) != 2 ||
+			strings.Index(string(result), `"a"`) > strings.Index(string(result), `"b"`) {
+			t.Errorf("export: %s %q %s", job.Status, job.Error, result)
+		}
+	})
+
+	t.Run("denied when run", func(t *testing.T) {
+		// enqueued without EnqueueHandler: the export runs as the principal
+		ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Subject: "bob"})
+		job, err := q.Enqueue(ctx, "export-todos", map[string]string{"format": "ndjson"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job = wait(t, job.ID); job.Status != StatusFailed || !strings.Contains(job.Error, "403") || job.Attempts != 1 {
+			t.Errorf("export by bob: want failed by 403 without retrying, got %s %q at attempt %d", job.Status, job.Error, job.Attempts)
+		}
+	})
+
+	t.Run("import failed", func(t *testing.T) {
+		ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Subject: "alice"})
+		input := `{"title":"c"}` + "\n" + `{"title":`
+		job, err := q.EnqueueInput(ctx, "import-todos", map[string]string{"format": "ndjson"}, strings.NewReader(input), "todos.ndjson")
+		if err != nil {
+			t.Fatal(err)
+		}
+		job = wait(t, job.ID)
+		if job.Status != StatusFailed || job.Attempts != 1 {
+			t.Errorf("import: want failed without retrying, got %s %q at attempt %d", job.Status, job.Error, job.Attempts)
+		}
+		report, _ := os.ReadFile(q.ResultPath(job))
+		if !strings.Contains(string(report), `"errors"`) || !strings.Contains(string(report), `"line":2`) {
+			t.Errorf("import report of the failed job: %q", report)
+		}
+		w := serve(r, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/jobs/%d/result", job.ID), nil), "alice")
+		if w.Code != http.StatusOK || w.Body.String() != string(report) {
+			t.Errorf("download the report: %d %s", w.Code, w.Body)
+		}
+	})
+}
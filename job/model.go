@@ -0,0 +1,63 @@
+package job
+
+import (
+	"encoding/json"
+	"github.com/tqrj/cd/auth"
+	"github.com/tqrj/cd/orm"
+	"time"
+)
+
+// Status is the status of a Job.
+type Status string
+
+// Job statuses.
+const (
+	StatusPending   Status = "pending"   // waiting for (re)try
+	StatusRunning   Status = "running"   // claimed by a worker
+	StatusSucceeded Status = "succeeded" // done, the result is available
+	StatusFailed    Status = "failed"    // out of attempts
+	StatusCanceled  Status = "canceled"  // canceled by Queue.Cancel
+)
+
+// Job is a record of the jobs table.
+type Job struct {
+	orm.BasicModel
+	Kind        string          `json:"kind" gorm:"index"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      Status          `json:"status" gorm:"index"`
+	Progress    int             `json:"progress"` // 0 ~ 100
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt" gorm:"index"` // not to run before
+	Error       string          `json:"error"`              // error of the last attempt
+	Result      string          `json:"-"`                  // result file name in the result dir
+	Input       string          `json:"-"`                  // input file name in the result dir, see EnqueueInput
+	LeaseUntil  time.Time       `json:"-" gorm:"index"`     // a running job is reclaimed after it
+
+	// the client enqueued the job, who only can see it: the Subject of
+	// the auth.Principal in the ctx of Enqueue, the principal itself (JSON)
+	// and the tenant, which the handler runs as.
+	Owner     string          `json:"-" gorm:"index"`
+	Principal json.RawMessage `json:"-"`
+	Tenant    string          `json:"-" gorm:"size:48"`
+
+	Download string `json:"download,omitempty" gorm:"-"` // link to the result, set by GetHandler
+}
+
+// Finished reports whether the job is no longer to run.
+func (j *Job) Finished() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed || j.Status == StatusCanceled
+}
+
+// principal returns the auth.Principal enqueued the job, if any.
+func (j *Job) principal() (*auth.Principal, bool) {
+	if len(j.Principal) == 0 {
+		return nil, false
+	}
+	var principal auth.Principal
+	if err := json.Unmarshal(j.Principal, &principal); err != nil {
+		logger.WithError(err).WithField("job", j.ID).Warn("Job: bad principal")
+		return nil, false
+	}
+	return &principal, true
+}
@@ -0,0 +1,562 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tqrj/cd/auth"
+	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Handler runs a job. It should return when the ctx is done,
+// which means the job is canceled or the queue is stopping.
+//
+// A failed job is retried, unless the error wraps ErrPermanent (e.g. the
+// job is invalid, or not allowed): it fails at once, keeping its result
+// file (e.g. a report of the failure).
+type Handler func(ctx context.Context, task *Task) error
+
+// Task is a running job given to its Handler.
+type Task struct {
+	Job    *Job
+	Result io.Writer // the result file, which can be downloaded when the job succeeded, or failed by ErrPermanent
+	Input  string    // path to the input file of the job (see EnqueueInput), or empty
+}
+
+// Bind decodes the payload of the job into v.
+func (t *Task) Bind(v any) error {
+	return json.Unmarshal(t.Job.Payload, v)
+}
+
+// SetProgress saves the progress (0 ~ 100) of the job.
+func (t *Task) SetProgress(progress int) error {
+	t.Job.Progress = progress
+	return orm.DB.Model(&Job{}).
+		Where("id = ? AND status = ?", t.Job.ID, StatusRunning).
+		Update("progress", progress).Error
+}
+
+// Queue runs jobs in worker goroutines.
+type Queue struct {
+	workers      int
+	pollInterval time.Duration
+	maxAttempts  int
+	backoff      func(attempt int) time.Duration
+	resultDir    string
+	lease        time.Duration
+
+	mu         sync.RWMutex
+	handlers   map[string]Handler
+	authorizes map[string]enqueueAuthorize // of the kinds, for EnqueueHandler
+
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// QueueOption is an option to construct the Queue.
+type QueueOption func(q *Queue)
+
+// NewQueue creates a Queue. By default, it has 1 worker polling every
+// second, 3 attempts for each job with exponential backoff (1s, 2s, 4s...
+// up to 1 hour), a lease of 1 minute, and stores results in the
+// "job-results" directory.
+func NewQueue(options ...QueueOption) *Queue {
+	q := &Queue{
+		workers:      1,
+		pollInterval: time.Second,
+		maxAttempts:  3,
+		backoff:      ExponentialBackoff(time.Second, time.Hour),
+		resultDir:    "job-results",
+		lease:        time.Minute,
+		handlers:     map[string]Handler{},
+		authorizes:   map[string]enqueueAuthorize{},
+	}
+	for _, option := range options {
+		option(q)
+	}
+	return q
+}
+
+// WithWorkers sets the number of worker goroutines.
+func WithWorkers(n int) QueueOption {
+	return func(q *Queue) {
+		q.workers = n
+	}
+}
+
+// WithPollInterval sets how often idle workers look for new jobs,
+// and how often running jobs are checked for cancellation and renew their
+// leases.
+func WithPollInterval(interval time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.pollInterval = interval
+	}
+}
+
+// WithMaxAttempts sets the default max attempts of new jobs.
+func WithMaxAttempts(n int) QueueOption {
+	return func(q *Queue) {
+		q.maxAttempts = n
+	}
+}
+
+// WithBackoff sets the delay before retrying a job failed for attempt times.
+func WithBackoff(backoff func(attempt int) time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.backoff = backoff
+	}
+}
+
+// WithLease sets the lease of running jobs: a worker renews the lease of
+// its job every poll interval, and a job whose lease expired (e.g. the
+// process crashed) is claimed again as an attempt. It must be longer than
+// the poll interval.
+func WithLease(lease time.Duration) QueueOption {
+	return func(q *Queue) {
+		q.lease = lease
+	}
+}
+
+// WithResultDir sets the directory to store the result and input files.
+func WithResultDir(dir string) QueueOption {
+	return func(q *Queue) {
+		q.resultDir = dir
+	}
+}
+
+// ExponentialBackoff returns a backoff doubling from base up to max.
+func ExponentialBackoff(base time.Duration, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		delay := base
+		for i := 1; i < attempt && delay < max; i++ {
+			delay *= 2
+		}
+		if delay > max {
+			delay = max
+		}
+		return delay
+	}
+}
+
+// Register registers the handler for the jobs of kind.
+// Only jobs of registered kinds can be enqueued and run.
+func (q *Queue) Register(kind string, handler Handler) {
+	q.register(kind, handler, nil)
+}
+
+func (q *Queue) register(kind string, handler Handler, authorize enqueueAuthorize) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+	if authorize != nil {
+		q.authorizes[kind] = authorize
+	} else {
+		delete(q.authorizes, kind)
+	}
+}
+
+func (q *Queue) authorize(kind string) enqueueAuthorize {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.authorizes[kind]
+}
+
+func (q *Queue) handler(kind string) (Handler, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	handler, ok := q.handlers[kind]
+	return handler, ok
+}
+
+func (q *Queue) kinds() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+// Enqueue adds a job of kind, with the payload encoded in JSON.
+//
+// The job is owned by the auth.Principal in ctx (anonymous if none), and
+// runs in the tenant of ctx if any (see orm.WithTenant): the ctx given to
+// the Handler carries the principal and the tenant.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload any) (*Job, error) {
+	return q.enqueue(ctx, kind, payload, "")
+}
+
+// EnqueueInput adds a job of kind as Enqueue, with an input file (e.g. the
+// file to import) read from input, and named like name (its extension is
+// kept). The Handler finds the file at Task.Input. The file is removed
+// when the job is finished.
+func (q *Queue) EnqueueInput(ctx context.Context, kind string, payload any, input io.Reader, name string) (*Job, error) {
+	if _, ok := q.handler(kind); !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKind, kind)
+	}
+	if err := os.MkdirAll(q.resultDir, 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(q.resultDir, "input-*"+filepath.Ext(name))
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(file, input)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	var job *Job
+	if err == nil {
+		job, err = q.enqueue(ctx, kind, payload, filepath.Base(file.Name()))
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+	return job, err
+}
+
+func (q *Queue) enqueue(ctx context.Context, kind string, payload any, input string) (*Job, error) {
+	if _, ok := q.handler(kind); !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKind, kind)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		Kind:        kind,
+		Payload:     data,
+		Status:      StatusPending,
+		MaxAttempts: q.maxAttempts,
+		RunAt:       time.Now(),
+		Input:       input,
+	}
+	job.Tenant, _ = orm.TenantFrom(ctx)
+	if principal, ok := auth.PrincipalFrom(ctx); ok && principal != nil {
+		job.Owner = principal.Subject
+		if job.Principal, err = json.Marshal(principal); err != nil {
+			return nil, err
+		}
+	}
+	if err := orm.DB.WithContext(ctx).Create(job).Error; err != nil {
+		logger.WithContext(ctx).WithError(err).
+			WithField("kind", kind).Warn("Enqueue: create job failed")
+		return nil, err
+	}
+	logger.WithContext(ctx).WithField("job", job.ID).
+		WithField("kind", kind).Debug("Enqueue: job created")
+	return job, nil
+}
+
+// Get gets the job by id, of any owner.
+func (q *Queue) Get(ctx context.Context, id any) (*Job, error) {
+	var job Job
+	err := orm.DB.WithContext(ctx).Take(&job, "id = ?", id).Error
+	return &job, err
+}
+
+// Cancel cancels a pending or running job. A running job is interrupted
+// (by its ctx) within the poll interval.
+func (q *Queue) Cancel(ctx context.Context, id any) error {
+	job, err := q.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	result := orm.DB.WithContext(ctx).Model(&Job{}).
+		Where("id = ? AND status IN ?", job.ID, []Status{StatusPending, StatusRunning}).
+		Update("status", StatusCanceled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotCancelable
+	}
+	// the input of a running job is removed when it's interrupted.
+	if job.Status == StatusPending {
+		q.removeInput(job)
+	}
+	return nil
+}
+
+// ResultPath returns the path to the result file of the job,
+// or an empty string if there is no result.
+func (q *Queue) ResultPath(job *Job) string {
+	if job.Result == "" {
+		return ""
+	}
+	return filepath.Join(q.resultDir, job.Result)
+}
+
+// Start migrates the jobs table and starts the workers.
+func (q *Queue) Start() error {
+	if err := orm.RegisterModel(&Job{}); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(q.resultDir, 0o755); err != nil {
+		return err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	q.stop = stop
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx, i)
+	}
+	logger.WithField("workers", q.workers).Info("Queue started")
+	return nil
+}
+
+// Stop stops the workers and waits for them to exit.
+// Interrupted jobs are put back to pending, to be run again later.
+func (q *Queue) Stop() {
+	if q.stop == nil {
+		return
+	}
+	q.stop()
+	q.wg.Wait()
+	logger.Info("Queue stopped")
+}
+
+// work is a worker loop: claims and runs jobs until ctx is done.
+func (q *Queue) work(ctx context.Context, worker int) {
+	defer q.wg.Done()
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		job, err := q.claim(ctx)
+		if err != nil {
+			logger.WithError(err).WithField("worker", worker).
+				Warn("work: claim job failed")
+		}
+		if job != nil {
+			q.run(ctx, job)
+			continue // try next one immediately
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim takes a due pending job, or a running job whose lease expired
+// (its worker is gone), and marks it running with a new lease.
+// It returns nil if there is no such job.
+func (q *Queue) claim(ctx context.Context) (*Job, error) {
+	kinds := q.kinds()
+	if len(kinds) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	var job Job
+	err := orm.DB.WithContext(ctx).
+		Where("kind IN ?", kinds).
+		Where(orm.DB.Where("status = ? AND run_at <= ?", StatusPending, now).
+			Or("status = ? AND lease_until < ?", StatusRunning, now)).
+		Order("run_at").Take(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || ctx.Err() != nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// optimistic lock: someone else may have claimed it.
+	claimed := orm.DB.WithContext(ctx).Model(&Job{}).
+		Where("id = ? AND status = ? AND attempts = ?", job.ID, job.Status, job.Attempts)
+	if job.Status == StatusRunning && job.Attempts >= job.MaxAttempts {
+		result := claimed.Updates(map[string]any{
+			"status": StatusFailed,
+			"error":  ErrLeaseExpired.Error(),
+		})
+		if result.Error == nil && result.RowsAffected != 0 {
+			logger.WithField("job", job.ID).Warn("claim: job lease expired, out of attempts")
+			q.removeInput(&job)
+		}
+		return nil, result.Error
+	}
+	if job.Status == StatusRunning {
+		logger.WithField("job", job.ID).Warn("claim: job lease expired, run again")
+	}
+	result := claimed.Updates(map[string]any{
+		"status":      StatusRunning,
+		"attempts":    gorm.Expr("attempts + 1"),
+		"lease_until": now.Add(q.lease),
+	})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	job.Status = StatusRunning
+	job.Attempts++
+	return &job, nil
+}
+
+// run runs the job, and saves its result.
+func (q *Queue) run(ctx context.Context, job *Job) {
+	logger := logger.WithField("job", job.ID).
+		WithField("kind", job.Kind).
+		WithField("attempt", job.Attempts)
+	logger.Debug("run: job started")
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go q.heartbeat(runCtx, job, cancel)
+
+	err := q.execute(runCtx, job)
+
+	// ctx is done (queue stopping) here, so update with a new one.
+	// Canceled jobs, and the ones claimed again by others (the lease
+	// expired) are left as they are.
+	db := orm.DB.WithContext(context.Background()).Model(&Job{}).
+		Where("id = ? AND status = ? AND attempts = ?", job.ID, StatusRunning, job.Attempts)
+	finished := false
+	switch {
+	case err == nil:
+		db = db.Updates(map[string]any{
+			"status":   StatusSucceeded,
+			"progress": 100,
+			"error":    "",
+			"result":   job.Result,
+		})
+		finished = true
+		logger.Info("run: job succeeded")
+	case ctx.Err() != nil: // stopping: put it back, not counting the attempt
+		db = db.Updates(map[string]any{
+			"status":   StatusPending,
+			"attempts": gorm.Expr("attempts - 1"),
+		})
+		logger.WithError(err).Info("run: job interrupted")
+	case errors.Is(err, ErrPermanent):
+		db = db.Updates(map[string]any{
+			"status": StatusFailed,
+			"error":  err.Error(),
+			"result": job.Result,
+		})
+		finished = true
+		logger.WithError(err).Warn("run: job failed permanently")
+	case job.Attempts < job.MaxAttempts:
+		db = db.Updates(map[string]any{
+			"status": StatusPending,
+			"run_at": time.Now().Add(q.backoff(job.Attempts)),
+			"error":  err.Error(),
+		})
+		logger.WithError(err).Warn("run: job failed, will retry")
+	default:
+		db = db.Updates(map[string]any{
+			"status": StatusFailed,
+			"error":  err.Error(),
+		})
+		finished = true
+		logger.WithError(err).Warn("run: job failed")
+	}
+	if db.Error != nil {
+		logger.WithError(db.Error).Error("run: save job status failed")
+	}
+	if db.RowsAffected == 0 { // canceled, or claimed again
+		var status Status
+		orm.DB.Model(&Job{}).Where("id = ?", job.ID).Select("status").Scan(&status)
+		finished = status == StatusCanceled
+	}
+	if finished {
+		q.removeInput(job)
+	}
+}
+
+// removeInput removes the input file of the job, if any.
+func (q *Queue) removeInput(job *Job) {
+	if job.Input == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(q.resultDir, job.Input)); err != nil && !os.IsNotExist(err) {
+		logger.WithError(err).WithField("job", job.ID).Warn("removeInput: failed")
+	}
+}
+
+// execute calls the job handler with a result file.
+func (q *Queue) execute(ctx context.Context, job *Job) (err error) {
+	handler, ok := q.handler(job.Kind)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKind, job.Kind)
+	}
+
+	name := strconv.FormatUint(uint64(job.ID), 10)
+	file, err := os.Create(filepath.Join(q.resultDir, name))
+	if err != nil {
+		return err
+	}
+	task := &Task{Job: job, Result: &resultWriter{file: file}}
+	if job.Input != "" {
+		task.Input = filepath.Join(q.resultDir, job.Input)
+	}
+	if job.Tenant != "" {
+		ctx = orm.WithTenant(ctx, job.Tenant)
+	}
+	if principal, ok := job.principal(); ok {
+		ctx = auth.WithPrincipal(ctx, principal)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panic: %v", r)
+		}
+		_ = file.Close()
+		if (err == nil || errors.Is(err, ErrPermanent)) && task.Result.(*resultWriter).written {
+			job.Result = name
+		} else {
+			_ = os.Remove(file.Name())
+		}
+	}()
+	return handler(ctx, task)
+}
+
+// heartbeat renews the lease of the running job every poll interval, and
+// cancels the run if the job is canceled, or claimed by another worker.
+func (q *Queue) heartbeat(ctx context.Context, job *Job, cancel context.CancelFunc) {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		result := orm.DB.WithContext(ctx).Model(&Job{}).
+			Where("id = ? AND status = ? AND attempts = ?", job.ID, StatusRunning, job.Attempts).
+			Update("lease_until", time.Now().Add(q.lease))
+		if result.Error == nil && result.RowsAffected == 0 {
+			logger.WithField("job", job.ID).Info("heartbeat: job canceled or lost")
+			cancel()
+			return
+		}
+	}
+}
+
+// resultWriter records whether anything is written.
+type resultWriter struct {
+	file    *os.File
+	written bool
+}
+
+func (w *resultWriter) Write(p []byte) (int, error) {
+	w.written = w.written || len(p) > 0
+	return w.file.Write(p)
+}
+
+var (
+	ErrUnknownKind   = errors.New("unknown job kind")
+	ErrNotCancelable = errors.New("job is not pending or running")
+	ErrLeaseExpired  = errors.New("job lease expired")
+	ErrPermanent     = errors.New("job failed permanently")
+)
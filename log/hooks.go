@@ -1,7 +1,11 @@
 package log
 
 import (
+	"context"
 	"github.com/sirupsen/logrus"
+	ginrequestid "github.com/tqrj/cd/pkg/gin-request-id"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
 )
 
 // ContextValueFieldHook add a FieldKey=ContextValue(ContextKey) field
@@ -38,9 +42,59 @@ func (c ContextValueFieldHook) Fire(entry *logrus.Entry) error {
 	return nil
 }
 
-// RequestIDHook add a context="request_id" field to the log entry
+// RequestIDHook add a context=<request_id> field to the log entry, if the
+// context of the entry has the request id set by the gin_request_id.RequestID
+// middleware (a *gin.Context, or the context of its request).
 func RequestIDHook() logrus.Hook {
-	return ContextValueFieldHook{
-		ContextKey: "request_id",
+	return requestIDHook{}
+}
+
+type requestIDHook struct{}
+
+func (requestIDHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (requestIDHook) Fire(entry *logrus.Entry) error {
+	if id, ok := ginrequestid.From(entry.Context); ok {
+		entry.Data["context"] = id
+	}
+	return nil
+}
+
+// TraceIDHook adds the trace_id and span_id fields of the OpenTelemetry
+// span in the context (if any) to the log entry. See package tracing.
+func TraceIDHook() logrus.Hook {
+	return traceIDHook{}
+}
+
+type traceIDHook struct{}
+
+func (traceIDHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (traceIDHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	span := spanContextFrom(entry.Context)
+	if !span.IsValid() {
+		return nil
+	}
+	entry.Data["trace_id"] = span.TraceID().String()
+	entry.Data["span_id"] = span.SpanID().String()
+	return nil
+}
+
+// spanContextFrom returns the span context in ctx, or in the context of
+// its http request for a *gin.Context, which hides it.
+func spanContextFrom(ctx context.Context) trace.SpanContext {
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		return span
+	}
+	if request, ok := ctx.Value(0).(*http.Request); ok && request != nil { // gin.Context.Value(0)
+		return trace.SpanContextFromContext(request.Context())
 	}
+	return trace.SpanContext{}
 }
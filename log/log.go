@@ -80,12 +80,13 @@ func WithHook(hook logrus.Hook) LoggerOption {
 }
 
 // DefaultLoggerOptions = WithLevel(LevelDebug) + WithReportCaller(false)
-//   - WithHook(RequestIDHook())
+//   - WithHook(RequestIDHook()) + WithHook(TraceIDHook())
 func DefaultLoggerOptions() []LoggerOption {
 	return []LoggerOption{
 		WithLevel(LevelDebug),
 		WithReportCaller(false),
 		WithHook(RequestIDHook()),
+		WithHook(TraceIDHook()),
 	}
 }
 
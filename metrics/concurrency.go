@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tqrj/cd/concurrency"
+)
+
+// concurrencyCollector collects the concurrency.AllStats.
+type concurrencyCollector struct {
+	inFlight *prometheus.Desc
+	queued   *prometheus.Desc
+	rejected *prometheus.Desc
+}
+
+func newConcurrencyCollector(namespace string) *concurrencyCollector {
+	name := func(name string) string {
+		return prometheus.BuildFQName(namespace, "concurrency", name)
+	}
+	labels := []string{"limiter"}
+	return &concurrencyCollector{
+		inFlight: prometheus.NewDesc(name("in_flight"), "Requests running in the concurrency limiter.", labels, nil),
+		queued:   prometheus.NewDesc(name("queued"), "Requests waiting in the queue of the concurrency limiter.", labels, nil),
+		rejected: prometheus.NewDesc(name("rejected_total"), "Requests rejected by the concurrency limiter.", labels, nil),
+	}
+}
+
+func (c *concurrencyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.inFlight
+	ch <- c.queued
+	ch <- c.rejected
+}
+
+// Collect collects the stats of the limiters, summed by name.
+func (c *concurrencyCollector) Collect(ch chan<- prometheus.Metric) {
+	var names []string
+	sums := map[string]*concurrency.Stats{}
+	for _, stats := range concurrency.AllStats() {
+		sum, ok := sums[stats.Name]
+		if !ok {
+			sum = &concurrency.Stats{Name: stats.Name}
+			sums[stats.Name] = sum
+			names = append(names, stats.Name)
+		}
+		sum.InFlight += stats.InFlight
+		sum.Queued += stats.Queued
+		sum.Rejected += stats.Rejected
+	}
+	for _, name := range names {
+		stats := sums[name]
+		ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(stats.InFlight), name)
+		ch <- prometheus.MustNewConstMetric(c.queued, prometheus.GaugeValue, float64(stats.Queued), name)
+		ch <- prometheus.MustNewConstMetric(c.rejected, prometheus.CounterValue, float64(stats.Rejected), name)
+	}
+}
@@ -0,0 +1,24 @@
+// Package metrics collects the Prometheus metrics of the HTTP routes, the
+// GORM queries, the DB connection pools and the concurrency limiters.
+//
+//	m := metrics.New()
+//	if err := orm.Use(m.Plugin()); err != nil { ... } // query metrics
+//	m.WatchDB("main", orm.DB)                          // pool gauges
+//	r := router.NewRouter(router.WithMetrics(m, "/metrics"))
+//
+// The metrics are served in the Prometheus text format by Handler (which
+// WithMetrics routes), and can be read in tests by scraping it with
+// httptest, without a Prometheus server:
+//
+//	crud_http_requests_total{method,route,status}
+//	crud_http_request_duration_seconds{method,route,status}
+//	crud_db_query_duration_seconds{table,operation}
+//	crud_db_query_errors_total{table,operation}
+//	crud_concurrency_in_flight{limiter}
+//	crud_concurrency_queued{limiter}
+//	crud_concurrency_rejected_total{limiter}
+//	go_sql_*{db_name}
+//
+// Routes are labeled by their templates (e.g. /todos/:TodoID), so the
+// label values are bounded.
+package metrics
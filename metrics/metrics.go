@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Metrics holds the collectors in a prometheus.Registry.
+type Metrics struct {
+	registry  *prometheus.Registry
+	namespace string
+	buckets   []float64
+
+	requests    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	queries     *prometheus.HistogramVec
+	queryErrors *prometheus.CounterVec
+}
+
+// Option is an option to construct the Metrics.
+type Option func(m *Metrics)
+
+// WithRegistry registers the metrics in the registry, instead of a new
+// one. Use prometheus.DefaultRegisterer's registry to serve them with the
+// Go runtime metrics.
+func WithRegistry(registry *prometheus.Registry) Option {
+	return func(m *Metrics) {
+		m.registry = registry
+	}
+}
+
+// WithNamespace sets the prefix of the metric names, default "crud".
+func WithNamespace(namespace string) Option {
+	return func(m *Metrics) {
+		m.namespace = namespace
+	}
+}
+
+// WithBuckets sets the buckets (in seconds) of the duration histograms,
+// default prometheus.DefBuckets.
+func WithBuckets(buckets []float64) Option {
+	return func(m *Metrics) {
+		m.buckets = buckets
+	}
+}
+
+// New creates the Metrics, with the concurrency limiters collected.
+func New(options ...Option) *Metrics {
+	m := &Metrics{
+		namespace: "crud",
+		buckets:   prometheus.DefBuckets,
+	}
+	for _, option := range options {
+		option(m)
+	}
+	if m.registry == nil {
+		m.registry = prometheus.NewRegistry()
+	}
+
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by route template, method and status.",
+	}, []string{"method", "route", "status"})
+	m.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request durations by route template, method and status.",
+		Buckets:   m.buckets,
+	}, []string{"method", "route", "status"})
+	m.queries = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Database query durations by table and operation.",
+		Buckets:   m.buckets,
+	}, []string{"table", "operation"})
+	m.queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Subsystem: "db",
+		Name:      "query_errors_total",
+		Help:      "Failed database queries by table and operation.",
+	}, []string{"table", "operation"})
+
+	m.registry.MustRegister(m.requests, m.duration, m.queries, m.queryErrors,
+		newConcurrencyCollector(m.namespace))
+	return m
+}
+
+// Registry returns the registry of the metrics, to register more
+// collectors.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware records the requests. Routes are labeled by the route
+// templates (gin.Context.FullPath), or "unmatched" for 404s.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		m.requests.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.duration.WithLabelValues(c.Request.Method, route, status).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// WatchDB collects the connection pool stats (sql.DB.Stats) of db as
+// the go_sql_* gauges labeled db_name=name.
+func (m *Metrics) WatchDB(name string, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return m.registry.Register(collectors.NewDBStatsCollector(sqlDB, name))
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/concurrency"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type widget struct {
+	ID   uint
+	Name string
+}
+
+// scrape returns the metrics in the text format.
+func scrape(t *testing.T, m *Metrics) string {
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	return string(body)
+}
+
+func assertContains(t *testing.T, text string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(text, line) {
+			t.Errorf("missing %q", line)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := New()
+	r := gin.New()
+	r.Use(m.Middleware())
+	r.GET("/widgets/:WidgetID", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	for _, path := range []string{"/widgets/1", "/widgets/2", "/nope"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assertContains(t, scrape(t, m),
+		`crud_http_requests_total{method="GET",route="/widgets/:WidgetID",status="200"} 2`,
+		`crud_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`crud_http_request_duration_seconds_count{method="GET",route="/widgets/:WidgetID",status="200"} 2`,
+	)
+}
+
+func TestPlugin(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:metrics?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(WithNamespace("test"))
+	if err := db.Use(m.Plugin()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WatchDB("main", db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&widget{}); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Create(&widget{Name: "a"})
+	var widgets []widget
+	db.Find(&widgets)
+	db.Table("nonexistent").Find(&widgets)
+
+	assertContains(t, scrape(t, m),
+		`test_db_query_duration_seconds_count{operation="create",table="widgets"} 1`,
+		`test_db_query_duration_seconds_count{operation="query",table="widgets"} 1`,
+		`test_db_query_errors_total{operation="query",table="nonexistent"} 1`,
+		`go_sql_max_open_connections{db_name="main"}`,
+	)
+}
+
+func TestConcurrency(t *testing.T) {
+	limiter := concurrency.New(concurrency.Limit{MaxInFlight: 1}, concurrency.WithName("metrics_test"))
+	release, _ := limiter.Acquire(context.Background())
+	_, _ = limiter.Acquire(context.Background()) // rejected
+	defer release()
+
+	m := New()
+	assertContains(t, scrape(t, m),
+		`crud_concurrency_in_flight{limiter="metrics_test"} 1`,
+		`crud_concurrency_rejected_total{limiter="metrics_test"} 1`,
+	)
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Plugin returns the GORM plugin recording the query durations and errors
+// into the Metrics. Apply it by orm.Use (or gorm.DB.Use).
+func (m *Metrics) Plugin() gorm.Plugin {
+	return &plugin{metrics: m}
+}
+
+type plugin struct {
+	metrics *Metrics
+}
+
+func (p *plugin) Name() string {
+	return "crud:metrics"
+}
+
+const startKey = "crud:metrics_start"
+
+// register is a callback processor of gorm, registering the callback
+// before or after a gorm callback.
+type register interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+func (p *plugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	for _, c := range []struct {
+		operation     string
+		before, after register
+	}{
+		{"create", callbacks.Create().Before("gorm:create"), callbacks.Create().After("gorm:create")},
+		{"query", callbacks.Query().Before("gorm:query"), callbacks.Query().After("gorm:query")},
+		{"update", callbacks.Update().Before("gorm:update"), callbacks.Update().After("gorm:update")},
+		{"delete", callbacks.Delete().Before("gorm:delete"), callbacks.Delete().After("gorm:delete")},
+		{"row", callbacks.Row().Before("gorm:row"), callbacks.Row().After("gorm:row")},
+		{"raw", callbacks.Raw().Before("gorm:raw"), callbacks.Raw().After("gorm:raw")},
+	} {
+		if err := c.before.Register("crud:metrics_before_"+c.operation, p.before); err != nil {
+			return err
+		}
+		if err := c.after.Register("crud:metrics_after_"+c.operation, p.after(c.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *plugin) before(db *gorm.DB) {
+	db.InstanceSet(startKey, time.Now())
+}
+
+func (p *plugin) after(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		start, _ := value.(time.Time)
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		p.metrics.queries.WithLabelValues(table, operation).
+			Observe(time.Since(start).Seconds())
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			p.metrics.queryErrors.WithLabelValues(table, operation).Inc()
+		}
+	}
+}
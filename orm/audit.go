@@ -0,0 +1,52 @@
+package orm
+
+import "context"
+
+// AuditedModel is a BasicModel recording who created, updated and
+// (soft) deleted the record:
+//
+//	ID, CreatedAt, UpdatedAt, DeletedAt, CreatedBy, UpdatedBy, DeletedBy
+//
+// The services fill the *By fields with the actor in the context (see
+// WithActor, auth.SetPrincipal sets it to the Subject of the Principal),
+// and clients can not write them: the values given to the services are
+// ignored.
+type AuditedModel struct {
+	BasicModel
+	CreatedBy string `gorm:"size:255" crud:"write="`
+	UpdatedBy string `gorm:"size:255" crud:"write="`
+	DeletedBy string `gorm:"size:255" crud:"write="`
+}
+
+// Audit returns the AuditedModel to be filled.
+func (m *AuditedModel) Audit() *AuditedModel {
+	return m
+}
+
+// Audited is a model embedding AuditedModel (the pointer to it).
+type Audited interface {
+	Audit() *AuditedModel
+}
+
+// ActorKey is the key to store the actor in gin.Context (c.Set),
+// which is the WithActor for a gin.Context.
+const ActorKey = "crud/orm.Actor"
+
+// actorCtxKey is the key of the actor in context.Context.
+type actorCtxKey struct{}
+
+// WithActor returns a ctx of the actor (who makes the changes), for the
+// AuditedModel.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorCtxKey{}, actor)
+}
+
+// ActorFrom returns the actor in ctx.
+func ActorFrom(ctx context.Context) (string, bool) {
+	if actor, ok := ctx.Value(actorCtxKey{}).(string); ok {
+		return actor, true
+	}
+	// a *gin.Context, or a context.Context derived from it
+	actor, ok := ctx.Value(ActorKey).(string)
+	return actor, ok
+}
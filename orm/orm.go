@@ -1,8 +1,10 @@
 package orm
 
 import (
+	"errors"
 	"github.com/tqrj/cd/log"
 	"gorm.io/gorm"
+	"sync"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
@@ -39,6 +41,7 @@ func ConnectDB(driver DBDriver, dsn string) (*gorm.DB, error) {
 	var err error
 
 	driverOpen := getDBOpener(driver)
+	connDriver, connDSN = driver, dsn
 
 	DB, err = gorm.Open(driverOpen(dsn), &gorm.Config{
 		Logger: log.Logger4Gorm,
@@ -50,6 +53,9 @@ func UseDB(db *gorm.DB) {
 	DB = db
 }
 
+// ErrNotConnected is the error of using DB before ConnectDB (or UseDB).
+var ErrNotConnected = errors.New("database not connected")
+
 // region dbOpener
 
 // DBOpener opens a gorm Dialector.
@@ -82,7 +88,8 @@ func getDBOpener(driver DBDriver) DBOpener {
 // RegisterModel registers the given model to the database.
 // Arguments should be pointers to model structs.
 //
-// It calls gorm.AutoMigrate to migrate the database.
+// It calls gorm.AutoMigrate to migrate the database, and the database of
+// every tenant if the tenancy is enabled (see EnableTenancy).
 func RegisterModel(m ...any) error {
 	err := DB.AutoMigrate(m...)
 	if err != nil {
@@ -90,5 +97,45 @@ func RegisterModel(m ...any) error {
 			Errorf("RegisterModel: AutoMigrate failed")
 		return err
 	}
+	modelsMu.Lock()
+	models = append(models, m...)
+	modelsMu.Unlock()
+	if tenancyConfig != nil {
+		return migrateTenants(m...)
+	}
 	return nil
 }
+
+var (
+	modelsMu sync.Mutex
+	models   []any // registered models, to be migrated in new tenants
+)
+
+func registeredModels() []any {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	return append([]any(nil), models...)
+}
+
+// Use applies the GORM plugin to DB, and to the DBs of the tenants opened
+// later (see EnableTenancy).
+func Use(plugin gorm.Plugin) error {
+	if err := DB.Use(plugin); err != nil {
+		return err
+	}
+	pluginsMu.Lock()
+	plugins = append(plugins, plugin)
+	pluginsMu.Unlock()
+	return nil
+}
+
+var (
+	pluginsMu sync.Mutex
+	plugins   []gorm.Plugin // used by Use, to be applied to the tenant DBs
+)
+
+func usedPlugins() []gorm.Plugin {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	return append([]gorm.Plugin(nil), plugins...)
+}
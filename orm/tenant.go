@@ -0,0 +1,416 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tenant is a provisioned tenant, recorded in the tenants table of the
+// base DB (the DB connected by ConnectDB).
+type Tenant struct {
+	ID        string `gorm:"primaryKey;size:48" json:"id" binding:"required"`
+	CreatedAt time.Time
+}
+
+func (t Tenant) Identity() (fieldName string, value any) {
+	return "ID", t.ID
+}
+
+// TenancyMode is the way tenants are isolated.
+type TenancyMode string
+
+const (
+	// TenancySchema puts each tenant in a postgres schema, selected by
+	// the search_path of the connections.
+	TenancySchema TenancyMode = "schema"
+	// TenancyDatabase puts each tenant in a separate database, e.g. a
+	// sqlite file per tenant.
+	TenancyDatabase TenancyMode = "database"
+)
+
+// TenancyOption configures the tenancy.
+type TenancyOption func(t *tenancy)
+
+// WithTenancyMode sets the mode. Default: TenancySchema for postgres,
+// TenancyDatabase for the others.
+func WithTenancyMode(mode TenancyMode) TenancyOption {
+	return func(t *tenancy) {
+		t.mode = mode
+	}
+}
+
+// WithTenantSchema sets the pattern of the schema names, where %s is the
+// tenant id. Default "tenant_%s".
+func WithTenantSchema(pattern string) TenancyOption {
+	return func(t *tenancy) {
+		t.schema = pattern
+	}
+}
+
+// WithTenantDSN sets the pattern of the dsn of tenant databases, where %s
+// is the tenant id, e.g. "data/tenant_%s.db". Default: the tenant id is
+// inserted before the extension of the sqlite dsn (app.db => app_acme.db).
+// It's required for mysql.
+func WithTenantDSN(pattern string) TenancyOption {
+	return func(t *tenancy) {
+		t.dsn = pattern
+	}
+}
+
+type tenancy struct {
+	mode   TenancyMode
+	schema string
+	dsn    string
+
+	mu  sync.Mutex
+	dbs map[string]*gorm.DB // opened tenant DBs
+}
+
+var (
+	tenancyConfig *tenancy
+	// the driver and dsn given to ConnectDB, to open the tenant DBs
+	connDriver DBDriver
+	connDSN    string
+)
+
+// tenantIDPattern restricts tenant ids to be safe schema names and files.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9_]{1,48}$`)
+
+// EnableTenancy enables the multi-tenancy: the services work on the DB of
+// the tenant in ctx (see WithTenant, and router.WithTenancy to resolve it
+// from the requests), which is a postgres schema (the search_path is set
+// on the connections) or a separate database of the tenant.
+//
+// It must be called after ConnectDB, and before RegisterModel: the models
+// registered are migrated in the base DB and in every tenant.
+// Tenants are created by ProvisionTenant and removed by DropTenant.
+//
+// It fails with ErrTenancyUnsupported if a feature working on the base DB
+// only is enabled (see RequireBaseDB), e.g. the change log and the
+// webhooks: their readers would never see the changes in the tenants.
+func EnableTenancy(options ...TenancyOption) error {
+	var features []string
+	baseDBOnly.Range(func(feature, _ any) bool {
+		features = append(features, feature.(string))
+		return true
+	})
+	if len(features) != 0 {
+		sort.Strings(features)
+		return fmt.Errorf("%w: %s work on the base DB only", ErrTenancyUnsupported, strings.Join(features, ", "))
+	}
+
+	t := &tenancy{schema: "tenant_%s", dbs: map[string]*gorm.DB{}}
+	if connDriver == DBDriverPostgres {
+		t.mode = TenancySchema
+	} else {
+		t.mode = TenancyDatabase
+	}
+	for _, option := range options {
+		option(t)
+	}
+	switch {
+	case t.mode == TenancySchema && connDriver != DBDriverPostgres:
+		return fmt.Errorf("%w: schema mode requires postgres", ErrTenancyUnsupported)
+	case t.mode == TenancyDatabase && t.dsn == "" && connDriver == DBDriverSqlite:
+		t.dsn = defaultTenantDSN(connDSN)
+	case t.mode == TenancyDatabase && t.dsn == "":
+		return fmt.Errorf("%w: WithTenantDSN is required for %s", ErrTenancyUnsupported, connDriver)
+	}
+	if err := DB.AutoMigrate(&Tenant{}); err != nil {
+		logger.WithError(err).Error("EnableTenancy: migrate tenants failed")
+		return err
+	}
+	tenancyConfig = t
+	return nil
+}
+
+// baseDBOnly is the set of the features declared by RequireBaseDB:
+// name => struct{}.
+var baseDBOnly sync.Map
+
+// RequireBaseDB declares that the feature (e.g. "changelog") works on the
+// base DB only, so it can not work with the tenancy: it fails with
+// ErrTenancyUnsupported if the tenancy is enabled, and EnableTenancy fails
+// after it.
+func RequireBaseDB(feature string) error {
+	if TenancyEnabled() {
+		return fmt.Errorf("%w: %s works on the base DB only", ErrTenancyUnsupported, feature)
+	}
+	baseDBOnly.Store(feature, struct{}{})
+	return nil
+}
+
+// defaultTenantDSN inserts "_%s" before the extension of the sqlite dsn:
+//
+//	app.db                         => app_%s.db
+//	file:app.db?cache=shared       => file:app_%s.db?cache=shared
+func defaultTenantDSN(dsn string) string {
+	dsn = strings.ReplaceAll(dsn, "%", "%%")
+	path, query, _ := strings.Cut(dsn, "?")
+	if i := strings.LastIndex(path, "."); i > strings.LastIndex(path, "/") {
+		path = path[:i] + "_%s" + path[i:]
+	} else {
+		path += "_%s"
+	}
+	if query != "" {
+		return path + "?" + query
+	}
+	return path
+}
+
+// TenantKey is the key to store the tenant id in gin.Context (c.Set),
+// which is the WithTenant for a gin.Context.
+const TenantKey = "crud/orm.Tenant"
+
+// tenantCtxKey is the key of the tenant id in context.Context.
+type tenantCtxKey struct{}
+
+// WithTenant returns a ctx of the tenant, services called with the ctx
+// work on the DB of the tenant.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenantID)
+}
+
+// TenantFrom returns the tenant id in ctx.
+func TenantFrom(ctx context.Context) (string, bool) {
+	if tenantID, ok := ctx.Value(tenantCtxKey{}).(string); ok {
+		return tenantID, true
+	}
+	// a *gin.Context, or a context.Context derived from it
+	tenantID, ok := ctx.Value(TenantKey).(string)
+	return tenantID, ok
+}
+
+// TenancyEnabled reports whether EnableTenancy is called.
+func TenancyEnabled() bool {
+	return tenancyConfig != nil
+}
+
+// DBFor returns the DB of the tenant in ctx, or the base DB if there is no
+// tenant in ctx (or the tenancy is not enabled). An unknown tenant
+// results in a DB with ErrUnknownTenant. The DB is with the
+// QueryContext(ctx).
+func DBFor(ctx context.Context) *gorm.DB {
+	tenantID, ok := TenantFrom(ctx)
+	if !ok || tenancyConfig == nil {
+		return DB.WithContext(QueryContext(ctx))
+	}
+	db, err := tenancyConfig.open(ctx, tenantID, false)
+	if err != nil {
+		db = DB.WithContext(QueryContext(ctx))
+		_ = db.AddError(err)
+		return db
+	}
+	return db.WithContext(QueryContext(ctx))
+}
+
+// TenantExists reports whether the tenant is provisioned.
+func TenantExists(ctx context.Context, tenantID string) (bool, error) {
+	if tenancyConfig == nil {
+		return false, ErrTenancyDisabled
+	}
+	_, err := tenancyConfig.open(ctx, tenantID, false)
+	if errors.Is(err, ErrUnknownTenant) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Tenants lists the provisioned tenants.
+func Tenants(ctx context.Context) ([]Tenant, error) {
+	if tenancyConfig == nil {
+		return nil, ErrTenancyDisabled
+	}
+	var tenants []Tenant
+	err := DB.WithContext(ctx).Order("id").Find(&tenants).Error
+	return tenants, err
+}
+
+// ProvisionTenant creates the schema (or database) of the tenant, and
+// migrates the registered models in it. It's idempotent, so it can also
+// be used to re-run the migrations of a tenant.
+func ProvisionTenant(ctx context.Context, tenantID string) error {
+	t := tenancyConfig
+	if t == nil {
+		return ErrTenancyDisabled
+	}
+	if !tenantIDPattern.MatchString(tenantID) {
+		return fmt.Errorf("%w: %q", ErrBadTenantID, tenantID)
+	}
+	logger := logger.WithContext(ctx).WithField("tenant", tenantID)
+
+	if t.mode == TenancySchema {
+		err := DB.WithContext(ctx).
+			Exec(fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %q`, t.schemaName(tenantID))).Error
+		if err != nil {
+			logger.WithError(err).Error("ProvisionTenant: create schema failed")
+			return err
+		}
+	}
+	db, err := t.open(ctx, tenantID, true)
+	if err != nil {
+		logger.WithError(err).Error("ProvisionTenant: open tenant DB failed")
+		return err
+	}
+	if err := db.WithContext(ctx).AutoMigrate(registeredModels()...); err != nil {
+		logger.WithError(err).Error("ProvisionTenant: migrate failed")
+		return err
+	}
+	err = DB.WithContext(ctx).Where(Tenant{ID: tenantID}).
+		FirstOrCreate(&Tenant{ID: tenantID}).Error
+	if err != nil {
+		logger.WithError(err).Error("ProvisionTenant: record tenant failed")
+		return err
+	}
+	logger.Info("ProvisionTenant: tenant provisioned")
+	return nil
+}
+
+// DropTenant drops the schema of the tenant with all its data
+// (DROP SCHEMA ... CASCADE). For the database mode, the connections are
+// closed and the tenant is removed from the tenants table, but the
+// database (file) is kept, remove it by yourself.
+func DropTenant(ctx context.Context, tenantID string) error {
+	t := tenancyConfig
+	if t == nil {
+		return ErrTenancyDisabled
+	}
+	if ok, err := TenantExists(ctx, tenantID); err != nil || !ok {
+		if err == nil {
+			err = ErrUnknownTenant
+		}
+		return err
+	}
+	logger := logger.WithContext(ctx).WithField("tenant", tenantID)
+
+	t.close(tenantID)
+	if t.mode == TenancySchema {
+		err := DB.WithContext(ctx).
+			Exec(fmt.Sprintf(`DROP SCHEMA IF EXISTS %q CASCADE`, t.schemaName(tenantID))).Error
+		if err != nil {
+			logger.WithError(err).Error("DropTenant: drop schema failed")
+			return err
+		}
+	}
+	if err := DB.WithContext(ctx).Delete(&Tenant{ID: tenantID}).Error; err != nil {
+		logger.WithError(err).Error("DropTenant: delete tenant failed")
+		return err
+	}
+	logger.Info("DropTenant: tenant dropped")
+	return nil
+}
+
+// migrateTenants migrates the models in all the provisioned tenants.
+func migrateTenants(models ...any) error {
+	tenants, err := Tenants(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		db, err := tenancyConfig.open(context.Background(), tenant.ID, true)
+		if err == nil {
+			err = db.AutoMigrate(models...)
+		}
+		if err != nil {
+			logger.WithError(err).WithField("tenant", tenant.ID).
+				Error("RegisterModel: migrate tenant failed")
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tenancy) schemaName(tenantID string) string {
+	return fmt.Sprintf(t.schema, tenantID)
+}
+
+// open returns the DB of the tenant, opening it if not yet. The tenant
+// must be provisioned unless force.
+func (t *tenancy) open(ctx context.Context, tenantID string, force bool) (*gorm.DB, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if db, ok := t.dbs[tenantID]; ok {
+		return db, nil
+	}
+	if !tenantIDPattern.MatchString(tenantID) {
+		return nil, fmt.Errorf("%w: %q", ErrBadTenantID, tenantID)
+	}
+	if !force {
+		var count int64
+		err := DB.WithContext(ctx).Model(&Tenant{}).Where("id = ?", tenantID).Count(&count).Error
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownTenant, tenantID)
+		}
+	}
+
+	var dsn string
+	if t.mode == TenancySchema {
+		dsn = withSearchPath(connDSN, t.schemaName(tenantID))
+	} else {
+		dsn = fmt.Sprintf(t.dsn, tenantID)
+	}
+	db, err := gorm.Open(getDBOpener(connDriver)(dsn), &gorm.Config{
+		Logger:         DB.Config.Logger,
+		NamingStrategy: DB.Config.NamingStrategy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if statementTimeoutsEnabled() {
+		if err := registerStatementTimeouts(db); err != nil {
+			return nil, err
+		}
+	}
+	for _, plugin := range usedPlugins() {
+		if err := db.Use(plugin); err != nil {
+			return nil, err
+		}
+	}
+	t.dbs[tenantID] = db
+	return db, nil
+}
+
+// close closes the connections of the tenant DB.
+func (t *tenancy) close(tenantID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if db, ok := t.dbs[tenantID]; ok {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+		delete(t.dbs, tenantID)
+	}
+}
+
+// withSearchPath sets the search_path of the postgres dsn, in the URL
+// (postgres://...) or the keyword/value (host=... user=...) format.
+func withSearchPath(dsn string, schema string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err == nil {
+			query := u.Query()
+			query.Set("search_path", schema)
+			u.RawQuery = query.Encode()
+			return u.String()
+		}
+	}
+	return dsn + " search_path=" + schema
+}
+
+var (
+	ErrTenancyDisabled    = errors.New("tenancy is not enabled")
+	ErrTenancyUnsupported = errors.New("tenancy unsupported")
+	ErrUnknownTenant      = errors.New("unknown tenant")
+	ErrBadTenantID        = errors.New("bad tenant id")
+)
@@ -0,0 +1,28 @@
+package orm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRequireBaseDB(t *testing.T) {
+	if _, err := ConnectDB(DBDriverSqlite, "file:requirebasedb?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RequireBaseDB("feed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableTenancy(); !errors.Is(err, ErrTenancyUnsupported) || TenancyEnabled() {
+		t.Errorf("EnableTenancy after a base DB only feature: want ErrTenancyUnsupported, got %v", err)
+	}
+	baseDBOnly.Delete("feed")
+
+	if err := EnableTenancy(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { tenancyConfig = nil }()
+	if err := RequireBaseDB("feed"); !errors.Is(err, ErrTenancyUnsupported) {
+		t.Errorf("RequireBaseDB with the tenancy: want ErrTenancyUnsupported, got %v", err)
+	}
+}
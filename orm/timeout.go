@@ -0,0 +1,146 @@
+package orm
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// QueryContext returns the ctx for the database queries.
+//
+// A *gin.Context (or a context.Context derived from it) does not show the
+// deadline of its http request, unless gin.Engine.ContextWithFallback is
+// set. So the deadline (set by controller.TimeoutMiddleware) and the
+// cancellation of the request are taken from the request here, while the
+// values are still looked up in ctx.
+func QueryContext(ctx context.Context) context.Context {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx
+	}
+	request, ok := ctx.Value(0).(*http.Request) // gin.Context.Value(0) is its Request
+	if !ok || request == nil {
+		return ctx
+	}
+	if _, ok := request.Context().Deadline(); !ok {
+		return ctx
+	}
+	return requestContext{Context: ctx, request: request.Context()}
+}
+
+// requestContext is a ctx with the deadline of the request.
+type requestContext struct {
+	context.Context
+	request context.Context
+}
+
+func (c requestContext) Deadline() (time.Time, bool) { return c.request.Deadline() }
+func (c requestContext) Done() <-chan struct{}       { return c.request.Done() }
+func (c requestContext) Err() error                  { return c.request.Err() }
+
+var statementTimeouts struct {
+	sync.Mutex
+	enabled bool
+}
+
+// EnableStatementTimeouts makes the database server stop the statements
+// running past the deadline of their ctx, in addition to the cancellation
+// by the driver (which may leave the statement running on the server):
+//
+//   - mysql: SELECTs get the MAX_EXECUTION_TIME(ms) optimizer hint.
+//   - postgres: statements in transactions run with SET LOCAL
+//     statement_timeout. Others are cancelled by the driver.
+//
+// It does nothing on the other databases. It should be called after
+// ConnectDB, and works for the tenant DBs as well.
+func EnableStatementTimeouts() error {
+	statementTimeouts.Lock()
+	defer statementTimeouts.Unlock()
+	if statementTimeouts.enabled {
+		return nil
+	}
+	if err := registerStatementTimeouts(DB); err != nil {
+		return err
+	}
+	statementTimeouts.enabled = true
+	return nil
+}
+
+// statementTimeoutsEnabled reports whether EnableStatementTimeouts is
+// called, for the DBs opened later.
+func statementTimeoutsEnabled() bool {
+	statementTimeouts.Lock()
+	defer statementTimeouts.Unlock()
+	return statementTimeouts.enabled
+}
+
+const statementTimeoutCallback = "crud:statement_timeout"
+
+func registerStatementTimeouts(db *gorm.DB) error {
+	callbacks := db.Callback()
+	switch db.Dialector.Name() {
+	case DBDriverMySQL:
+		return callbacks.Query().Before("gorm:query").Register(statementTimeoutCallback, mysqlStatementTimeout)
+	case DBDriverPostgres:
+		for _, err := range []error{
+			callbacks.Create().Before("gorm:create").Register(statementTimeoutCallback, postgresStatementTimeout),
+			callbacks.Query().Before("gorm:query").Register(statementTimeoutCallback, postgresStatementTimeout),
+			callbacks.Update().Before("gorm:update").Register(statementTimeoutCallback, postgresStatementTimeout),
+			callbacks.Delete().Before("gorm:delete").Register(statementTimeoutCallback, postgresStatementTimeout),
+			callbacks.Row().Before("gorm:row").Register(statementTimeoutCallback, postgresStatementTimeout),
+			callbacks.Raw().Before("gorm:raw").Register(statementTimeoutCallback, postgresStatementTimeout),
+		} {
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remainingMillis returns the time left to the deadline of the statement
+// ctx in milliseconds, at least 1.
+func remainingMillis(db *gorm.DB) (int64, bool) {
+	if db.Statement.Context == nil {
+		return 0, false
+	}
+	deadline, ok := db.Statement.Context.Deadline()
+	if !ok {
+		return 0, false
+	}
+	ms := time.Until(deadline).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return ms, true
+}
+
+func mysqlStatementTimeout(db *gorm.DB) {
+	ms, ok := remainingMillis(db)
+	if !ok {
+		return
+	}
+	c := db.Statement.Clauses["SELECT"]
+	c.AfterNameExpression = clause.Expr{SQL: fmt.Sprintf("/*+ MAX_EXECUTION_TIME(%d) */", ms)}
+	db.Statement.Clauses["SELECT"] = c
+}
+
+func postgresStatementTimeout(db *gorm.DB) {
+	ms, ok := remainingMillis(db)
+	if !ok {
+		return
+	}
+	// SET LOCAL lasts until the end of the transaction, out of a
+	// transaction it would do nothing.
+	if _, inTx := db.Statement.ConnPool.(gorm.TxCommitter); !inTx {
+		return
+	}
+	_, err := db.Statement.ConnPool.ExecContext(db.Statement.Context,
+		fmt.Sprintf("SET LOCAL statement_timeout = %d", ms))
+	if err != nil {
+		_ = db.AddError(err)
+	}
+}
@@ -0,0 +1,47 @@
+package orm
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// slowQuery counts to 100 million on sqlite, which takes seconds.
+const slowQuery = "WITH RECURSIVE cnt(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM cnt LIMIT 100000000) SELECT count(*) FROM cnt"
+
+func TestQueryContext(t *testing.T) {
+	if _, err := ConnectDB(DBDriverSqlite, "file:querycontext?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/", nil)
+	if _, ok := QueryContext(c).Deadline(); ok {
+		t.Errorf("deadline without a timeout")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	if _, ok := c.Deadline(); ok {
+		t.Fatal("gin.Context shows the request deadline, the test is moot")
+	}
+	if _, ok := QueryContext(c).Deadline(); !ok {
+		t.Errorf("the deadline of the request is lost")
+	}
+
+	start := time.Now()
+	var n int64
+	err := DBFor(c).Raw(slowQuery).Scan(&n).Error
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("query is not cancelled at the deadline: took %v", elapsed)
+	}
+	if err == nil || !errors.Is(QueryContext(c).Err(), context.DeadlineExceeded) {
+		t.Errorf("want the query cancelled by the deadline, got %v (n=%d)", err, n)
+	}
+}
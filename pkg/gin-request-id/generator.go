@@ -0,0 +1,74 @@
+package gin_request_id
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/gofrs/uuid"
+	"time"
+)
+
+// Generator generates request ids.
+type Generator func() string
+
+// UUIDv4 generates random UUIDs:
+//
+//	0b5a1f9c-2f5e-4c8e-9a43-5e4f3c7b2d1a
+func UUIDv4() Generator {
+	return func() string {
+		return uuid.Must(uuid.NewV4()).String()
+	}
+}
+
+// UUIDv7 generates UUIDs ordered by time (in milliseconds):
+//
+//	018b4a3e-9d2c-7f3a-b1e4-2c6d8e0f1a2b
+func UUIDv7() Generator {
+	return func() string {
+		return uuid.Must(uuid.NewV7()).String()
+	}
+}
+
+// crockford is the Crockford's base32 alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates ULIDs (48 bits of time in milliseconds, and 80 random
+// bits), which are ordered by time:
+//
+//	01HBX3Q9T1ZB2Y7K8Q4M6N0P3R
+//
+// See https://github.com/ulid/spec
+func ULID() Generator {
+	return func() string {
+		var b [16]byte
+		binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixMilli())<<16)
+		mustRead(b[6:])
+
+		// 128 bits into 26 characters of 5 bits, from the lowest bits
+		hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+		var s [26]byte
+		for i := len(s) - 1; i >= 0; i-- {
+			s[i] = crockford[lo&31]
+			lo = lo>>5 | hi<<59
+			hi >>= 5
+		}
+		return string(s[:])
+	}
+}
+
+// Random generates n random bytes in hex.
+func Random(n int) Generator {
+	return func() string {
+		b := make([]byte, n)
+		mustRead(b)
+		return hex.EncodeToString(b)
+	}
+}
+
+// mustRead fills b with random bytes. It panics if the system random
+// source fails, as uuid.Must does.
+func mustRead(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+}
@@ -1,54 +1,195 @@
 package gin_request_id
 
 import (
-	"fmt"
+	"context"
 	"github.com/gin-gonic/gin"
-	"github.com/gofrs/uuid"
-	"time"
+	"strings"
 )
 
+// Key is the key to store the request id in gin.Context (c.Set).
+const Key = "request_id"
+
+// ctxKey is the key of the request id in context.Context.
+type ctxKey struct{}
+
+// WithRequestID returns a ctx with the request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// From returns the request id in ctx: a context.Context from WithRequestID
+// (e.g. the context of a request through the RequestID middleware), or a
+// *gin.Context (or a context.Context derived from it).
+func From(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	if id, ok := ctx.Value(ctxKey{}).(string); ok {
+		return id, true
+	}
+	id, ok := ctx.Value(Key).(string)
+	return id, ok
+}
+
+// config of the RequestID middleware.
+type config struct {
+	headers     []string
+	traceParent bool
+	generator   Generator
+	maxLength   int
+	validate    func(id string) bool
+}
+
+// Option is an option of the RequestID middleware.
+type Option func(c *config)
+
+// WithHeaders sets the request headers to read the request id from, in
+// order, default "X-Request-Id". The first one is the response header.
+func WithHeaders(headers ...string) Option {
+	return func(c *config) {
+		if len(headers) > 0 {
+			c.headers = headers
+		}
+	}
+}
+
+// WithTraceParent uses the trace id of the W3C traceparent header as the
+// request id, if the request has no id in the headers. So the logs of the
+// request can be found by the trace id of the caller.
+func WithTraceParent() Option {
+	return func(c *config) {
+		c.traceParent = true
+	}
+}
+
+// WithGenerator generates the missing (or invalid) request ids by
+// generator, default UUIDv4.
+func WithGenerator(generator Generator) Option {
+	return func(c *config) {
+		c.generator = generator
+	}
+}
+
+// WithMaxLength sets the max length of the request ids from the clients,
+// default 64. Longer ones are replaced by generated ids.
+func WithMaxLength(n int) Option {
+	return func(c *config) {
+		c.maxLength = n
+	}
+}
+
+// WithValidator validates the request ids from the clients, invalid ones
+// are replaced by generated ids. The default accepts letters, digits and
+// "-_.:+=/".
+func WithValidator(validate func(id string) bool) Option {
+	return func(c *config) {
+		c.validate = validate
+	}
+}
+
 // RequestID is a middleware that adds a `request_id` value to the context as
 // well as a `X-Request-ID` header to the response.
 //
-// The `request_id` is got from the request header "X-Request-ID", and
-// if not found, a new one is generated as a UUIDv3 based on the client IP,
-// request method, request URI and time (time is got from
-// `c.GetString("start_time")` and if not found, it is set to time.Now())
+// The `request_id` is got from the request header "X-Request-ID" (see
+// WithHeaders), or the traceparent header (if WithTraceParent), and if not
+// found, or not valid (see WithMaxLength and WithValidator), a new one is
+// generated (see WithGenerator).
 //
-// All request_ids are generated by a single instance of uuid.Gen with a
-// constant namespace, which is a random UUIDv4 (or uuid.Nil if errs),
-// determined when this middleware is initialized.
+// The `request_id` is set into the gin.Context (c.Set(Key, id)) and the
+// context of the request, so it's found by From in both (and the contexts
+// derived from them), e.g. by log.RequestIDHook.
 //
 // An early Use of this middleware is recommended to make sure the
 // request_id is set for other middlewares.
-func RequestID() gin.HandlerFunc {
-	uuidGen := uuid.NewGen()
-	ns, err := uuidGen.NewV4()
-	if err != nil {
-		ns = uuid.Nil
+func RequestID(options ...Option) gin.HandlerFunc {
+	cfg := &config{
+		headers:   []string{"X-Request-Id"},
+		generator: UUIDv4(),
+		maxLength: 64,
+		validate:  validID,
+	}
+	for _, option := range options {
+		option(cfg)
 	}
-	fmt.Printf("RequestID middleware: namespace=%v\n", ns)
 
 	return func(c *gin.Context) {
-		id := c.Request.Header.Get("X-Request-Id")
-		if id == "" {
-			startTime := c.GetString("start_time")
-			if startTime == "" {
-				startTime = time.Now().String()
-			}
-			uid := uuidGen.NewV3(ns,
-				c.ClientIP()+
-					c.Request.Method+
-					c.Request.RequestURI+
-					startTime,
-			)
-			// get base64
-
-			uid.Bytes()
-			id = uid.String()
-		}
-		c.Set("request_id", id)
-		c.Header("X-Request-Id", id)
+		id := cfg.requestID(c)
+		c.Set(Key, id)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), id))
+		c.Header(cfg.headers[0], id)
 		c.Next()
 	}
 }
+
+// requestID returns the valid request id from the headers of the request,
+// or a generated one.
+func (cfg *config) requestID(c *gin.Context) string {
+	for _, header := range cfg.headers {
+		if id := c.GetHeader(header); id != "" && cfg.valid(id) {
+			return id
+		}
+	}
+	if cfg.traceParent {
+		if traceID, ok := ParseTraceParent(c.GetHeader("traceparent")); ok {
+			return traceID
+		}
+	}
+	return cfg.generator()
+}
+
+func (cfg *config) valid(id string) bool {
+	if cfg.maxLength > 0 && len(id) > cfg.maxLength {
+		return false
+	}
+	return cfg.validate == nil || cfg.validate(id)
+}
+
+// validID reports whether id only contains letters, digits and "-_.:+=/".
+func validID(id string) bool {
+	for _, r := range id {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case strings.ContainsRune("-_.:+=/", r):
+		default:
+			return false
+		}
+	}
+	return id != ""
+}
+
+// ParseTraceParent returns the trace id of the W3C traceparent header:
+//
+//	traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+//	             version-trace_id-parent_id-flags
+//
+// See https://www.w3.org/TR/trace-context/#traceparent-header
+func ParseTraceParent(header string) (traceID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return "", false
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return "", false
+	}
+	if !isHex(traceID, 32) || !isHex(parentID, 16) || !isHex(flags, 2) {
+		return "", false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return "", false
+	}
+	return traceID, true
+}
+
+// isHex reports whether s is n lowercase hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, r := range s {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
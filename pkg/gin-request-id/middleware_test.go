@@ -0,0 +1,134 @@
+package gin_request_id
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serve requests / with headers through the RequestID, and returns the
+// request ids seen by the handler from the gin.Context and the request
+// context, and the response header.
+func serve(t *testing.T, handler gin.HandlerFunc, headers map[string]string) (fromGin, fromRequest, response string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(handler)
+	r.GET("/", func(c *gin.Context) {
+		fromGin, _ = From(c)
+		fromRequest, _ = From(c.Request.Context())
+	})
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, request)
+	return fromGin, fromRequest, w.Header().Get("X-Request-Id")
+}
+
+func TestRequestID(t *testing.T) {
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	for _, tt := range []struct {
+		name    string
+		options []Option
+		headers map[string]string
+		want    string // "" for a generated one
+	}{
+		{"client id", nil, map[string]string{"X-Request-Id": "abc-123"}, "abc-123"},
+		{"generated", nil, nil, ""},
+		{"invalid", nil, map[string]string{"X-Request-Id": "a b\n"}, ""},
+		{"too long", nil, map[string]string{"X-Request-Id": strings.Repeat("a", 65)}, ""},
+		{"max length", []Option{WithMaxLength(100)},
+			map[string]string{"X-Request-Id": strings.Repeat("a", 65)}, strings.Repeat("a", 65)},
+		{"headers", []Option{WithHeaders("X-Request-Id", "X-Correlation-Id")},
+			map[string]string{"X-Correlation-Id": "corr"}, "corr"},
+		{"trace parent", []Option{WithTraceParent()},
+			map[string]string{"traceparent": traceParent}, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"trace parent off", nil, map[string]string{"traceparent": traceParent}, ""},
+		{"header before trace parent", []Option{WithTraceParent()},
+			map[string]string{"traceparent": traceParent, "X-Request-Id": "abc"}, "abc"},
+		{"generator", []Option{WithGenerator(func() string { return "fixed" })}, nil, "fixed"},
+		{"validator", []Option{WithValidator(func(id string) bool { return id == "ok" }),
+			WithGenerator(func() string { return "gen" })},
+			map[string]string{"X-Request-Id": "abc"}, "gen"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fromGin, fromRequest, response := serve(t, RequestID(tt.options...), tt.headers)
+			if fromGin != fromRequest || fromGin != response {
+				t.Errorf("ids: gin %q, request %q, response %q", fromGin, fromRequest, response)
+			}
+			if tt.want == "" && (len(fromGin) != 36 || fromGin == tt.headers["X-Request-Id"]) {
+				t.Errorf("id = %q, want a generated UUID", fromGin)
+			}
+			if tt.want != "" && fromGin != tt.want {
+				t.Errorf("id = %q, want %q", fromGin, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrom(t *testing.T) {
+	if _, ok := From(context.Background()); ok {
+		t.Error("From(Background) is ok")
+	}
+	ctx := context.WithValue(WithRequestID(context.Background(), "id"), struct{}{}, 1)
+	if id, ok := From(ctx); !ok || id != "id" {
+		t.Errorf("From = %q, %v", id, ok)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	for header, want := range map[string]string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":     "4bf92f3577b34da6a3ce929d0e0e4736",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xyz": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xyz": "",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":     "",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01":     "",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01":     "",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01":     "",
+		"00-4bf92f3577b34da6-00f067aa0ba902b7-01":                     "",
+		"": "",
+	} {
+		got, ok := ParseTraceParent(header)
+		if got != want || ok != (want != "") {
+			t.Errorf("ParseTraceParent(%q) = %q, %v, want %q", header, got, ok, want)
+		}
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	for name, tt := range map[string]struct {
+		generator Generator
+		length    int
+	}{
+		"UUIDv4":    {UUIDv4(), 36},
+		"UUIDv7":    {UUIDv7(), 36},
+		"ULID":      {ULID(), 26},
+		"Random(8)": {Random(8), 16},
+	} {
+		seen := map[string]bool{}
+		for i := 0; i < 100; i++ {
+			id := tt.generator()
+			if len(id) != tt.length || !validID(id) {
+				t.Errorf("%s: invalid id %q", name, id)
+			}
+			if seen[id] {
+				t.Errorf("%s: duplicate id %q", name, id)
+			}
+			seen[id] = true
+		}
+	}
+
+	// ULIDs are ordered by time
+	a := ULID()()
+	time.Sleep(2 * time.Millisecond)
+	if b := ULID()(); a[:10] >= b[:10] {
+		t.Errorf("ULID %q is not before %q", a, b)
+	}
+}
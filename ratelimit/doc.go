@@ -0,0 +1,24 @@
+// Package ratelimit throttles requests by token buckets.
+//
+// A Limiter takes a token from the bucket of each request, where the
+// bucket is picked by a KeyFunc: the authenticated principal (including
+// the verified API keys), or the client IP. Buckets hold Burst tokens at most, and are refilled by Rate tokens
+// every Per:
+//
+//	limiter := ratelimit.New(ratelimit.Limit{Rate: 100, Per: time.Minute},
+//	    ratelimit.WithKey(ratelimit.ByIP()))
+//
+// Use it for all the routes with router.WithRateLimit, or for an operation
+// by the RateLimit of the Crud options (enum.ListOption.RateLimit, ...).
+// Requests out of tokens are responded 429 Too Many Requests with the
+// Retry-After header, and all the limited responses have the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers.
+//
+// Buckets are kept in a Store. MemoryStore is the default, which works for
+// a single instance. Implement Store on a shared storage (e.g. redis) for
+// multiple instances, and Bucket.Take does the token bucket math.
+package ratelimit
+
+import "github.com/tqrj/cd/log"
+
+var logger = log.ZoneLogger("crud/ratelimit")
@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/orm"
+	"math"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket: it holds Burst tokens at most, and is refilled
+// by Rate tokens every Per. Burst defaults to Rate, and Per defaults to a
+// second.
+type Limit struct {
+	Rate  int
+	Per   time.Duration
+	Burst int
+}
+
+// normalized returns the limit with the defaults filled.
+func (l Limit) normalized() Limit {
+	if l.Per <= 0 {
+		l.Per = time.Second
+	}
+	if l.Burst <= 0 {
+		l.Burst = l.Rate
+	}
+	return l
+}
+
+// tokensPerSecond is the refill rate.
+func (l Limit) tokensPerSecond() float64 {
+	return float64(l.Rate) / l.Per.Seconds()
+}
+
+// Result is the state of a bucket after taking a token.
+type Result struct {
+	Allowed   bool
+	Limit     int           // the Burst
+	Remaining int           // tokens left
+	Reset     time.Duration // until the bucket is full again
+	// RetryAfter is the time to wait for a token if not Allowed.
+	RetryAfter time.Duration
+}
+
+// Bucket is the state of a token bucket, for the Store implementations.
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// Take refills the bucket to now, and takes a token from it if any.
+// A zero Bucket is full.
+func (b *Bucket) Take(limit Limit, now time.Time) Result {
+	limit = limit.normalized()
+	rate := limit.tokensPerSecond()
+	if b.Updated.IsZero() {
+		b.Tokens = float64(limit.Burst)
+	} else if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(float64(limit.Burst), b.Tokens+elapsed*rate)
+	}
+	b.Updated = now
+
+	result := Result{Limit: limit.Burst}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		result.Allowed = true
+	} else if rate > 0 {
+		result.RetryAfter = seconds((1 - b.Tokens) / rate)
+	} else {
+		result.RetryAfter = limit.Per
+	}
+	result.Remaining = int(b.Tokens)
+	if rate > 0 {
+		result.Reset = seconds((float64(limit.Burst) - b.Tokens) / rate)
+	}
+	return result
+}
+
+// Full reports whether the bucket would be full at now, i.e. it's
+// equivalent to a zero Bucket and can be dropped.
+func (b *Bucket) Full(limit Limit, now time.Time) bool {
+	limit = limit.normalized()
+	elapsed := now.Sub(b.Updated).Seconds()
+	return b.Tokens+elapsed*limit.tokensPerSecond() >= float64(limit.Burst)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Store keeps the buckets. Take must be atomic for a key.
+type Store interface {
+	// Take takes a token from the bucket of key.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// KeyFunc picks the bucket of the request. An empty key means the
+// KeyFunc can not identify the request.
+type KeyFunc func(c *gin.Context) string
+
+// ByIP keys requests by the client IP (gin.Context.ClientIP, which trusts
+// the proxies set by gin.Engine.SetTrustedProxies).
+func ByIP() KeyFunc {
+	return func(c *gin.Context) string {
+		return "ip:" + c.ClientIP()
+	}
+}
+
+// ByAPIKey keys requests by the KeyID of the Authorization: ApiKey header
+// (see auth.APIKey). The key is not verified: use it only for a limiter
+// after auth.APIKeyMiddleware (which refuses the invalid keys), otherwise
+// a client gets a new bucket for every made-up KeyID. The verified keys
+// are keyed by ByPrincipal as well.
+func ByAPIKey() KeyFunc {
+	return func(c *gin.Context) string {
+		scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "ApiKey") {
+			return ""
+		}
+		keyID, _, ok := strings.Cut(strings.TrimSpace(token), ".")
+		if !ok || keyID == "" {
+			return ""
+		}
+		return "apikey:" + keyID
+	}
+}
+
+// ByPrincipal keys requests by the subject of the authenticated principal
+// (see auth.SetPrincipal), so the limiter should be used after the auth
+// middlewares.
+func ByPrincipal() KeyFunc {
+	return func(c *gin.Context) string {
+		if subject := c.GetString(orm.ActorKey); subject != "" {
+			return "principal:" + subject
+		}
+		return ""
+	}
+}
+
+// FirstOf keys requests by the first KeyFunc giving a non-empty key.
+func FirstOf(keys ...KeyFunc) KeyFunc {
+	return func(c *gin.Context) string {
+		for _, key := range keys {
+			if k := key(c); k != "" {
+				return k
+			}
+		}
+		return ""
+	}
+}
+
+// Limiter limits the requests by a Limit for each key.
+type Limiter struct {
+	limit Limit
+	name  string
+	store Store
+	key   KeyFunc
+}
+
+// Option is an option to construct the Limiter.
+type Option func(l *Limiter)
+
+// New creates a Limiter. By default, the buckets are kept in a new
+// MemoryStore, and keyed by FirstOf(ByPrincipal(), ByIP()).
+func New(limit Limit, options ...Option) *Limiter {
+	l := &Limiter{
+		limit: limit.normalized(),
+		key:   FirstOf(ByPrincipal(), ByIP()),
+	}
+	for _, option := range options {
+		option(l)
+	}
+	if l.store == nil {
+		l.store = NewMemoryStore()
+	}
+	return l
+}
+
+// WithStore sets the Store of the buckets.
+func WithStore(store Store) Option {
+	return func(l *Limiter) {
+		l.store = store
+	}
+}
+
+// WithKey sets how to pick the bucket of a request.
+func WithKey(key KeyFunc) Option {
+	return func(l *Limiter) {
+		l.key = key
+	}
+}
+
+// WithName prefixes the keys by name, so that Limiters sharing a Store
+// have their own buckets. Limiters without a name on a shared Store share
+// the buckets of a key.
+func WithName(name string) Option {
+	return func(l *Limiter) {
+		l.name = name
+	}
+}
+
+// Take takes a token for the request. Requests without a key are not
+// limited: ok is false.
+func (l *Limiter) Take(c *gin.Context) (result Result, ok bool, err error) {
+	key := l.key(c)
+	if key == "" {
+		return Result{}, false, nil
+	}
+	if l.name != "" {
+		key = l.name + ":" + key
+	}
+	result, err = l.store.Take(c, key, l.limit)
+	return result, err == nil, err
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/orm"
+)
+
+func TestBucket(t *testing.T) {
+	limit := Limit{Rate: 2, Per: time.Second, Burst: 3}
+	now := time.Now()
+	var b Bucket
+
+	for i := 2; i >= 0; i-- {
+		r := b.Take(limit, now)
+		if !r.Allowed || r.Remaining != i || r.Limit != 3 {
+			t.Fatalf("take %d: %+v", 3-i, r)
+		}
+	}
+	r := b.Take(limit, now)
+	if r.Allowed || r.RetryAfter != 500*time.Millisecond || r.Reset != 1500*time.Millisecond {
+		t.Fatalf("empty bucket: %+v", r)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if r := b.Take(limit, now); !r.Allowed || r.Remaining != 0 {
+		t.Fatalf("refilled: %+v", r)
+	}
+	if b.Full(limit, now.Add(time.Second)) || !b.Full(limit, now.Add(1500*time.Millisecond)) {
+		t.Errorf("Full: %+v", b)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	limit := Limit{Rate: 1, Per: time.Second}
+
+	ctx := context.Background()
+	if r, _ := s.Take(ctx, "a", limit); !r.Allowed {
+		t.Errorf("a: %+v", r)
+	}
+	if r, _ := s.Take(ctx, "a", limit); r.Allowed {
+		t.Errorf("a again: %+v", r)
+	}
+	if r, _ := s.Take(ctx, "b", limit); !r.Allowed {
+		t.Errorf("b: %+v", r)
+	}
+
+	now = now.Add(2 * memorySweepInterval)
+	s.Take(ctx, "c", limit)
+	if n := s.Len(); n != 1 {
+		t.Errorf("full buckets not swept: %d left", n)
+	}
+}
+
+func TestLimiterKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := New(Limit{Rate: 1, Per: time.Hour}, WithName("list"))
+
+	take := func(header, actor string) (Result, bool) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.RemoteAddr = "10.0.0.1:1234"
+		if header != "" {
+			c.Request.Header.Set("Authorization", header)
+		}
+		if actor != "" {
+			c.Set(orm.ActorKey, actor)
+		}
+		r, ok, err := limiter.Take(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r, ok
+	}
+
+	if r, ok := take("", ""); !ok || !r.Allowed {
+		t.Errorf("ip: %v %+v", ok, r)
+	}
+	if r, _ := take("", ""); r.Allowed {
+		t.Errorf("same ip: %+v", r)
+	}
+	if r, _ := take("ApiKey fake.secret", ""); r.Allowed {
+		t.Errorf("unverified api key got a new bucket: %+v", r)
+	}
+	if r, _ := take("", "alice"); !r.Allowed {
+		t.Errorf("principal: %+v", r)
+	}
+	if r, _ := take("", "alice"); r.Allowed {
+		t.Errorf("same principal: %+v", r)
+	}
+
+	noKey := New(Limit{Rate: 1}, WithKey(ByPrincipal()))
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok, _ := noKey.Take(c); ok {
+		t.Errorf("anonymous should not be limited by ByPrincipal")
+	}
+}
@@ -0,0 +1,29 @@
+package router
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/auth"
+)
+
+// APIKeys adds the admin routes to manage API keys on relativePath:
+//
+//	   GET /apikeys
+//	  POST /apikeys                      // responds the plaintext key once
+//	  POST /apikeys/:APIKeyID/rotate     // responds the new plaintext key
+//	DELETE /apikeys/:APIKeyID            // revoke
+//
+// The base router should be protected (e.g. by an admin only middleware).
+// auth.EnableAPIKeys() should be called before, and the keys are
+// authenticated by auth.APIKeyMiddleware.
+func APIKeys(base gin.IRouter, relativePath string) gin.IRouter {
+	group := base.Group(relativePath)
+	idParam := getIdParam[auth.APIKey]()
+
+	group.GET("", auth.ListAPIKeysHandler())
+	group.POST("", auth.CreateAPIKeyHandler())
+	group.POST(fmt.Sprintf("/:%s/rotate", idParam), auth.RotateAPIKeyHandler(idParam))
+	group.DELETE(fmt.Sprintf("/:%s", idParam), auth.RevokeAPIKeyHandler(idParam))
+
+	return group
+}
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/auth"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+)
+
+type syncTodo struct {
+	orm.BasicModel
+	Title  string `json:"title"`
+	Tenant string `json:"tenant"`
+}
+
+func TestChangesTombstones(t *testing.T) {
+	if err := orm.RegisterModel(syncTodo{}); err != nil {
+		t.Fatal(err)
+	}
+	service.Scope[syncTodo](auth.OwnerScope("tenant"))
+
+	ctx := service.Unscoped(context.Background())
+	todos := map[string]*syncTodo{}
+	for _, todo := range []*syncTodo{{Title: "a1", Tenant: "a"}, {Title: "b1", Tenant: "b"}, {Title: "a2", Tenant: "a"}} {
+		if err := service.Create(ctx, todo, &enum.CreateOption{}, service.IfNotExist()); err != nil {
+			t.Fatal(err)
+		}
+		todos[todo.Title] = todo
+	}
+	if _, err := service.Delete(ctx, todos["b1"]); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := DefaultCrudOption()
+	opt.ChangesOption.Enable = true
+	opt.DelOption.Bulk = true
+	r := NewRouter()
+	r.Use(withUser)
+	Crud[syncTodo](r, "/syncs", opt)
+
+	if w := serve(r, http.MethodDelete, "/syncs?filters[title]=a2", "", "X-User", "a"); w.Code != http.StatusOK {
+		t.Fatalf("bulk DELETE: %d %s", w.Code, w.Body)
+	}
+
+	w := serve(r, http.MethodGet, "/syncs/changes?since=0", "", "X-User", "a")
+	var body struct {
+		Todos      []syncTodo `json:"syncTodos"`
+		Tombstones []struct {
+			ID string `json:"id"`
+		} `json:"tombstones"`
+		Next string `json:"next"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("changes: %d %s", w.Code, w.Body)
+	}
+	if len(body.Todos) != 1 || body.Todos[0].Title != "a1" {
+		t.Errorf("want a1 changed, got %s", w.Body)
+	}
+	var tombstones []string
+	for _, tombstone := range body.Tombstones {
+		tombstones = append(tombstones, tombstone.ID)
+	}
+	if want := fmt.Sprint(todos["a2"].ID); len(tombstones) != 1 || tombstones[0] != want {
+		t.Errorf("want only the tombstone of a2 (%s), got %v", want, tombstones)
+	}
+}
+
+type closedTodo struct {
+	orm.BasicModel
+	Title  string `json:"title"`
+	UserID string `json:"user_id"`
+}
+
+// hardTodo is deleted for good: no DeletedAt.
+type hardTodo struct {
+	ID     uint   `gorm:"primarykey"`
+	Title  string `json:"title"`
+	UserID string `json:"user_id"`
+}
+
+func (t hardTodo) Identity() (string, any) {
+	return "ID", t.ID
+}
+
+func TestChangesClosureDeletes(t *testing.T) {
+	if err := orm.RegisterModel(closedTodo{}, hardTodo{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var softIDs, hardIDs []string
+	for _, user := range []string{"alice", "bob"} {
+		soft := &closedTodo{Title: user + "'s", UserID: user}
+		hard := &hardTodo{Title: user + "'s", UserID: user}
+		if err := service.Create(ctx, soft, &enum.CreateOption{}, service.IfNotExist()); err != nil {
+			t.Fatal(err)
+		}
+		if err := service.Create(ctx, hard, &enum.CreateOption{}, service.IfNotExist()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := service.Delete(ctx, soft); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := service.Delete(ctx, hard); err != nil {
+			t.Fatal(err)
+		}
+		softIDs = append(softIDs, fmt.Sprint(soft.ID))
+		hardIDs = append(hardIDs, fmt.Sprint(hard.ID))
+	}
+
+	opt := DefaultCrudOption()
+	opt.ChangesOption.Enable = true
+	opt.ListOption.QueryOptionClosure = func(c *gin.Context, _ enum.GetRequestOptions) enum.QueryOption {
+		return service.Where("user_id = ?", c.GetHeader("X-User"))
+	}
+	r := NewRouter()
+	Crud[closedTodo](r, "/soft", opt)
+	Crud[hardTodo](r, "/hard", opt)
+
+	tombstones := func(path string) []string {
+		t.Helper()
+		w := serve(r, http.MethodGet, path+"/changes?since=0", "", "X-User", "alice")
+		var body struct {
+			Tombstones []struct {
+				ID string `json:"id"`
+			} `json:"tombstones"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("changes: %d %s", w.Code, w.Body)
+		}
+		var ids []string
+		for _, tombstone := range body.Tombstones {
+			ids = append(ids, tombstone.ID)
+		}
+		return ids
+	}
+	if ids := tombstones("/soft"); len(ids) != 1 || ids[0] != softIDs[0] {
+		t.Errorf("soft deletes: want only the tombstone of alice's (%s), got %v", softIDs[0], ids)
+	}
+	if ids := tombstones("/hard"); len(ids) != 0 {
+		t.Errorf("hard deletes: want no tombstones with a closure, got %v (bob's is %s)", ids, hardIDs[1])
+	}
+}
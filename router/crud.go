@@ -47,6 +47,7 @@ func Crud[T orm.Model](base gin.IRouter, relativePath string, opt *enum.CurdOpti
 			Info("Crud: Adding CRUD routes for model")
 	}
 
+	registerCrud(getTypeName[T](), group.BasePath())
 	crudGroups = append(crudGroups, crud[T](opt))
 
 	for _, option := range crudGroups {
@@ -80,29 +81,90 @@ func DefaultCrudOption() *enum.CurdOption {
 //	  POST /
 //	   PUT /:idParam
 //	DELETE /:idParam
+//
+// and the bulk routes if UpdateOption.Bulk or DelOption.Bulk is set:
+//
+//	 PATCH /?filters[...]
+//	DELETE /?filters[...]
+//
+// and GET /export, POST /import if ExportOption, ImportOption are enabled,
+// and GET /stream (Server-Sent Events) if StreamOption is enabled,
+// and GET /ws (WebSocket) if WebSocketOption is enabled,
+// and GET /changes (delta sync) if ChangesOption is enabled.
+//
+// The Limits of ListOption limit GET /, /stream, /ws and /changes,
+// CreateOption's limit POST / and /import, and so on. The long-lived
+// /stream and /ws are limited by the RateLimit only, and are never timed
+// out. GET /export has the Limits of ExportOption, never timed out by
+// default.
 func crud[T orm.Model](opt *enum.CurdOption) enum.CrudGroup {
 	idParam := getIdParam[T]()
 	return func(group *gin.RouterGroup) *gin.RouterGroup {
 		if opt.ListOption.Enable {
-			group.GET("", controller.GetListHandler[T](&opt.ListOption))
+			group.GET("", limited(opt.ListOption.Limits, controller.GetListHandler[T](&opt.ListOption))...)
 		}
 		if opt.GetOption.Enable {
-			group.GET(fmt.Sprintf("/:%s", idParam), controller.GetByIDHandler[T](idParam, &opt.GetOption))
+			group.GET(fmt.Sprintf("/:%s", idParam), limited(opt.GetOption.Limits, controller.GetByIDHandler[T](idParam, &opt.GetOption))...)
 		}
 		if opt.CreateOption.Enable {
-			group.POST("", controller.CreateHandler[T](&opt.CreateOption))
+			group.POST("", limited(opt.CreateOption.Limits, controller.CreateHandler[T](&opt.CreateOption))...)
 		}
 		if opt.UpdateOption.Enable {
-			group.PUT(fmt.Sprintf("/:%s", idParam), controller.UpdateHandler[T](idParam, &opt.UpdateOption))
+			group.PUT(fmt.Sprintf("/:%s", idParam), limited(opt.UpdateOption.Limits, controller.UpdateHandler[T](idParam, &opt.UpdateOption))...)
 		}
 		if opt.DelOption.Enable {
-			group.DELETE(fmt.Sprintf("/:%s", idParam), controller.DeleteHandler[T](idParam, &opt.DelOption))
+			group.DELETE(fmt.Sprintf("/:%s", idParam), limited(opt.DelOption.Limits, controller.DeleteHandler[T](idParam, &opt.DelOption))...)
+		}
+		if opt.UpdateOption.Enable && opt.UpdateOption.Bulk {
+			group.PATCH("", limited(opt.UpdateOption.Limits, controller.UpdateManyHandler[T](&opt.UpdateOption))...)
+		}
+		if opt.DelOption.Enable && opt.DelOption.Bulk {
+			group.DELETE("", limited(opt.DelOption.Limits, controller.DeleteManyHandler[T](&opt.DelOption))...)
+		}
+		if opt.ExportOption.Enable {
+			exportDefaults(&opt.ExportOption, &opt.ListOption)
+			group.GET("/export", limited(opt.ExportOption.Limits, controller.ExportHandler[T](&opt.ExportOption))...)
+		}
+		if opt.ImportOption.Enable {
+			group.POST("/import", limited(opt.CreateOption.Limits, controller.ImportHandler[T](&opt.CreateOption, &opt.UpdateOption, &opt.ImportOption))...)
+		}
+		if opt.StreamOption.Enable {
+			group.GET("/stream", limited(enum.Limits{RateLimit: opt.ListOption.RateLimit, Timeout: -1}, controller.StreamHandler[T](&opt.ListOption, &opt.StreamOption))...)
+		}
+		if opt.WebSocketOption.Enable {
+			group.GET("/ws", limited(enum.Limits{RateLimit: opt.ListOption.RateLimit, Timeout: -1}, controller.WebSocketHandler[T](&opt.GetOption, &opt.ListOption, &opt.WebSocketOption))...)
+		}
+		if opt.ChangesOption.Enable {
+			group.GET("/changes", limited(opt.ListOption.Limits, controller.ChangesHandler[T](&opt.ListOption, &opt.ChangesOption))...)
 		}
 
 		return group
 	}
 }
 
+// exportDefaults defaults the options of the export not set to the ones of
+// the list, see enum.ExportOption.
+func exportDefaults(opt *enum.ExportOption, listOpt *enum.ListOption) {
+	if opt.Omit == nil {
+		opt.Omit = listOpt.Omit
+	}
+	if opt.QueryOptionClosure == nil {
+		opt.QueryOptionClosure = listOpt.QueryOptionClosure
+	}
+	if opt.Pretreat == nil {
+		opt.Pretreat = listOpt.Pretreat
+	}
+	if opt.Authorize == nil {
+		opt.Authorize = listOpt.Authorize
+	}
+	if opt.RateLimit == nil {
+		opt.RateLimit = listOpt.RateLimit
+	}
+	if opt.Timeout == 0 {
+		opt.Timeout = -1
+	}
+}
+
 // GetNested add a GET route to the group for querying a nested model:
 //
 //	GET /:parentIdParam/field
@@ -118,9 +180,9 @@ func GetNested[P orm.Model, N orm.Model](field string, opt *enum.GetOption) enum
 				Info("Crud: Adding GET route for getting nested model")
 		}
 
-		group.GET(relativePath,
+		group.GET(relativePath, limited(opt.Limits,
 			controller.GetFieldHandler[P](parentIdParam, field, opt),
-		)
+		)...)
 		// there is no GET /:parentIdParam/:field/:childIdParam,
 		// because it is equivalent to GET /:childModel/:childIdParam.
 		// So there is also no PUT /:parentIdParam/:field/:childIdParam.
@@ -144,9 +206,9 @@ func CreateNested[P orm.Model, N orm.Model](field string, opt *enum.CreateOption
 				Info("Crud: Adding POST route for creating nested model")
 		}
 
-		group.POST(relativePath,
+		group.POST(relativePath, limited(opt.Limits,
 			controller.CreateNestedHandler[P, N](parentIdParam, field, opt),
-		)
+		)...)
 		return group
 	}
 }
@@ -154,7 +216,7 @@ func CreateNested[P orm.Model, N orm.Model](field string, opt *enum.CreateOption
 // DeleteNested add a DELETE route to the group for deleting a nested model:
 //
 //	DELETE /:parentIdParam/field/:childIdParam
-func DeleteNested[P orm.Model, T orm.Model](field string) enum.CrudGroup {
+func DeleteNested[P orm.Model, T orm.Model](field string, opt *enum.DelOption) enum.CrudGroup {
 	parentIdParam := getIdParam[P]()
 	childIdParam := getIdParam[T]()
 	return func(group *gin.RouterGroup) *gin.RouterGroup {
@@ -167,9 +229,9 @@ func DeleteNested[P orm.Model, T orm.Model](field string) enum.CrudGroup {
 				Info("Crud: Adding DELETE route for deleting nested model")
 		}
 
-		group.DELETE(relativePath,
-			controller.DeleteNestedHandler[P, T](parentIdParam, field, childIdParam),
-		)
+		group.DELETE(relativePath, limited(opt.Limits,
+			controller.DeleteNestedHandler[P, T](parentIdParam, field, childIdParam, opt),
+		)...)
 		return group
 	}
 }
@@ -187,7 +249,7 @@ func CrudNested[P orm.Model, T orm.Model](field string, opt *enum.CurdOption) en
 			group = CreateNested[P, T](field, &opt.CreateOption)(group)
 		}
 		if opt.DelOption.Enable {
-			group = DeleteNested[P, T](field)(group)
+			group = DeleteNested[P, T](field, &opt.DelOption)(group)
 		}
 		return group
 	}
@@ -1,3 +1,292 @@
 package router
 
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/auth"
+	"github.com/tqrj/cd/changelog"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+)
+
 // TODO: test Crud
+
+// TestMain connects orm.DB to an in-memory sqlite database shared by the
+// tests, with the change log enabled. Tests migrate their own models.
+func TestMain(m *testing.M) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file:router?mode=memory&cache=shared"); err != nil {
+		panic(err)
+	}
+	if err := changelog.Enable(); err != nil {
+		panic(err)
+	}
+	changelog.PollInterval = 50 * time.Millisecond
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+type bulkTodo struct {
+	orm.BasicModel
+	Title   string `json:"title"`
+	Done    bool   `json:"done"`
+	Project int    `json:"project"`
+}
+
+// serve serves the request by h, and returns the response.
+func serve(h http.Handler, method, url, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestBulkRoutes(t *testing.T) {
+	if err := orm.RegisterModel(bulkTodo{}); err != nil {
+		t.Fatal(err)
+	}
+	reset := func() {
+		orm.DB.Exec("DELETE FROM bulk_todos")
+		for i, project := range []int{1, 1, 1, 2} {
+			orm.DB.Create(&bulkTodo{BasicModel: orm.BasicModel{ID: uint(i + 1)}, Title: "t", Project: project})
+		}
+	}
+	remaining := func(cond string) (ids []uint) {
+		orm.DB.Model(&bulkTodo{}).Where(cond).Order("id").Pluck("id", &ids)
+		return ids
+	}
+
+	opt := DefaultCrudOption()
+	opt.UpdateOption.Bulk = true
+	opt.DelOption.Bulk = true
+	opt.UpdateOption.LimitID = []int64{2}
+	opt.DelOption.LimitID = []int64{2}
+	onlyProject1 := func(c *gin.Context, request enum.GetRequestOptions) enum.QueryOption {
+		return service.FilterBy("project", 1)
+	}
+	opt.UpdateOption.QueryOptionClosure = onlyProject1
+	opt.DelOption.QueryOptionClosure = onlyProject1
+
+	r := NewRouter()
+	Crud[bulkTodo](r, "/todos", opt)
+
+	t.Run("confirm", func(t *testing.T) {
+		reset()
+		for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+			if w := serve(r, method, "/todos", `{"done":true}`); w.Code != http.StatusBadRequest {
+				t.Errorf("%s without filters: want 400, got %d %s", method, w.Code, w.Body)
+			}
+		}
+		if ids := remaining("done = false"); len(ids) != 4 {
+			t.Errorf("unconfirmed writes changed the table: %v", ids)
+		}
+
+		if w := serve(r, http.MethodPatch, "/todos?confirm=true", `{"done":true}`); w.Code != http.StatusOK {
+			t.Errorf("confirmed PATCH: %d %s", w.Code, w.Body)
+		}
+		if ids := remaining("done = true"); len(ids) != 2 {
+			t.Errorf("confirmed PATCH updated %v, want [1 3]", ids)
+		}
+		if w := serve(r, http.MethodDelete, "/todos?confirm=true", ""); w.Code != http.StatusOK {
+			t.Errorf("confirmed DELETE: %d %s", w.Code, w.Body)
+		}
+		if ids := remaining("1 = 1"); len(ids) != 2 || ids[0] != 2 || ids[1] != 4 {
+			t.Errorf("confirmed DELETE left %v, want [2 4]", ids)
+		}
+	})
+
+	t.Run("LimitID and closure", func(t *testing.T) {
+		reset()
+		w := serve(r, http.MethodPatch, "/todos?filters[title]=t", `{"title":"x"}`)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"rowsAffected":2`) {
+			t.Errorf("PATCH: %d %s", w.Code, w.Body)
+		}
+		// 2 is excluded by LimitID, 4 is out of the closure (project 2)
+		if ids := remaining("title = 'x'"); len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+			t.Errorf("PATCH updated %v, want [1 3]", ids)
+		}
+
+		w = serve(r, http.MethodDelete, "/todos?filters[title]=x", "")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"rowsAffected":2`) {
+			t.Errorf("DELETE: %d %s", w.Code, w.Body)
+		}
+		if ids := remaining("1 = 1"); len(ids) != 2 || ids[0] != 2 || ids[1] != 4 {
+			t.Errorf("DELETE left %v, want [2 4]", ids)
+		}
+	})
+
+	t.Run("update id", func(t *testing.T) {
+		reset()
+		if w := serve(r, http.MethodPatch, "/todos?filters[project]=1", `{"ID":9}`); w.Code != http.StatusBadRequest {
+			t.Errorf("PATCH ID: want 400, got %d %s", w.Code, w.Body)
+		}
+	})
+}
+
+type ownedTodo struct {
+	orm.BasicModel
+	Title  string `json:"title"`
+	UserID string `json:"user_id"`
+}
+
+// withUser is a test auth middleware: the principal is the X-User header.
+func withUser(c *gin.Context) {
+	if user := c.GetHeader("X-User"); user != "" {
+		auth.SetPrincipal(c, &auth.Principal{Subject: user, Roles: []string{c.GetHeader("X-Role")}})
+	}
+}
+
+// uploadFile posts the content as the multipart file of the name.
+func uploadFile(h http.Handler, url, name, content string, header ...string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, _ := form.CreateFormFile("file", name)
+	_, _ = file.Write([]byte(content))
+	_ = form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, url, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestExportImportAuthorize(t *testing.T) {
+	if err := orm.RegisterModel(ownedTodo{}); err != nil {
+		t.Fatal(err)
+	}
+	orm.DB.Create(&ownedTodo{BasicModel: orm.BasicModel{ID: 1}, Title: "alice's", UserID: "alice"})
+
+	opt := DefaultCrudOption()
+	opt.ExportOption.Enable = true
+	opt.ImportOption.Enable = true
+	opt.ListOption.Authorize = auth.Authorize(auth.RBAC(auth.Roles{enum.OpList: {"admin"}}))
+	opt.CreateOption.Authorize = auth.Authorize(auth.Authenticated())
+	opt.UpdateOption.Authorize = auth.Authorize(auth.Owner("UserID"))
+
+	r := NewRouter()
+	r.Use(withUser)
+	Crud[ownedTodo](r, "/todos", opt)
+
+	t.Run("export", func(t *testing.T) {
+		w := serve(r, http.MethodGet, "/todos/export?format=ndjson", "", "X-User", "bob")
+		if w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "alice's") {
+			t.Errorf("export by bob: want 403, got %d %s", w.Code, w.Body)
+		}
+		w = serve(r, http.MethodGet, "/todos/export?format=ndjson", "", "X-User", "root", "X-Role", "admin")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "alice's") {
+			t.Errorf("export by admin: %d %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("upsert others", func(t *testing.T) {
+		w := uploadFile(r, "/todos/import?upsert=true", "todos.ndjson",
+			`{"ID":1,"title":"hacked","user_id":"bob"}`, "X-User", "bob")
+		if w.Code != http.StatusForbidden {
+			t.Errorf("upsert alice's todo by bob: want 403, got %d %s", w.Code, w.Body)
+		}
+		var stored ownedTodo
+		orm.DB.First(&stored, 1)
+		if stored.Title != "alice's" || stored.UserID != "alice" {
+			t.Errorf("alice's todo overwritten: %+v", stored)
+		}
+	})
+
+	t.Run("upsert own", func(t *testing.T) {
+		w := uploadFile(r, "/todos/import?upsert=true", "todos.ndjson",
+			`{"ID":1,"title":"updated"}`+"\n"+`{"ID":2,"title":"new","user_id":"alice"}`, "X-User", "alice")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"imported":2`) {
+			t.Errorf("upsert by alice: %d %s", w.Code, w.Body)
+		}
+		var stored ownedTodo
+		orm.DB.First(&stored, 1)
+		if stored.Title != "updated" || stored.UserID != "alice" {
+			t.Errorf("upsert: %+v", stored)
+		}
+	})
+}
+
+type exportedTodo struct {
+	orm.BasicModel
+	Title  string `json:"title"`
+	UserID string `json:"user_id"`
+}
+
+func TestExportDefaults(t *testing.T) {
+	if err := orm.RegisterModel(exportedTodo{}); err != nil {
+		t.Fatal(err)
+	}
+	orm.DB.Create(&[]exportedTodo{{Title: "alice's", UserID: "alice"}, {Title: "bob's", UserID: "bob"}})
+
+	opt := DefaultCrudOption()
+	opt.ExportOption.Enable = true
+	opt.ListOption.Omit = []string{"UserID"}
+	opt.ListOption.QueryOptionClosure = func(c *gin.Context, _ enum.GetRequestOptions) enum.QueryOption {
+		return service.Where("user_id = ?", c.GetHeader("X-User"))
+	}
+
+	r := NewRouter(WithTimeout(time.Nanosecond))
+	Crud[exportedTodo](r, "/todos", opt)
+
+	if w := serve(r, http.MethodGet, "/todos", "", "X-User", "alice"); w.Code != http.StatusGatewayTimeout {
+		t.Errorf("list: want 504 by the router timeout, got %d %s", w.Code, w.Body)
+	}
+	w := serve(r, http.MethodGet, "/todos/export?format=ndjson", "", "X-User", "alice")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "alice's") {
+		t.Errorf("export is timed out, or not scoped: %d %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), "bob's") || strings.Contains(w.Body.String(), "user_id") {
+		t.Errorf("export is not scoped, or omitted by the list: %s", w.Body)
+	}
+}
+
+type csvTodo struct {
+	orm.BasicModel
+	Title    string `json:"title"`
+	Done     bool   `json:"done"`
+	Priority int    `json:"priority"`
+	UserID   string `json:"user_id"`
+}
+
+func TestImportCSVCells(t *testing.T) {
+	if err := orm.RegisterModel(csvTodo{}); err != nil {
+		t.Fatal(err)
+	}
+	opt := DefaultCrudOption()
+	opt.ImportOption.Enable = true
+	r := NewRouter()
+	Crud[csvTodo](r, "/todos", opt)
+
+	w := uploadFile(r, "/todos/import", "todos.csv",
+		"title,done,priority\n"+`a,TRUE,1`+"\n"+`b,"true,""user_id"":""mallory""",2`+"\n"+`c,0,"1,""user_id"":""mallory"""`+"\n"+`d,f,Inf`)
+	if w.Code != http.StatusUnprocessableEntity || strings.Count(w.Body.String(), "invalid csv cell") != 3 {
+		t.Errorf("import of injected cells: want 422 for 3 rows, got %d %s", w.Code, w.Body)
+	}
+	var count int64
+	orm.DB.Model(&csvTodo{}).Where("user_id <> ''").Count(&count)
+	if count != 0 {
+		t.Errorf("user_id injected by the csv cells")
+	}
+
+	w = uploadFile(r, "/todos/import", "todos.csv", "title,done,priority\na,TRUE,1\nb, 0 ,-20")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"imported":2`) {
+		t.Errorf("import: %d %s", w.Code, w.Body)
+	}
+}
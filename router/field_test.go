@@ -0,0 +1,201 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+)
+
+type employee struct {
+	orm.BasicModel
+	Name   string `json:"name"`
+	Salary int    `json:"salary" crud:"read=admin,hr;write=admin"`
+	Notes  string `json:"notes" crud:"read=admin;write="`
+}
+
+func TestFieldPermissions(t *testing.T) {
+	if err := orm.RegisterModel(employee{}); err != nil {
+		t.Fatal(err)
+	}
+	controller.FieldPermissions[employee](enum.FieldPermissions{
+		"CreatedAt": {Read: []string{"admin"}},
+	})
+	ctx := context.Background()
+	alice := &employee{Name: "alice", Salary: 100, Notes: "secret"}
+	if err := service.Create(ctx, alice, &enum.CreateOption{}, service.IfNotExist()); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := DefaultCrudOption()
+	opt.UpdateOption.Bulk = true
+	opt.ImportOption.Enable = true
+	opt.StreamOption.Enable = true
+	opt.WebSocketOption.Enable = true
+	r := NewRouter()
+	r.Use(withUser)
+	Crud[employee](r, "/emps", opt)
+
+	as := func(role string) []string {
+		return []string{"X-User", role, "X-Role", role}
+	}
+	visible := func(t *testing.T, body string, fields ...string) {
+		t.Helper()
+		for _, field := range []string{`"salary"`, `"notes"`} {
+			want := false
+			for _, f := range fields {
+				want = want || `"`+f+`"` == field
+			}
+			if got := strings.Contains(body, field); got != want {
+				t.Errorf("%s visible: %v, want %v: %s", field, got, want, body)
+			}
+		}
+	}
+
+	t.Run("get", func(t *testing.T) {
+		visible(t, serve(r, http.MethodGet, "/emps/1", "", as("user")...).Body.String())
+		visible(t, serve(r, http.MethodGet, "/emps/1", "", as("hr")...).Body.String(), "salary")
+		visible(t, serve(r, http.MethodGet, "/emps/1", "", as("admin")...).Body.String(), "salary", "notes")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		w := serve(r, http.MethodGet, "/emps", "", as("user")...)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "alice") {
+			t.Fatalf("list: %d %s", w.Code, w.Body)
+		}
+		visible(t, w.Body.String())
+	})
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	t.Run("stream", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/emps/stream", nil)
+		req.Header.Set("Last-Event-ID", "0") // replays the creation of alice
+		req.Header.Set("X-User", "user")
+		req.Header.Set("X-Role", "user")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data:"); ok {
+				if !strings.Contains(data, "alice") {
+					t.Fatalf("unexpected event: %s", data)
+				}
+				visible(t, data)
+				return
+			}
+		}
+		t.Errorf("no event: %v", scanner.Err())
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		header := http.Header{"X-User": {"user"}, "X-Role": {"user"}}
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/emps/ws", header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		if err := conn.WriteJSON(gin.H{"type": "subscribe", "sub": "a", "id": "1"}); err != nil {
+			t.Fatal(err)
+		}
+		var message struct {
+			Type  string          `json:"type"`
+			Data  json.RawMessage `json:"data"`
+			Patch json.RawMessage `json:"patch"`
+		}
+		if err := conn.ReadJSON(&message); err != nil || message.Type != "subscribed" {
+			t.Fatalf("subscribe: %+v %v", message, err)
+		}
+		visible(t, string(message.Data))
+
+		alice.Name, alice.Salary = "alice2", 200
+		if _, err := service.Update(ctx, alice, &enum.UpdateOption{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.ReadJSON(&message); err != nil || message.Type != "change" {
+			t.Fatalf("change: %+v %v", message, err)
+		}
+		if !strings.Contains(string(message.Patch), "alice2") {
+			t.Errorf("patch: %s", message.Patch)
+		}
+		visible(t, string(message.Patch))
+	})
+
+	t.Run("write", func(t *testing.T) {
+		if w := serve(r, http.MethodPut, "/emps/1", `{"salary":5}`, as("user")...); w.Code != http.StatusForbidden {
+			t.Errorf("PUT: want 403, got %d %s", w.Code, w.Body)
+		}
+		if w := serve(r, http.MethodPatch, "/emps?filters[name]=alice2", `{"salary":5}`, as("user")...); w.Code != http.StatusForbidden {
+			t.Errorf("PATCH: want 403, got %d %s", w.Code, w.Body)
+		}
+		if w := uploadFile(r, "/emps/import", "emps.ndjson", `{"name":"bob","salary":5}`, as("hr")...); w.Code != http.StatusForbidden {
+			t.Errorf("import: want 403, got %d %s", w.Code, w.Body)
+		}
+		if w := serve(r, http.MethodPut, "/emps/1", `{"notes":"x"}`, as("admin")...); w.Code != http.StatusForbidden {
+			t.Errorf("PUT read only field: want 403, got %d %s", w.Code, w.Body)
+		}
+
+		var stored employee
+		orm.DB.First(&stored, 1)
+		if stored.Salary != 200 || stored.Notes != "secret" {
+			t.Errorf("written: %+v", stored)
+		}
+		if w := serve(r, http.MethodPut, "/emps/1", `{"name":"alice3"}`, as("user")...); w.Code != http.StatusOK {
+			t.Errorf("PUT writable field: %d %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("query", func(t *testing.T) {
+		for _, url := range []string{
+			"/emps?filters[salary]=200",
+			"/emps?order_by=salary",
+			"/emps?order_by=name,salary%20desc",
+			"/emps?filters_at=2000-01-01&filters_at=2100-01-01",
+		} {
+			if w := serve(r, http.MethodGet, url, "", as("user")...); w.Code != http.StatusForbidden {
+				t.Errorf("%s: want 403, got %d %s", url, w.Code, w.Body)
+			}
+		}
+		if w := serve(r, http.MethodGet, "/emps?filters_at=2000-01-01&filters_at=2100-01-01", "", as("admin")...); w.Code != http.StatusOK {
+			t.Errorf("filters_at by admin: %d %s", w.Code, w.Body)
+		}
+		if w := serve(r, http.MethodGet, "/emps?order_by=salary", "", as("hr")...); w.Code != http.StatusOK {
+			t.Errorf("order by salary by hr: %d %s", w.Code, w.Body)
+		}
+		if w := serve(r, http.MethodGet, "/emps?order_by=Name%20DESC&filters[Name]=alice3", "", as("user")...); w.Code != http.StatusOK {
+			t.Errorf("query by field names: %d %s", w.Code, w.Body)
+		}
+		// expressions and qualified names could order or filter by salary
+		for _, url := range []string{
+			"/emps?order_by=abs(salary)",
+			"/emps?order_by=CASE%20WHEN%20salary%3E100000%20THEN%200%20ELSE%201%20END",
+			"/emps?order_by=employees.salary",
+			"/emps?order_by=name%20desc%20nulls",
+			"/emps?filters[employees.salary]=200",
+			"/emps?filters[nope]=1",
+		} {
+			if w := serve(r, http.MethodGet, url, "", as("user")...); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: want 400, got %d %s", url, w.Code, w.Body)
+			}
+		}
+	})
+}
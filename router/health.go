@@ -0,0 +1,173 @@
+package router
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/config"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/orm"
+	"net/http/pprof"
+	"strings"
+	"time"
+)
+
+// healthConfig is the config of WithHealth.
+type healthConfig struct {
+	checks       []controller.HealthCheck
+	timeout      time.Duration
+	debug        bool
+	debugProtect []gin.HandlerFunc
+	debugPublic  bool
+	config       any
+}
+
+// HealthOption is an option of WithHealth.
+type HealthOption func(h *healthConfig)
+
+// WithReadinessCheck adds a check of the readiness (/readyz), e.g. to
+// ping a cache or a downstream API. The check is run with the timeout of
+// WithCheckTimeout.
+func WithReadinessCheck(name string, check func(ctx context.Context) error) HealthOption {
+	return func(h *healthConfig) {
+		h.checks = append(h.checks, controller.HealthCheck{Name: name, Check: check})
+	}
+}
+
+// WithCheckTimeout sets the timeout of each readiness check, default 2s.
+func WithCheckTimeout(timeout time.Duration) HealthOption {
+	return func(h *healthConfig) {
+		h.timeout = timeout
+	}
+}
+
+// WithDebug adds the /debug group, protected by the middlewares (e.g. an
+// auth.Middleware with auth.RequireRoles("admin")):
+//
+//	GET /debug/pprof/*       net/http/pprof
+//	GET /debug/routes        the Crud routes registered
+//	GET /debug/db            the connection pool stats of orm.DB
+//	GET /debug/config        the config given by WithDebugConfig, redacted
+//
+// The /debug group exposes the internals of the service, it must not be
+// public: WithHealth panics if no middleware is given, unless
+// WithPublicDebug.
+func WithDebug(protect ...gin.HandlerFunc) HealthOption {
+	return func(h *healthConfig) {
+		h.debug = true
+		h.debugProtect = protect
+	}
+}
+
+// WithPublicDebug adds the /debug group of WithDebug without any
+// protection, e.g. for a service listening on a local or an admin port
+// only. Don't use it on a public listener.
+func WithPublicDebug() HealthOption {
+	return func(h *healthConfig) {
+		h.debug = true
+		h.debugPublic = true
+	}
+}
+
+// WithDebugConfig shows the config (a pointer to it, to show the changes
+// reloaded by config.WatchFileChange) on GET /debug/config, with the
+// secrets redacted (see config.Redact).
+func WithDebugConfig(cfg any) HealthOption {
+	return func(h *healthConfig) {
+		h.config = cfg
+	}
+}
+
+// WithHealth adds the probes for the orchestrator (e.g. kubernetes):
+//
+//	GET /healthz    liveness: 200 if the process serves requests
+//	GET /readyz     readiness: pings orm.DB and runs the checks added
+//	                by WithReadinessCheck, 503 if any of them fails
+//
+// and the /debug group if WithDebug. It panics if the /debug group has
+// no protection and not WithPublicDebug.
+func WithHealth(options ...HealthOption) RouterOption {
+	h := &healthConfig{timeout: 2 * time.Second}
+	for _, option := range options {
+		option(h)
+	}
+	if h.debug && len(h.debugProtect) == 0 && !h.debugPublic {
+		panic("router: WithDebug without any middleware: the /debug group would be public, protect it or use WithPublicDebug")
+	}
+
+	checks := []controller.HealthCheck{{Name: "db", Check: pingDB}}
+	checks = append(checks, h.checks...)
+	for i := range checks {
+		checks[i].Timeout = h.timeout
+	}
+
+	return func(router gin.IRouter) gin.IRouter {
+		router.GET("/healthz", controller.HealthHandler())
+		router.GET("/readyz", controller.ReadinessHandler(checks...))
+		if h.debug {
+			addDebugRoutes(router, h)
+		}
+		return router
+	}
+}
+
+// pingDB checks the connection to orm.DB.
+func pingDB(ctx context.Context) error {
+	if orm.DB == nil {
+		return orm.ErrNotConnected
+	}
+	sqlDB, err := orm.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func addDebugRoutes(router gin.IRouter, h *healthConfig) {
+	if len(h.debugProtect) == 0 {
+		logger.Warn("WithHealth: /debug is public (WithPublicDebug), this is a security risk!")
+	}
+	debug := router.Group("/debug", h.debugProtect...)
+
+	debug.GET("/pprof/*name", func(c *gin.Context) {
+		name := strings.TrimPrefix(c.Param("name"), "/")
+		switch name {
+		case "cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "trace":
+			pprof.Trace(c.Writer, c.Request)
+		case "":
+			pprof.Index(c.Writer, c.Request)
+		default: // the profiles by names: heap, goroutine, ...
+			pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+		}
+	})
+
+	engine, _ := router.(*gin.Engine)
+	debug.GET("/routes", func(c *gin.Context) {
+		controller.ResponseSuccess(c, nil, gin.H{"routes": CrudRoutes(engine)})
+	})
+
+	debug.GET("/db", func(c *gin.Context) {
+		if orm.DB == nil {
+			controller.ResponseError(c, controller.CodeServiceUnavailable, orm.ErrNotConnected)
+			return
+		}
+		sqlDB, err := orm.DB.DB()
+		if err != nil {
+			controller.ResponseError(c, controller.CodeProcessFailed, err)
+			return
+		}
+		controller.ResponseSuccess(c, nil, gin.H{
+			"driver": orm.DB.Dialector.Name(),
+			"stats":  sqlDB.Stats(),
+		})
+	})
+
+	debug.GET("/config", func(c *gin.Context) {
+		controller.ResponseSuccess(c, nil, gin.H{"config": config.Redact(h.config)})
+	})
+}
@@ -0,0 +1,27 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDebugProtected(t *testing.T) {
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("WithDebug() without a middleware: want a panic")
+			}
+		}()
+		WithHealth(WithDebug())
+	}()
+
+	deny := func(c *gin.Context) { c.AbortWithStatus(http.StatusForbidden) }
+	if w := serve(NewRouter(WithHealth(WithDebug(deny))), http.MethodGet, "/debug/routes", ""); w.Code != http.StatusForbidden {
+		t.Errorf("protected /debug: want 403, got %d", w.Code)
+	}
+	if w := serve(NewRouter(WithHealth(WithPublicDebug())), http.MethodGet, "/debug/routes", ""); w.Code != http.StatusOK {
+		t.Errorf("public /debug: want 200, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,27 @@
+package router
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/job"
+)
+
+// Jobs adds the routes to the background job queue on relativePath:
+//
+//	  POST /jobs
+//	   GET /jobs/:JobID
+//	   GET /jobs/:JobID/result
+//	DELETE /jobs/:JobID
+//
+// The queue should be started by queue.Start() to run the jobs.
+func Jobs(base gin.IRouter, relativePath string, queue *job.Queue) gin.IRouter {
+	group := base.Group(relativePath)
+	idParam := getIdParam[job.Job]()
+
+	group.POST("", job.EnqueueHandler(queue))
+	group.GET(fmt.Sprintf("/:%s", idParam), job.GetHandler(queue, idParam))
+	group.GET(fmt.Sprintf("/:%s/result", idParam), job.ResultHandler(queue, idParam))
+	group.DELETE(fmt.Sprintf("/:%s", idParam), job.CancelHandler(queue, idParam))
+
+	return group
+}
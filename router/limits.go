@@ -0,0 +1,70 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/concurrency"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/ratelimit"
+	"time"
+)
+
+// WithRateLimit limits all the requests to the router by the limiter:
+//
+//	NewRouter(
+//	    WithMiddleware(jwt.Middleware()),  // before, to key by principal
+//	    WithRateLimit(ratelimit.New(ratelimit.Limit{Rate: 100, Per: time.Minute})),
+//	)
+//
+// Requests out of tokens are responded 429. Use the RateLimit of the Crud
+// options to limit the operations of a model separately.
+func WithRateLimit(limiter *ratelimit.Limiter) RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		router.Use(controller.RateLimitMiddleware(limiter))
+		return router
+	}
+}
+
+// LimitConcurrency bounds the requests in flight to the whole Crud group
+// by the limiter:
+//
+//	Crud[Todo](r, "/todos", opt, LimitConcurrency(limiter))
+//
+// Requests rejected are responded 503. Connections to /stream and /ws hold
+// their slots while connected. Use the Concurrency of the Crud options to
+// bound an operation separately.
+func LimitConcurrency(limiter *concurrency.Limiter) enum.CrudGroup {
+	return func(group *gin.RouterGroup) *gin.RouterGroup {
+		group.Use(controller.ConcurrencyMiddleware(limiter))
+		return group
+	}
+}
+
+// WithTimeout sets the default timeout of all the requests to the router.
+// Requests running past it are responded 504, and their queries are
+// cancelled. The Timeout of the Crud options replaces it per operation,
+// and /stream and /ws are never timed out.
+//
+// See orm.EnableStatementTimeouts to stop the statements on the database
+// server as well.
+func WithTimeout(timeout time.Duration) RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		router.Use(controller.TimeoutMiddleware(timeout))
+		return router
+	}
+}
+
+// limited prepends the middlewares of the limits to the handler.
+func limited(limits enum.Limits, handler gin.HandlerFunc) []gin.HandlerFunc {
+	var handlers []gin.HandlerFunc
+	if limits.RateLimit != nil {
+		handlers = append(handlers, controller.RateLimitMiddleware(limits.RateLimit))
+	}
+	if limits.Concurrency != nil {
+		handlers = append(handlers, controller.ConcurrencyMiddleware(limits.Concurrency))
+	}
+	if limits.Timeout != 0 {
+		handlers = append(handlers, controller.TimeoutMiddleware(limits.Timeout))
+	}
+	return append(handlers, handler)
+}
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/concurrency"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/ratelimit"
+	"github.com/tqrj/cd/service"
+)
+
+type limitedTodo struct {
+	orm.BasicModel
+	Title string `json:"title"`
+}
+
+// errorBody checks that the response is an error response of the status,
+// with the status and the error code in the body.
+func errorBody(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	var body struct {
+		Code  int    `json:"code"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+	}
+	if w.Code != status || body.Code != status || body.Error != code {
+		t.Errorf("want %d %q, got %d %s", status, code, w.Code, w.Body)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	if err := orm.RegisterModel(limitedTodo{}); err != nil {
+		t.Fatal(err)
+	}
+	orm.DB.Create(&limitedTodo{Title: "a"})
+
+	t.Run("timeout", func(t *testing.T) {
+		opt := DefaultCrudOption()
+		opt.ListOption.Timeout = 50 * time.Millisecond
+		// a condition taking seconds on sqlite
+		opt.ListOption.QueryOptionClosure = func(c *gin.Context, _ enum.GetRequestOptions) enum.QueryOption {
+			return service.Where("(WITH RECURSIVE cnt(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM cnt LIMIT 100000000) SELECT count(*) FROM cnt) > 0")
+		}
+		r := NewRouter()
+		Crud[limitedTodo](r, "/todos", opt)
+
+		start := time.Now()
+		w := serve(r, http.MethodGet, "/todos", "")
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("query is not cancelled at the timeout: took %v", elapsed)
+		}
+		errorBody(t, w, http.StatusGatewayTimeout, "timeout")
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		opt := DefaultCrudOption()
+		opt.GetOption.RateLimit = ratelimit.New(ratelimit.Limit{Rate: 1, Per: time.Hour})
+		r := NewRouter()
+		Crud[limitedTodo](r, "/todos", opt)
+
+		if w := serve(r, http.MethodGet, "/todos/1", ""); w.Code != http.StatusOK {
+			t.Errorf("first request: %d %s", w.Code, w.Body)
+		}
+		w := serve(r, http.MethodGet, "/todos/1", "")
+		errorBody(t, w, http.StatusTooManyRequests, "rate_limited")
+		if w.Header().Get("Retry-After") == "" {
+			t.Errorf("no Retry-After")
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		limiter := concurrency.New(concurrency.Limit{MaxInFlight: 1})
+		entered, release := make(chan struct{}), make(chan struct{})
+		r := NewRouter()
+		r.GET("/busy", limited(enum.Limits{Concurrency: limiter}, func(c *gin.Context) {
+			close(entered)
+			<-release
+		})...)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			serve(r, http.MethodGet, "/busy", "")
+		}()
+		<-entered
+		w := serve(r, http.MethodGet, "/busy", "")
+		close(release)
+		<-done
+		errorBody(t, w, http.StatusServiceUnavailable, "overloaded")
+	})
+}
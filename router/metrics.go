@@ -0,0 +1,22 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/metrics"
+)
+
+// WithMetrics records the requests to the router into m, and serves the
+// metrics in the Prometheus text format on GET path (e.g. "/metrics").
+// An empty path serves nothing, e.g. to serve them on another port with
+// m.Handler().
+//
+// See package metrics for the GORM query and DB pool metrics.
+func WithMetrics(m *metrics.Metrics, path string) RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		router.Use(m.Middleware())
+		if path != "" {
+			router.GET(path, gin.WrapH(m.Handler()))
+		}
+		return router
+	}
+}
@@ -37,12 +37,15 @@ func AllowAllCors() RouterOption {
 	}
 }
 
-// WithRequestID adds the gin_request_id.RequestID() middleware,
+// WithRequestID adds the gin_request_id.RequestID(options...) middleware,
 // which adds a request_id in the context for each request.
 // And the request_id will be writen to the X-Request-Id response header.
-func WithRequestID() RouterOption {
+//
+// NewRouter has a RequestID() already, use this to configure the headers
+// or the generator of the ids: the later one replaces the request_id.
+func WithRequestID(options ...ginrequestid.Option) RouterOption {
 	return func(router gin.IRouter) gin.IRouter {
-		router.Use(ginrequestid.RequestID())
+		router.Use(ginrequestid.RequestID(options...))
 		return router
 	}
 }
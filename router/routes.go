@@ -0,0 +1,66 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CrudRoute is a group of routes added by Crud.
+type CrudRoute struct {
+	Model  string   `json:"model"`
+	Path   string   `json:"path"`             // the base path of the group
+	Routes []string `json:"routes,omitempty"` // "GET /todos/:TodoID", ...
+}
+
+var crudRoutes struct {
+	sync.Mutex
+	list []CrudRoute
+}
+
+// registerCrud records the Crud group of the model on path.
+func registerCrud(model, path string) {
+	crudRoutes.Lock()
+	defer crudRoutes.Unlock()
+	crudRoutes.list = append(crudRoutes.list, CrudRoute{Model: model, Path: path})
+}
+
+// CrudRoutes returns the Crud groups added, in order. If engine is not
+// nil, the routes of each group in the engine are listed as well: a route
+// belongs to the group with the longest path matching it, so the nested
+// routes (GetNested, ...) are in the group of the parent.
+func CrudRoutes(engine *gin.Engine) []CrudRoute {
+	crudRoutes.Lock()
+	groups := make([]CrudRoute, len(crudRoutes.list))
+	copy(groups, crudRoutes.list)
+	crudRoutes.Unlock()
+
+	if engine == nil {
+		return groups
+	}
+	for _, route := range engine.Routes() {
+		best := -1
+		for i, group := range groups {
+			if !inGroup(route.Path, group.Path) {
+				continue
+			}
+			if best < 0 || len(group.Path) > len(groups[best].Path) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			groups[best].Routes = append(groups[best].Routes, route.Method+" "+route.Path)
+		}
+	}
+	for _, group := range groups {
+		sort.Strings(group.Routes)
+	}
+	return groups
+}
+
+// inGroup reports whether the path is in the group on groupPath.
+func inGroup(path, groupPath string) bool {
+	groupPath = strings.TrimSuffix(groupPath, "/")
+	return path == groupPath || strings.HasPrefix(path, groupPath+"/")
+}
@@ -0,0 +1,50 @@
+package router
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/controller"
+	"github.com/tqrj/cd/orm"
+)
+
+// WithTenancy adds the controller.TenantMiddleware to resolve the tenant
+// of each request, by the resolvers (the first non-empty one wins):
+//
+//	NewRouter(
+//	    WithMiddleware(jwt.Middleware()),  // before, for auth.TenantFromClaim
+//	    WithTenancy(true,
+//	        auth.TenantFromClaim(),
+//	        controller.TenantFromSubdomain("example.com")),
+//	)
+//
+// Requests without a tenant are refused if required, otherwise they work
+// on the base DB. Authenticated requests to a tenant other than the one of
+// the principal are refused (403), so the auth middleware must run before.
+// The client given tenants (by TenantFromHeader, TenantFromSubdomain) are
+// meant for the anonymous requests. orm.EnableTenancy should be called
+// before.
+func WithTenancy(required bool, resolvers ...controller.TenantResolver) RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		router.Use(controller.TenantMiddleware(required, resolvers...))
+		return router
+	}
+}
+
+// Tenants adds the admin routes to manage tenants on relativePath:
+//
+//	   GET /tenants
+//	  POST /tenants               // provision, or migrate if exists
+//	DELETE /tenants/:TenantID     // drop with all its data
+//
+// The base router should be protected (e.g. by an admin only Authorize
+// or middleware), and should not be under WithTenancy with required.
+func Tenants(base gin.IRouter, relativePath string) gin.IRouter {
+	group := base.Group(relativePath)
+	idParam := getIdParam[orm.Tenant]()
+
+	group.GET("", controller.ListTenantsHandler())
+	group.POST("", controller.ProvisionTenantHandler())
+	group.DELETE(fmt.Sprintf("/:%s", idParam), controller.DropTenantHandler(idParam))
+
+	return group
+}
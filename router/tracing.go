@@ -0,0 +1,18 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/tracing"
+)
+
+// WithTracing starts an OpenTelemetry server span for each request to the
+// router. The services called in the handlers record their spans as its
+// children.
+//
+// See package tracing for the GORM query spans.
+func WithTracing(options ...tracing.Option) RouterOption {
+	return func(router gin.IRouter) gin.IRouter {
+		router.Use(tracing.Middleware(options...))
+		return router
+	}
+}
@@ -0,0 +1,33 @@
+package router
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/webhook"
+)
+
+// Webhooks adds the routes to manage webhook subscriptions on relativePath:
+//
+//	   GET /webhooks
+//	   GET /webhooks/:SubscriptionID
+//	  POST /webhooks
+//	   PUT /webhooks/:SubscriptionID
+//	DELETE /webhooks/:SubscriptionID
+//	  POST /webhooks/:SubscriptionID/redeliver
+//
+// Secrets of the subscriptions are write-only: they are never responded.
+// webhook.Enable() should be called before, and a webhook.Dispatcher
+// should be started to deliver the messages.
+func Webhooks(base gin.IRouter, relativePath string) gin.IRouter {
+	opt := DefaultCrudOption()
+	opt.ListOption.Omit = []string{"Secret"}
+	opt.GetOption.Omit = []string{"Secret"}
+
+	redeliver := func(group *gin.RouterGroup) *gin.RouterGroup {
+		idParam := getIdParam[webhook.Subscription]()
+		group.POST(fmt.Sprintf("/:%s/redeliver", idParam), webhook.RedeliverHandler(idParam))
+		return group
+	}
+	return Crud[webhook.Subscription](base, relativePath, opt, enum.CrudGroup(redeliver))
+}
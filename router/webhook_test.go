@@ -0,0 +1,47 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/webhook"
+)
+
+func TestWebhookSecret(t *testing.T) {
+	if err := orm.RegisterModel(&webhook.Subscription{}); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter()
+	Webhooks(r, "/webhooks")
+
+	w := serve(r, http.MethodPost, "/webhooks", `{"url":"https://example.com/hook","secret":"s3cret","active":true}`)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "s3cret") {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	var created struct{ Subscription webhook.Subscription }
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || created.Subscription.ID == 0 {
+		t.Fatalf("POST: %v %s", err, w.Body)
+	}
+	path := fmt.Sprintf("/webhooks/%d", created.Subscription.ID)
+
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"PUT":  serve(r, http.MethodPut, path, `{"url":"https://example.com/hook"}`),
+		"GET":  serve(r, http.MethodGet, path, ""),
+		"LIST": serve(r, http.MethodGet, "/webhooks", ""),
+	} {
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "s3cret") {
+			t.Errorf("%s: secret responded: %d %s", name, w.Code, w.Body)
+		}
+	}
+
+	var stored webhook.Subscription
+	orm.DB.First(&stored, created.Subscription.ID)
+	if stored.Secret != "s3cret" {
+		t.Errorf("secret not stored: %q", stored.Secret)
+	}
+}
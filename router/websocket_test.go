@@ -0,0 +1,91 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/tqrj/cd/auth"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+)
+
+type sharedDoc struct {
+	orm.BasicModel
+	Title  string `json:"title"`
+	UserID string `json:"user_id"`
+}
+
+func TestWebSocketReauthorize(t *testing.T) {
+	if err := orm.RegisterModel(sharedDoc{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	doc := &sharedDoc{Title: "draft", UserID: "alice"}
+	if err := service.Create(ctx, doc, &enum.CreateOption{}, service.IfNotExist()); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := DefaultCrudOption()
+	opt.WebSocketOption.Enable = true
+	opt.GetOption.Authorize = auth.Authorize(auth.Owner("UserID"))
+	r := NewRouter()
+	r.Use(withUser)
+	Crud[sharedDoc](r, "/docs", opt)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/docs/ws",
+		http.Header{"X-User": {"alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// read reads a message as: type, kind, and the title of the data.
+	read := func() (string, string, string) {
+		t.Helper()
+		var message struct {
+			Type string `json:"type"`
+			Kind string `json:"kind"`
+			Data struct {
+				Title string `json:"title"`
+			} `json:"data"`
+		}
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		return message.Type, message.Kind, message.Data.Title
+	}
+	if err := conn.WriteJSON(gin.H{"type": "subscribe", "sub": "a", "id": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if typ, _, title := read(); typ != "subscribed" || title != "draft" {
+		t.Fatalf("subscribe: %s %s", typ, title)
+	}
+
+	update := func(title, owner string) {
+		t.Helper()
+		doc.Title, doc.UserID = title, owner
+		if _, err := service.Update(ctx, doc, &enum.UpdateOption{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update("draft", "bob") // given away
+	if typ, kind, _ := read(); typ != "change" || kind != "removed" {
+		t.Fatalf("want removed, got %s %s", typ, kind)
+	}
+	update("bob's secret", "bob") // not sent to alice
+	update("returned", "alice")
+	if _, kind, title := read(); kind != "updated" || title != "returned" {
+		t.Errorf("want the whole record returned, got %s %q", kind, title)
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+// auditFields are the fields (and columns) of AuditedModel, which are
+// written by the services only.
+var auditFields = map[string]bool{
+	"CreatedBy": true, "created_by": true,
+	"UpdatedBy": true, "updated_by": true,
+	"DeletedBy": true, "deleted_by": true,
+}
+
+// auditCreated fills the CreatedBy and UpdatedBy of a new AuditedModel.
+func auditCreated(ctx context.Context, model any) {
+	if audited, ok := model.(orm.Audited); ok && isNew(model) {
+		actor, _ := orm.ActorFrom(ctx)
+		audited.Audit().CreatedBy = actor
+		audited.Audit().UpdatedBy = actor
+		audited.Audit().DeletedBy = ""
+	}
+}
+
+// auditUpdated fills the UpdatedBy of an AuditedModel. The CreatedBy and
+// DeletedBy are not to be saved, see auditOmit.
+func auditUpdated(ctx context.Context, model any) {
+	if audited, ok := model.(orm.Audited); ok {
+		audited.Audit().UpdatedBy, _ = orm.ActorFrom(ctx)
+	}
+}
+
+// auditOmit adds the CreatedBy and DeletedBy of an AuditedModel to the
+// omitted fields of an update, keeping the stored ones.
+func auditOmit(model any, omit []string) []string {
+	if _, ok := model.(orm.Audited); !ok {
+		return omit
+	}
+	return append(omit[:len(omit):len(omit)], "CreatedBy", "DeletedBy")
+}
+
+// auditUnset removes the fields of AuditedModel from the fields and values
+// of an UpdateMany, which are filled by the services only.
+func auditUnset(values any, fields []string) []string {
+	if m, ok := values.(map[string]any); ok {
+		for key := range m {
+			if auditFields[key] {
+				delete(m, key)
+			}
+		}
+	}
+	kept := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if !auditFields[field] {
+			kept = append(kept, field)
+		}
+	}
+	return kept
+}
+
+// auditUpserted fills the *By fields of an AuditedModel to be upserted:
+// CreatedBy is kept if the record exists.
+func auditUpserted(ctx context.Context, db *gorm.DB, model any) error {
+	audited, ok := model.(orm.Audited)
+	if !ok {
+		return nil
+	}
+	actor, _ := orm.ActorFrom(ctx)
+	audited.Audit().CreatedBy = actor
+	audited.Audit().UpdatedBy = actor
+	audited.Audit().DeletedBy = ""
+	if isNew(model) {
+		return nil
+	}
+	idField, id := model.(orm.Model).Identity()
+	var createdBy []string
+	err := db.Session(&gorm.Session{NewDB: true}).Unscoped().
+		Model(reflect.New(reflect.TypeOf(model).Elem()).Interface()).
+		Where(map[string]any{idField: id}).Limit(1).
+		Pluck("created_by", &createdBy).Error
+	if err != nil {
+		return err
+	}
+	if len(createdBy) != 0 {
+		audited.Audit().CreatedBy = createdBy[0]
+	}
+	return nil
+}
+
+// auditDeleted sets the DeletedBy of the records of an AuditedModel (T or
+// *T) matched by the query, before they are soft deleted.
+func auditDeleted(ctx context.Context, model any, query *gorm.DB) error {
+	if _, ok := model.(orm.Audited); !ok {
+		return nil
+	}
+	actor, _ := orm.ActorFrom(ctx)
+	return query.UpdateColumn("DeletedBy", actor).Error
+}
+
+var ErrAuditField = errors.New("audit fields are written by the services only")
@@ -3,16 +3,21 @@ package service
 import (
 	"context"
 	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/event"
 	"github.com/tqrj/cd/orm"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
 )
 
 // Create creates a model in the database.
 // Nested models associated with the model will be created as well.
 //
-// There are two mode of creating a model:
+// There are three mode of creating a model:
 //   - IfNotExist: creates a model record if it does not exist.
 //   - NestInto: creates a nested model of the parent model.
+//   - Upsert: creates a model record, or updates it if it exists.
 //
 // Note:
 //
@@ -22,7 +27,10 @@ import (
 //	group := GetByID[Group](123)
 //	Create(&user, NestInto(&group, "users"))
 //	// user is already in the database: just add it into group.users
-func Create(ctx context.Context, model any, opt *enum.CreateOption, in CreateMode) error {
+func Create(ctx context.Context, model any, opt *enum.CreateOption, in CreateMode) (err error) {
+	ctx, span := startSpan(ctx, "Create", model)
+	defer endSpan(span, &err)
+
 	return in(ctx, model, opt)
 }
 
@@ -68,8 +76,15 @@ func NestInto(parent any, field string, opt *enum.CreateOption) CreateMode {
 			WithField("modelToCreate", modelToCreate).
 			Trace("Create Nested")
 
-		return orm.DB.WithContext(ctx).Session(&gorm.Session{FullSaveAssociations: true}).
-			Model(parent).Association(field).Append(modelToCreate)
+		if err := stamp(ctx, modelToCreate); err != nil {
+			return err
+		}
+		auditCreated(ctx, modelToCreate)
+		change := event.Change{Kind: event.KindAssociated, Model: parent, Field: field, Child: modelToCreate}
+		return write(ctx, change, func(db *gorm.DB) error {
+			return db.Session(&gorm.Session{FullSaveAssociations: true}).
+				Model(parent).Association(field).Append(modelToCreate)
+		})
 	}
 }
 
@@ -79,16 +94,139 @@ func IfNotExist() CreateMode {
 		logger.WithContext(ctx).
 			WithField("modelToCreate", modelToCreate).
 			Trace("Create IfNotExist")
-		db := orm.DB.WithContext(ctx)
-		//if opt.QueryOptionClosure != nil {
-		//	db = opt.QueryOptionClosure(db)
-		//}
+		if err := stamp(ctx, modelToCreate); err != nil {
+			return err
+		}
+		auditCreated(ctx, modelToCreate)
+		change := event.Change{Kind: event.KindCreated, Model: modelToCreate}
+		return write(ctx, change, func(db *gorm.DB) error {
+			//if opt.QueryOptionClosure != nil {
+			//	db = opt.QueryOptionClosure(db)
+			//}
+
+			//@todo 暂时先写在这里吧 其实应该在上层 做传递
+			if opt.Omit != nil && len(opt.Omit) != 0 {
+				db = Omit(opt.Omit)(db)
+			}
+
+			return db.Create(modelToCreate).Error
+		})
+	}
+}
+
+// Upsert creates a model, or updates the fields (struct field names or
+// columns, e.g. the fields present in the input) of the record if the
+// model (its primary key) already exists. Without fields, the non-zero
+// fields of the model are updated.
+//
+// The primary key, created_at, CreatedBy and the scope columns are never
+// updated, nor the omitted fields (CreateOption.Omit).
+func Upsert(fields ...string) CreateMode {
+	return func(ctx context.Context, modelToCreate any, opt *enum.CreateOption) error {
+		logger.WithContext(ctx).
+			WithField("modelToCreate", modelToCreate).
+			WithField("fields", fields).
+			Trace("Create Upsert")
+		if err := stamp(ctx, modelToCreate); err != nil {
+			return err
+		}
+		onConflict, err := upsertClause(ctx, modelToCreate, fields, opt.Omit)
+		if err != nil {
+			return err
+		}
+		change := event.Change{Kind: event.KindCreated, Model: modelToCreate}
+		if !isNew(modelToCreate) {
+			change.Kind = event.KindUpdated
+		}
+		return write(ctx, change, func(db *gorm.DB) error {
+			if err := checkScope(ctx, db, modelToCreate); err != nil {
+				return err
+			}
+			if err := auditUpserted(ctx, db, modelToCreate); err != nil {
+				return err
+			}
+			if opt.Omit != nil && len(opt.Omit) != 0 {
+				db = Omit(opt.Omit)(db)
+			}
+			return db.Clauses(onConflict).Create(modelToCreate).Error
+		})
+	}
+}
+
+// upsertClause returns the ON CONFLICT clause of Upsert, which updates
+// the columns of the fields (or the non-zero fields if none), but not the
+// primary key, created_at, created_by, scope and omitted columns.
+func upsertClause(ctx context.Context, model any, fields []string, omit []string) (clause.OnConflict, error) {
+	s, err := parseSchema(model)
+	if err != nil {
+		return clause.OnConflict{}, err
+	}
+	scope, err := scopeOf(ctx, reflect.TypeOf(model))
+	if err != nil {
+		return clause.OnConflict{}, err
+	}
+	kept := func(field *schema.Field) bool {
+		if field == nil || field.DBName == "" || field.PrimaryKey || field.AutoCreateTime != 0 {
+			return false
+		}
+		if field.DBName == "created_at" || field.DBName == "created_by" {
+			return false
+		}
+		if _, ok := scope[field.DBName]; ok {
+			return false
+		}
+		for _, name := range omit {
+			if name == field.Name || name == field.DBName {
+				return false
+			}
+		}
+		return true
+	}
 
-		//@todo 暂时先写在这里吧 其实应该在上层 做传递
-		if opt.Omit != nil && len(opt.Omit) != 0 {
-			db = Omit(opt.Omit)(db)
+	if len(fields) == 0 {
+		rv := reflect.Indirect(reflect.ValueOf(model))
+		for _, field := range s.Fields {
+			if _, zero := field.ValueOf(ctx, rv); !zero {
+				fields = append(fields, field.Name)
+			}
+		}
+	}
+	var columns []string
+	added := make(map[string]bool)
+	add := func(field *schema.Field) {
+		if kept(field) && !added[field.DBName] {
+			columns = append(columns, field.DBName)
+			added[field.DBName] = true
 		}
+	}
+	for _, name := range fields {
+		add(s.LookUpField(name))
+	}
+	for _, field := range s.Fields {
+		if field.AutoUpdateTime != 0 {
+			add(field)
+		}
+	}
+	if _, ok := model.(orm.Audited); ok {
+		add(s.LookUpField("updated_by"))
+	}
+
+	onConflict := clause.OnConflict{DoUpdates: clause.AssignmentColumns(columns)}
+	for _, field := range s.PrimaryFields {
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: field.DBName})
+	}
+	if len(columns) == 0 {
+		onConflict.DoNothing = true
+	}
+	return onConflict, nil
+}
 
-		return db.Create(modelToCreate).Error
+// isNew reports whether the primary key of the model is not set.
+func isNew(model any) bool {
+	m, ok := model.(orm.Model)
+	if !ok {
+		return true
 	}
+	_, id := m.Identity()
+	return id == nil || reflect.ValueOf(id).IsZero()
 }
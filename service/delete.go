@@ -2,20 +2,38 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/event"
 	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+	"reflect"
 )
 
 // Delete a model from database.
 func Delete(ctx context.Context, model any) (rowsAffected int64, err error) {
+	ctx, span := startSpan(ctx, "Delete", model)
+	defer endSpan(span, &err)
+
 	logger.WithContext(ctx).
 		WithField("model", model).Trace("Delete model")
-	result := orm.DB.WithContext(ctx).Delete(model)
-	return result.RowsAffected, result.Error
+	err = write(ctx, event.Change{Kind: event.KindDeleted, Model: model}, func(db *gorm.DB) error {
+		db = scopedType(ctx, reflect.TypeOf(model), db)
+		if err := auditDeleted(ctx, model, db.Session(&gorm.Session{}).Model(model)); err != nil {
+			return err
+		}
+		result := db.Delete(model)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	return rowsAffected, err
 }
 
 // DeleteByID deletes a model from database by its ID.
 func DeleteByID[T orm.Model](ctx context.Context, id any, opt *enum.DelOption) (rowsAffected int64, err error) {
+	ctx, span := startSpan(ctx, "DeleteByID", new(T))
+	defer endSpan(span, &err)
+
 	logger.WithContext(ctx).
 		WithField("id", id).
 		Trace("DeleteByID: Delete model by ID")
@@ -27,18 +45,67 @@ func DeleteByID[T orm.Model](ctx context.Context, id any, opt *enum.DelOption) (
 			Warn("DeleteByID: GetByID failed")
 		return 0, err
 	}
-	db := orm.DB.WithContext(ctx)
-	result := db.Delete(&model)
-	if result.Error != nil {
+	err = write(ctx, event.Change{Kind: event.KindDeleted, Model: &model}, func(db *gorm.DB) error {
+		if err := auditDeleted(ctx, &model, db.Model(&model)); err != nil {
+			return err
+		}
+		result := db.Delete(&model)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
 		logger.WithContext(ctx).
-			WithError(result.Error).Warn("DeleteByID: failed")
+			WithError(err).Warn("DeleteByID: failed")
 	}
-	return result.RowsAffected, result.Error
+	return rowsAffected, err
+}
+
+// DeleteMany deletes all models T matched by options in a single DELETE
+// statement (or UPDATE for soft delete models).
+//
+// A Deleted change is recorded (write hooks, events) for each deleted
+// model, as Delete does. To record them, the models are loaded and deleted
+// in batches of 500 instead, in a single transaction.
+//
+// GORM refuses to delete without any condition, use AllowGlobal to
+// explicitly delete the whole table.
+func DeleteMany[T any](ctx context.Context, options ...enum.QueryOption) (rowsAffected int64, err error) {
+	ctx, span := startSpan(ctx, "DeleteMany", new(T))
+	defer endSpan(span, &err)
+
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T)))
+	logger.Trace("DeleteMany: Delete models")
+
+	// DeletedBy is set before the soft delete, in the same transaction.
+	rowsAffected, err = writeMany[T](ctx, event.KindDeleted, func(ctx context.Context) *gorm.DB {
+		query := scoped[T](ctx, DB(ctx).Model(new(T)))
+		for _, option := range options {
+			query = option(query)
+		}
+		return query
+	}, func(query *gorm.DB) (int64, error) {
+		if err := auditDeleted(ctx, new(T), query.Session(&gorm.Session{})); err != nil {
+			return 0, err
+		}
+		result := query.Delete(new(T))
+		return result.RowsAffected, result.Error
+	})
+	if err != nil {
+		logger.WithError(err).Warn("DeleteMany: failed")
+	}
+	return rowsAffected, err
 }
 
 // DeleteNested remove the association between parent and child.
-func DeleteNested[P orm.Model, T any](ctx context.Context, parent *P, field string, child *T) error {
-	err := orm.DB.WithContext(ctx).Model(parent).Association(field).Delete(child)
+func DeleteNested[P orm.Model, T any](ctx context.Context, parent *P, field string, child *T) (err error) {
+	ctx, span := startSpan(ctx, "DeleteNested", parent)
+	defer endSpan(span, &err)
+
+	change := event.Change{Kind: event.KindDissociated, Model: parent, Field: field, Child: child}
+	err = write(ctx, change, func(db *gorm.DB) error {
+		return db.Model(parent).Association(field).Delete(child)
+	})
 	if err != nil {
 		logger.WithContext(ctx).
 			WithError(err).Warn("DeleteNested: failed")
@@ -50,7 +117,10 @@ func DeleteNested[P orm.Model, T any](ctx context.Context, parent *P, field stri
 }
 
 // DeleteNestedByID remove the association between parent and child.
-func DeleteNestedByID[P orm.Model, T orm.Model](ctx context.Context, parentID any, field string, childID any) error {
+func DeleteNestedByID[P orm.Model, T orm.Model](ctx context.Context, parentID any, field string, childID any) (err error) {
+	ctx, span := startSpan(ctx, "DeleteNestedByID", new(P))
+	defer endSpan(span, &err)
+
 	logger.WithContext(ctx).
 		WithField("parentID", parentID).
 		WithField("field", field).
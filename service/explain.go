@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+)
+
+// EstimateCost returns the cost of the query GetMany[T] with the options
+// would run, estimated by the planner of the database (EXPLAIN). The
+// preloads are not counted. Only postgres is supported, others return
+// ErrExplainUnsupported.
+func EstimateCost[T any](ctx context.Context, options ...enum.QueryOption) (float64, error) {
+	db := DB(ctx)
+	if db.Dialector.Name() != orm.DBDriverPostgres {
+		return 0, ErrExplainUnsupported
+	}
+
+	query := scoped[T](ctx, db.Session(&gorm.Session{DryRun: true}).Model(new(T)))
+	for _, option := range options {
+		query = option(query)
+	}
+	var dest []T
+	stmt := query.Find(&dest).Statement
+	if stmt.Error != nil {
+		return 0, stmt.Error
+	}
+
+	var plan string
+	err := db.Raw("EXPLAIN (FORMAT JSON) "+stmt.SQL.String(), stmt.Vars...).Row().Scan(&plan)
+	if err != nil {
+		return 0, err
+	}
+	var plans []struct {
+		Plan struct {
+			TotalCost float64 `json:"Total Cost"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(plan), &plans); err != nil || len(plans) == 0 {
+		return 0, fmt.Errorf("bad EXPLAIN output: %v", err)
+	}
+	return plans[0].Plan.TotalCost, nil
+}
+
+var ErrExplainUnsupported = errors.New("EXPLAIN is not supported on the database")
@@ -35,7 +35,10 @@ import (
 //
 // Because this getting model by id is a common operation, a shortcut GetByID
 // is provided. (but you still have to add Preload options if needed)
-func Get[T any](ctx context.Context, dest any, options ...enum.QueryOption) error {
+func Get[T any](ctx context.Context, dest any, options ...enum.QueryOption) (err error) {
+	ctx, span := startSpan(ctx, "Get", new(T))
+	defer endSpan(span, &err)
+
 	vT := *new(T)
 	logger := logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", vT)).
@@ -43,7 +46,7 @@ func Get[T any](ctx context.Context, dest any, options ...enum.QueryOption) erro
 
 	logger.Trace("Get model into dest")
 
-	query := orm.DB.WithContext(ctx).Model(new(T))
+	query := scoped[T](ctx, DB(ctx).Model(new(T)))
 	for _, option := range options {
 		query = option(query)
 	}
@@ -64,7 +67,10 @@ func Get[T any](ctx context.Context, dest any, options ...enum.QueryOption) erro
 // Notice: "id" here is the column (or field) name of the primary key of the
 // model which is indicated by the Identity method of orm.Model.
 // So GetByID only works for models that implement the orm.Model interface.
-func GetByID[T orm.Model](ctx context.Context, id any, dest any, options ...enum.QueryOption) error {
+func GetByID[T orm.Model](ctx context.Context, id any, dest any, options ...enum.QueryOption) (err error) {
+	ctx, span := startSpan(ctx, "GetByID", new(T))
+	defer endSpan(span, &err)
+
 	logger.WithContext(ctx).WithField("model", fmt.Sprintf("%T", *new(T))).
 		WithField("dest", fmt.Sprintf("%T", dest)).
 		Trace("GetByID: Get model by id")
@@ -107,13 +113,16 @@ func GetByID[T orm.Model](ctx context.Context, id any, dest any, options ...enum
 //	    WHERE name = "John"
 //	    ORDER BY age desc
 //	    LIMIT 10 OFFSET 0;  // into users
-func GetMany[T any](ctx context.Context, dest any, options ...enum.QueryOption) error {
+func GetMany[T any](ctx context.Context, dest any, options ...enum.QueryOption) (err error) {
+	ctx, span := startSpan(ctx, "GetMany", new(T))
+	defer endSpan(span, &err)
+
 	logger := logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", *new(T))).
 		WithField("dest", fmt.Sprintf("%T", dest))
 	logger.Trace("GetMany: Get models into dest")
 
-	query := orm.DB.WithContext(ctx).Model(new(T))
+	query := scoped[T](ctx, DB(ctx).Model(new(T)))
 	for _, option := range options {
 		query = option(query)
 	}
@@ -125,13 +134,74 @@ func GetMany[T any](ctx context.Context, dest any, options ...enum.QueryOption)
 	return ret.Error
 }
 
+// FindInBatches queries models T matched by options batch by batch, and
+// calls fn with each batch of at most batchSize models, so that a big
+// table can be processed without loading it into memory at once.
+//
+// Batches are fetched in the order of the primary key (see gorm
+// FindInBatches), use FindInPages if another ordering is required.
+func FindInBatches[T any](ctx context.Context, batchSize int, fn func(batch []*T) error, options ...enum.QueryOption) error {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("batchSize", batchSize)
+	logger.Trace("FindInBatches: Get models in batches")
+
+	query := scoped[T](ctx, DB(ctx).Model(new(T)))
+	for _, option := range options {
+		query = option(query)
+	}
+	var batch []*T
+	ret := query.FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		return fn(batch)
+	})
+	if ret.Error != nil {
+		logger.WithError(ret.Error).
+			Warn("FindInBatches: Get models in batches failed")
+	}
+	return ret.Error
+}
+
+// FindInPages is a FindInBatches that pages by LIMIT/OFFSET, which keeps
+// the ordering given by the OrderBy options.
+func FindInPages[T any](ctx context.Context, pageSize int, fn func(batch []*T) error, options ...enum.QueryOption) error {
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("pageSize", pageSize)
+	logger.Trace("FindInPages: Get models in pages")
+
+	for offset := 0; ; offset += pageSize {
+		query := scoped[T](ctx, DB(ctx).Model(new(T)))
+		for _, option := range options {
+			query = option(query)
+		}
+		var page []*T
+		if err := query.Limit(pageSize).Offset(offset).Find(&page).Error; err != nil {
+			logger.WithError(err).
+				Warn("FindInPages: Get models in pages failed")
+			return err
+		}
+		if len(page) == 0 {
+			return nil
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if len(page) < pageSize {
+			return nil
+		}
+	}
+}
+
 // Count returns the number of models.
 func Count[T any](ctx context.Context, options ...enum.QueryOption) (count int64, err error) {
+	ctx, span := startSpan(ctx, "Count", new(T))
+	defer endSpan(span, &err)
+
 	logger := logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", *new(T)))
 	logger.Trace("Count: Count models")
 
-	query := orm.DB.WithContext(ctx).Model(new(T))
+	query := scoped[T](ctx, DB(ctx).Model(new(T)))
 	for _, option := range options {
 		query = option(query)
 	}
@@ -144,7 +214,10 @@ func Count[T any](ctx context.Context, options ...enum.QueryOption) (count int64
 }
 
 // GetAssociations find matched associations (model.field) into dest.
-func GetAssociations(ctx context.Context, model any, field string, dest any, options ...enum.QueryOption) error {
+func GetAssociations(ctx context.Context, model any, field string, dest any, options ...enum.QueryOption) (err error) {
+	ctx, span := startSpan(ctx, "GetAssociations", model)
+	defer endSpan(span, &err)
+
 	logger := logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", model)).
 		WithField("field", field).
@@ -152,7 +225,7 @@ func GetAssociations(ctx context.Context, model any, field string, dest any, opt
 
 	logger.Trace("GetAssociation: Get association into dest")
 
-	err := associationQuery(ctx, model, field, options...).Find(dest)
+	err = associationQuery(ctx, model, field, options...).Find(dest)
 	if err != nil {
 		logger.WithError(err).
 			Warn("GetAssociation: Get association into dest failed")
@@ -162,18 +235,23 @@ func GetAssociations(ctx context.Context, model any, field string, dest any, opt
 
 // CountAssociations count matched associations (model.field).
 func CountAssociations(ctx context.Context, model any, field string, options ...enum.QueryOption) (count int64, err error) {
+	ctx, span := startSpan(ctx, "CountAssociations", model)
+	defer endSpan(span, &err)
+
 	logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", model)).
 		WithField("field", field).
 		Trace("CountAssociations: Count associations")
 
-	count = associationQuery(ctx, model, field, options...).Count()
-	return count, err
+	association := associationQuery(ctx, model, field, options...)
+	count = association.Count()
+	return count, association.Error
 }
 
-// associationQuery builds a gorm association query
+// associationQuery builds a gorm association query,
+// scoped by the scope of the associated model.
 func associationQuery(ctx context.Context, model any, field string, options ...enum.QueryOption) *gorm.Association {
-	query := orm.DB.WithContext(ctx).Model(model)
+	query := scopedType(ctx, associationType(model, field), DB(ctx).Model(model))
 	for _, option := range options {
 		query = option(query)
 	}
@@ -272,6 +350,21 @@ func Where(query any, args ...any) enum.QueryOption {
 	}
 }
 
+// Exclude is a query option that sets WHERE field NOT IN (values) condition.
+func Exclude(field string, values any) enum.QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Not(map[string]any{field: values})
+	}
+}
+
+// AllowGlobal allows UpdateMany and DeleteMany to run without any
+// condition, i.e. to write the whole table. Use it with care.
+func AllowGlobal() enum.QueryOption {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Session(&gorm.Session{AllowGlobalUpdate: true})
+	}
+}
+
 var (
 	ErrNoIdentityField = errors.New("no identity field found")
 	ErrNilID           = errors.New("id is nil")
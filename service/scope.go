@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sync"
+)
+
+// ScopeFunc returns the conditions (column => value) of the rows of a model
+// that are visible in the ctx, for example { "tenant_id": "acme" }.
+// A ScopeFunc returning an error refuses the service call.
+type ScopeFunc func(ctx context.Context) (map[string]any, error)
+
+// scopes is the registry of ScopeFunc: reflect.Type of model => ScopeFunc.
+var scopes sync.Map
+
+// Scope declares the scope of model T. It's applied to all the services
+// on T (the models T read, counted, updated and deleted must match the
+// conditions), and stamped onto the created T (the conditions are set into
+// the fields, overriding the client given values). For example:
+//
+//	service.Scope[Todo](auth.TenantScope("tenant_id"))
+//
+// makes the Todos of other tenants invisible and unwritable through the
+// services, and thus through the Crud routes.
+//
+// Notice: the raw DB(ctx) is not scoped, nor the nested models created
+// with their parents (FullSaveAssociations).
+func Scope[T any](scope ScopeFunc) {
+	scopes.Store(reflect.TypeOf(*new(T)), scope)
+}
+
+// unscopedKey is the context key of Unscoped.
+type unscopedKey struct{}
+
+// Unscoped returns a ctx in which the scopes are not applied,
+// for the trusted code like jobs and admin tools.
+func Unscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unscopedKey{}, true)
+}
+
+// scopeOf returns the conditions of the scope of the model type in ctx.
+// It's nil if the model has no scope.
+func scopeOf(ctx context.Context, model reflect.Type) (map[string]any, error) {
+	for model != nil && (model.Kind() == reflect.Ptr || model.Kind() == reflect.Slice || model.Kind() == reflect.Array) {
+		model = model.Elem()
+	}
+	if model == nil {
+		return nil, nil
+	}
+	scope, ok := scopes.Load(model)
+	if !ok {
+		return nil, nil
+	}
+	if unscoped, _ := ctx.Value(unscopedKey{}).(bool); unscoped {
+		return nil, nil
+	}
+	conditions, err := scope.(ScopeFunc)(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOutOfScope, err)
+	}
+	return conditions, nil
+}
+
+// scoped adds the scope of model T to the query.
+func scoped[T any](ctx context.Context, query *gorm.DB) *gorm.DB {
+	return scopedType(ctx, reflect.TypeOf(*new(T)), query)
+}
+
+func scopedType(ctx context.Context, model reflect.Type, query *gorm.DB) *gorm.DB {
+	conditions, err := scopeOf(ctx, model)
+	if err != nil {
+		_ = query.AddError(err)
+		return query
+	}
+	if len(conditions) != 0 {
+		query = query.Where(conditions)
+	}
+	return query
+}
+
+// stamp sets the scope conditions into the fields of the model.
+func stamp(ctx context.Context, model any) error {
+	return stampAs(ctx, reflect.TypeOf(model), model)
+}
+
+// stampAs stamps the scope of the model type onto the values,
+// a pointer to struct or a map[string]any.
+func stampAs(ctx context.Context, model reflect.Type, values any) error {
+	conditions, err := scopeOf(ctx, model)
+	if err != nil || len(conditions) == 0 {
+		return err
+	}
+	if values, ok := values.(map[string]any); ok {
+		for column, value := range conditions {
+			values[column] = value
+		}
+		return nil
+	}
+	s, err := parseSchema(values)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(values)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	for column, value := range conditions {
+		field := s.LookUpField(column)
+		if field == nil {
+			return fmt.Errorf("%w: unknown field %q of %s", ErrOutOfScope, column, s.Name)
+		}
+		if err := field.Set(ctx, rv, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InScope reports whether the model (a pointer to struct) matches its
+// scope in ctx, in memory. It's for the records not read from the
+// database, e.g. the snapshots of deleted records.
+func InScope(ctx context.Context, model any) (bool, error) {
+	conditions, err := scopeOf(ctx, reflect.TypeOf(model))
+	if err != nil || len(conditions) == 0 {
+		return err == nil, err
+	}
+	s, err := parseSchema(model)
+	if err != nil {
+		return false, err
+	}
+	rv := reflect.ValueOf(model)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	for column, want := range conditions {
+		field := s.LookUpField(column)
+		if field == nil {
+			return false, nil
+		}
+		got, _ := field.ValueOf(ctx, rv)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// checkScope refuses to write the model (by its primary key) if the record
+// exists out of the scope: another tenant's record must not be overwritten.
+// The error is a gorm.ErrRecordNotFound as well, as the record is not
+// visible in the scope.
+func checkScope(ctx context.Context, db *gorm.DB, model any) error {
+	conditions, err := scopeOf(ctx, reflect.TypeOf(model))
+	if err != nil || len(conditions) == 0 || isNew(model) {
+		return err
+	}
+	idField, id := model.(orm.Model).Identity()
+	var count int64
+	err = db.Session(&gorm.Session{NewDB: true}).Unscoped().
+		Model(reflect.New(reflect.TypeOf(model).Elem()).Interface()).
+		Where(map[string]any{idField: id}).Not(conditions).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count != 0 {
+		return errNotInScope
+	}
+	return nil
+}
+
+// isScopeField reports whether the field (name or column) of the model
+// is a column of the scope, which can not be updated.
+func isScopeField(ctx context.Context, model any, name string) (bool, error) {
+	conditions, err := scopeOf(ctx, reflect.TypeOf(model))
+	if err != nil || len(conditions) == 0 {
+		return false, err
+	}
+	s, err := parseSchema(model)
+	if err != nil {
+		return false, err
+	}
+	field := s.LookUpField(name)
+	if field == nil {
+		return false, nil
+	}
+	_, ok := conditions[field.DBName]
+	return ok, nil
+}
+
+var schemaCache = &sync.Map{}
+
+func parseSchema(model any) (*schema.Schema, error) {
+	return schema.Parse(model, schemaCache, orm.DB.NamingStrategy)
+}
+
+// associationType returns the type of model.field, with the pointers
+// and slices removed.
+func associationType(model any, field string) reflect.Type {
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	f, ok := t.FieldByName(field)
+	if !ok {
+		return nil
+	}
+	return f.Type
+}
+
+var ErrOutOfScope = errors.New("out of scope")
+
+var errNotInScope = fmt.Errorf("%w: %w", ErrOutOfScope, gorm.ErrRecordNotFound)
@@ -2,6 +2,8 @@
 //
 // For any not-in-the-box lower level database operations, you can implement
 // your own services with the orm.DB (a *gorm.DB) instance.
+//
+// Services called inside a Transaction share the same database transaction.
 package service
 
 import "github.com/tqrj/cd/log"
@@ -1,3 +1,366 @@
 package service
 
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/event"
+	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+)
+
 // TODO: CRUD operations tests
+
+// connect connects orm.DB to a new in-memory sqlite database of the name,
+// and migrates the models.
+func connect(t *testing.T, name string, models ...any) {
+	t.Helper()
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file:"+name+"?mode=memory&cache=shared"); err != nil {
+		t.Fatal(err)
+	}
+	if err := orm.RegisterModel(models...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type upsertTodo struct {
+	orm.AuditedModel
+	Title  string
+	Done   bool
+	Tenant string
+}
+
+func TestUpsert(t *testing.T) {
+	connect(t, "upsert", upsertTodo{})
+	Scope[upsertTodo](func(ctx context.Context) (map[string]any, error) {
+		return map[string]any{"tenant": "acme"}, nil
+	})
+	defer scopes.Delete(reflect.TypeOf(upsertTodo{}))
+
+	ctx := orm.WithActor(context.Background(), "alice")
+	todo := &upsertTodo{Title: "a", Done: true}
+	if err := Create(ctx, todo, &enum.CreateOption{}, IfNotExist()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = orm.WithActor(context.Background(), "bob")
+	row := &upsertTodo{Title: "b"}
+	row.ID = todo.ID
+	if err := Create(ctx, row, &enum.CreateOption{}, Upsert("Title")); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored upsertTodo
+	if err := orm.DB.First(&stored, todo.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "b" || !stored.Done {
+		t.Errorf("want Title b and Done kept, got %q %v", stored.Title, stored.Done)
+	}
+	if stored.CreatedBy != "alice" || stored.UpdatedBy != "bob" {
+		t.Errorf("want created by alice, updated by bob, got %q %q", stored.CreatedBy, stored.UpdatedBy)
+	}
+	if !stored.CreatedAt.Equal(todo.CreatedAt) {
+		t.Errorf("created_at changed: %v -> %v", todo.CreatedAt, stored.CreatedAt)
+	}
+	if stored.Tenant != "acme" {
+		t.Errorf("tenant changed: %q", stored.Tenant)
+	}
+
+	// a new row is inserted as a whole
+	row = &upsertTodo{Title: "c", Done: true}
+	row.ID = todo.ID + 1
+	if err := Create(ctx, row, &enum.CreateOption{}, Upsert("Title")); err != nil {
+		t.Fatal(err)
+	}
+	var inserted upsertTodo
+	if err := orm.DB.First(&inserted, row.ID).Error; err != nil || !inserted.Done || inserted.CreatedBy != "bob" {
+		t.Errorf("inserted: %+v %v", inserted, err)
+	}
+}
+
+type eventTodo struct {
+	orm.BasicModel
+	Title string
+	Done  bool
+}
+
+func TestTransactionEvents(t *testing.T) {
+	connect(t, "events", eventTodo{})
+	ctx := context.Background()
+
+	var published []string
+	defer event.Subscribe(func(ctx context.Context, e event.Created[eventTodo]) error {
+		published = append(published, e.Model.Title)
+		return nil
+	})()
+
+	err := Transaction(ctx, func(ctx context.Context) error {
+		if err := Create(ctx, &eventTodo{Title: "committed"}, &enum.CreateOption{}, IfNotExist()); err != nil {
+			return err
+		}
+		if len(published) != 0 {
+			t.Errorf("published before commit: %v", published)
+		}
+		return nil
+	})
+	if err != nil || len(published) != 1 || published[0] != "committed" {
+		t.Errorf("want published after commit, got %v %v", published, err)
+	}
+
+	errRollback := errors.New("rollback")
+	err = Transaction(ctx, func(ctx context.Context) error {
+		if err := Create(ctx, &eventTodo{Title: "rolled back"}, &enum.CreateOption{}, IfNotExist()); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) || len(published) != 1 {
+		t.Errorf("want dropped on rollback, got %v %v", published, err)
+	}
+}
+
+func TestBulkWriteEvents(t *testing.T) {
+	connect(t, "bulk_events", eventTodo{})
+	ctx := context.Background()
+	for _, title := range []string{"a", "b", "c"} {
+		if err := Create(ctx, &eventTodo{Title: title}, &enum.CreateOption{}, IfNotExist()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var changes []event.Change
+	AddWriteHook(func(ctx context.Context, change event.Change) error {
+		if _, ok := change.Model.(*eventTodo); ok {
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	var updated []string
+	defer event.Subscribe(func(ctx context.Context, e event.Updated[eventTodo]) error {
+		updated = append(updated, e.Old.Title+">"+e.New.Title)
+		return nil
+	})()
+
+	n, err := UpdateMany[eventTodo](ctx, map[string]any{"title": "x"}, []string{"title"}, FilterBy("title", "b"))
+	if err != nil || n != 1 {
+		t.Fatalf("UpdateMany: %d %v", n, err)
+	}
+	if len(changes) != 1 || changes[0].Kind != event.KindUpdated || changes[0].Model.(*eventTodo).Title != "x" {
+		t.Errorf("UpdateMany recorded %+v", changes)
+	}
+	if len(updated) != 1 || updated[0] != "b>x" {
+		t.Errorf("UpdateMany published %v", updated)
+	}
+
+	changes = nil
+	n, err = DeleteMany[eventTodo](ctx, func(db *gorm.DB) *gorm.DB { return db.Where("title <> ?", "x") })
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteMany: %d %v", n, err)
+	}
+	var deleted []string
+	for _, change := range changes {
+		if change.Kind == event.KindDeleted {
+			deleted = append(deleted, change.Model.(*eventTodo).Title)
+		}
+	}
+	if len(deleted) != 2 || deleted[0] != "a" || deleted[1] != "c" {
+		t.Errorf("DeleteMany recorded %v, want [a c]", deleted)
+	}
+}
+
+type omitTodo struct {
+	orm.BasicModel
+	Title  string
+	Secret string
+}
+
+func TestBulkWriteBatches(t *testing.T) {
+	connect(t, "bulk_batches", omitTodo{})
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if err := Create(ctx, &omitTodo{Title: "a", Secret: "s"}, &enum.CreateOption{}, IfNotExist()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(size int) { bulkBatchSize = size }(bulkBatchSize)
+	bulkBatchSize = 2
+
+	var changes []event.Change
+	AddWriteHook(func(ctx context.Context, change event.Change) error {
+		if _, ok := change.Model.(*omitTodo); ok {
+			changes = append(changes, change)
+		}
+		return nil
+	})
+
+	values := &omitTodo{Title: "b", Secret: "leaked"}
+	n, err := UpdateMany[omitTodo](ctx, values, []string{"Title", "Secret"}, Where("title = ?", "a"), Omit([]string{"Secret"}))
+	if err != nil || n != 5 {
+		t.Fatalf("UpdateMany: %d %v", n, err)
+	}
+	var stored []omitTodo
+	orm.DB.Find(&stored)
+	for _, todo := range stored {
+		if todo.Title != "b" || todo.Secret != "s" {
+			t.Errorf("want title updated and secret omitted, got %+v", todo)
+		}
+	}
+	if len(changes) != 5 {
+		t.Fatalf("want 5 changes in 3 batches, got %d", len(changes))
+	}
+	for _, change := range changes {
+		if old := change.Old.(*omitTodo); old.Title != "a" || old.Secret != "s" {
+			t.Errorf("old: %+v", old)
+		}
+	}
+}
+
+type scopeTodo struct {
+	orm.BasicModel
+	Title  string
+	Tenant string
+}
+
+func TestScope(t *testing.T) {
+	connect(t, "scope", scopeTodo{})
+	Scope[scopeTodo](func(ctx context.Context) (map[string]any, error) {
+		tenantID, _ := orm.TenantFrom(ctx)
+		return map[string]any{"tenant": tenantID}, nil
+	})
+	defer scopes.Delete(reflect.TypeOf(scopeTodo{}))
+
+	acme := orm.WithTenant(context.Background(), "acme")
+	globex := orm.WithTenant(context.Background(), "globex")
+	stored := func(id uint) (todo scopeTodo) {
+		orm.DB.Unscoped().First(&todo, id)
+		return todo
+	}
+
+	// the client given scope value is overwritten
+	own := &scopeTodo{Title: "own", Tenant: "globex"}
+	if err := Create(acme, own, &enum.CreateOption{}, IfNotExist()); err != nil {
+		t.Fatal(err)
+	}
+	if own.Tenant != "acme" || stored(own.ID).Tenant != "acme" {
+		t.Errorf("create: want tenant acme, got %q", stored(own.ID).Tenant)
+	}
+	other := &scopeTodo{Title: "other"}
+	if err := Create(globex, other, &enum.CreateOption{}, IfNotExist()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("cross scope", func(t *testing.T) {
+		var got scopeTodo
+		if err := GetByID[scopeTodo](acme, other.ID, &got); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("get: want not found, got %v %+v", err, got)
+		}
+		var list []scopeTodo
+		if err := GetMany[scopeTodo](acme, &list); err != nil || len(list) != 1 || list[0].ID != own.ID {
+			t.Errorf("list: want own only, got %+v %v", list, err)
+		}
+
+		update := &scopeTodo{BasicModel: orm.BasicModel{ID: other.ID}, Title: "taken"}
+		if _, err := Update(acme, update, &enum.UpdateOption{}); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("update: want not found, got %v", err)
+		}
+		if _, err := UpdateField[scopeTodo](acme, other.ID, "title", "taken"); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("update field: want not found, got %v", err)
+		}
+		if _, err := UpdateMany[scopeTodo](acme, map[string]any{"title": "taken"}, []string{"title"}, FilterBy("id", other.ID)); err != nil {
+			t.Errorf("update many: %v", err)
+		}
+		if _, err := DeleteByID[scopeTodo](acme, other.ID, &enum.DelOption{}); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("delete: want not found, got %v", err)
+		}
+		if n, err := Delete(acme, &scopeTodo{BasicModel: orm.BasicModel{ID: other.ID}}); n != 0 || err != nil {
+			t.Errorf("delete model: want nothing deleted, got %d %v", n, err)
+		}
+		if n, err := DeleteMany[scopeTodo](acme, FilterBy("id", other.ID)); n != 0 || err != nil {
+			t.Errorf("delete many: want nothing deleted, got %d %v", n, err)
+		}
+		if todo := stored(other.ID); todo.Title != "other" || todo.Tenant != "globex" || todo.DeletedAt.Valid {
+			t.Errorf("record of globex written by acme: %+v", todo)
+		}
+	})
+
+	t.Run("move out", func(t *testing.T) {
+		update := &scopeTodo{BasicModel: own.BasicModel, Title: "moved", Tenant: "globex"}
+		if _, err := Update(acme, update, &enum.UpdateOption{}); err != nil {
+			t.Errorf("update: %v", err)
+		}
+		if _, err := UpdateField[scopeTodo](acme, own.ID, "Tenant", "globex"); !errors.Is(err, ErrOutOfScope) {
+			t.Errorf("update field: want out of scope, got %v", err)
+		}
+		if _, err := UpdateMany[scopeTodo](acme, map[string]any{"tenant": "globex"}, []string{"tenant"}, FilterBy("id", own.ID)); err != nil {
+			t.Errorf("update many: %v", err)
+		}
+		if todo := stored(own.ID); todo.Tenant != "acme" || todo.Title != "moved" {
+			t.Errorf("want the record kept in acme, got %+v", todo)
+		}
+	})
+}
+
+type auditTodo struct {
+	orm.AuditedModel
+	Title string
+}
+
+func TestAudit(t *testing.T) {
+	connect(t, "audit", auditTodo{})
+	as := func(actor string) context.Context {
+		return orm.WithActor(context.Background(), actor)
+	}
+	stored := func(id uint) (todo auditTodo) {
+		orm.DB.Unscoped().First(&todo, id)
+		return todo
+	}
+	forged := orm.AuditedModel{CreatedBy: "mallory", UpdatedBy: "mallory", DeletedBy: "mallory"}
+
+	todo := &auditTodo{AuditedModel: forged, Title: "a"}
+	if err := Create(as("alice"), todo, &enum.CreateOption{}, IfNotExist()); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored(todo.ID); got.CreatedBy != "alice" || got.UpdatedBy != "alice" || got.DeletedBy != "" {
+		t.Errorf("create: want created and updated by alice, got %q %q %q", got.CreatedBy, got.UpdatedBy, got.DeletedBy)
+	}
+
+	update := &auditTodo{AuditedModel: forged, Title: "b"}
+	update.BasicModel = stored(todo.ID).BasicModel
+	if _, err := Update(as("bob"), update, &enum.UpdateOption{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored(todo.ID); got.Title != "b" || got.CreatedBy != "alice" || got.UpdatedBy != "bob" || got.DeletedBy != "" {
+		t.Errorf("update: want created by alice, updated by bob, got %q %q %q", got.CreatedBy, got.UpdatedBy, got.DeletedBy)
+	}
+
+	if _, err := UpdateField[auditTodo](as("bob"), todo.ID, "CreatedBy", "mallory"); !errors.Is(err, ErrAuditField) {
+		t.Errorf("update field CreatedBy: want refused, got %v", err)
+	}
+	values := map[string]any{"title": "c", "created_by": "mallory", "updated_by": "mallory"}
+	if _, err := UpdateMany[auditTodo](as("carol"), values, []string{"title", "created_by", "updated_by"}, FilterBy("id", todo.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored(todo.ID); got.Title != "c" || got.CreatedBy != "alice" || got.UpdatedBy != "carol" {
+		t.Errorf("update many: want created by alice, updated by carol, got %q %q", got.CreatedBy, got.UpdatedBy)
+	}
+
+	upsert := &auditTodo{AuditedModel: forged, Title: "d"}
+	upsert.ID = todo.ID
+	if err := Create(as("dave"), upsert, &enum.CreateOption{}, Upsert("Title")); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored(todo.ID); got.Title != "d" || got.CreatedBy != "alice" || got.UpdatedBy != "dave" || got.DeletedBy != "" {
+		t.Errorf("upsert: want created by alice, updated by dave, got %q %q %q", got.CreatedBy, got.UpdatedBy, got.DeletedBy)
+	}
+
+	if _, err := DeleteByID[auditTodo](as("erin"), todo.ID, &enum.DelOption{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := stored(todo.ID); !got.DeletedAt.Valid || got.DeletedBy != "erin" || got.CreatedBy != "alice" {
+		t.Errorf("delete: want deleted by erin, got %+v", got.AuditedModel)
+	}
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/tqrj/cd/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// modelKey is the span attribute of the model type.
+const modelKey = attribute.Key("crud.model")
+
+// startSpan starts the span of a service call, named "service.<name>",
+// with the type of model. The span is a no-op if the tracing is not
+// enabled, see package tracing.
+func startSpan(ctx context.Context, name string, model any) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "service."+name, trace.WithAttributes(
+		modelKey.String(strings.TrimLeft(fmt.Sprintf("%T", model), "*"))))
+}
+
+// endSpan ends the span with the error returned by the service call:
+//
+//	ctx, span := startSpan(ctx, "Get", new(T))
+//	defer endSpan(span, &err)
+func endSpan(span trace.Span, err *error) {
+	tracing.End(span, *err)
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"github.com/tqrj/cd/event"
+	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"sync"
+)
+
+// txKey is the context key of the transaction started by Transaction.
+type txKey struct{}
+
+// txState is a running transaction and the changes made in it.
+type txState struct {
+	tx      *gorm.DB
+	changes []event.Change // to be published after commit
+}
+
+// Transaction runs fn in a database transaction. All services called with
+// the ctx given to fn share the transaction, for example:
+//
+//	err := Transaction(ctx, func(ctx context.Context) error {
+//	    if err := Create(ctx, &order, opt, IfNotExist()); err != nil {
+//	        return err  // rollback
+//	    }
+//	    _, err := UpdateField[Product](ctx, order.ProductID, "stock", ...)
+//	    return err  // commit if err == nil
+//	})
+//
+// The transaction is committed if fn returns nil, otherwise rolled back.
+// A Transaction inside another Transaction is a nested transaction
+// (i.e. a SAVEPOINT) of the outer one.
+//
+// Change events of the writes in the transaction are published after the
+// (outermost) transaction is committed, and dropped if it's rolled back.
+func Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	state := &txState{}
+	err := DB(ctx).Transaction(func(tx *gorm.DB) error {
+		state.tx = tx
+		return fn(context.WithValue(ctx, txKey{}, state))
+	})
+	if err != nil {
+		return err
+	}
+
+	if outer, ok := ctx.Value(txKey{}).(*txState); ok {
+		outer.changes = append(outer.changes, state.changes...)
+		return nil
+	}
+	for _, change := range state.changes {
+		_ = event.PublishChange(ctx, change)
+	}
+	return nil
+}
+
+// DB returns the transaction in the ctx if any, or the global orm.DB
+// (the DB of the tenant in ctx, see orm.DBFor), with the ctx set.
+// Use it instead of orm.DB to implement your own services that work in
+// a Transaction.
+func DB(ctx context.Context) *gorm.DB {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return state.tx.WithContext(orm.QueryContext(ctx))
+	}
+	return orm.DBFor(ctx)
+}
+
+// WriteHook is called in the transaction of each write (Create, Update,
+// UpdateField, Delete, DeleteByID, DeleteNested) with the change made.
+// Bulk writes (UpdateMany, DeleteMany) call it with the change of each
+// affected record.
+// Use DB(ctx) in the hook to read / write in the same transaction.
+// Returning an error fails the write and rolls back the transaction.
+type WriteHook func(ctx context.Context, change event.Change) error
+
+var (
+	writeHooksMu sync.RWMutex
+	writeHooks   []WriteHook
+)
+
+// AddWriteHook adds a WriteHook for all writes, e.g. to write a
+// transactional outbox or change log.
+func AddWriteHook(hook WriteHook) {
+	writeHooksMu.Lock()
+	defer writeHooksMu.Unlock()
+	writeHooks = append(writeHooks, hook)
+}
+
+// write runs the write fn in a transaction, and records the change if
+// it succeeds.
+func write(ctx context.Context, change event.Change, fn func(db *gorm.DB) error) error {
+	return Transaction(ctx, func(ctx context.Context) error {
+		if err := fn(DB(ctx)); err != nil {
+			return err
+		}
+		return record(ctx, change)
+	})
+}
+
+// record calls the write hooks, and publishes the change event after
+// the transaction in ctx is committed.
+func record(ctx context.Context, change event.Change) error {
+	writeHooksMu.RLock()
+	hooks := writeHooks
+	writeHooksMu.RUnlock()
+	for _, hook := range hooks {
+		if err := hook(ctx, change); err != nil {
+			logger.WithContext(ctx).WithError(err).
+				WithField("kind", change.Kind).
+				Warn("record: write hook failed")
+			return err
+		}
+	}
+
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		state.changes = append(state.changes, change)
+		return nil
+	}
+	_ = event.PublishChange(ctx, change)
+	return nil
+}
+
+// recorded reports whether the changes of the kind to the model are
+// recorded by anyone: a write hook or an event subscriber.
+func recorded(kind event.Kind, model any) bool {
+	writeHooksMu.RLock()
+	hooked := len(writeHooks) != 0
+	writeHooksMu.RUnlock()
+	return hooked || event.Subscribed(kind, model)
+}
+
+// bulkBatchSize is the number of models written by a statement of
+// writeMany when the changes are recorded.
+var bulkBatchSize = 500
+
+// writeMany runs the bulk write fn on the models T matched by the query,
+// in a transaction, and records the change of each affected model.
+//
+// If nobody records the changes, fn is run on the query as a single
+// statement. Otherwise, the matched models are loaded in batches (by the
+// order of the primary key), fn is run on the query restricted to the
+// primary keys of each batch (so the models written are exactly the ones
+// loaded), and the updated models are reloaded for the changes.
+func writeMany[T any](ctx context.Context, kind event.Kind, query func(ctx context.Context) *gorm.DB, fn func(query *gorm.DB) (int64, error)) (rowsAffected int64, err error) {
+	if !recorded(kind, new(T)) {
+		err = Transaction(ctx, func(ctx context.Context) error {
+			rowsAffected, err = fn(query(ctx))
+			return err
+		})
+		return rowsAffected, err
+	}
+
+	s, err := parseSchema(new(T))
+	if err != nil {
+		return 0, err
+	}
+	pk := s.PrioritizedPrimaryField
+	if pk == nil {
+		return 0, ErrNoIdentityField
+	}
+	column := clause.Column{Table: clause.CurrentTable, Name: pk.DBName}
+	err = Transaction(ctx, func(ctx context.Context) error {
+		var last any
+		for {
+			// the whole models are loaded for the changes, whatever
+			// the options omit from the write.
+			load := query(ctx)
+			load.Statement.Omits = nil
+			if last != nil {
+				load = load.Where(clause.Gt{Column: column, Value: last})
+			}
+			var olds []T
+			err := load.Order(clause.OrderByColumn{Column: column}).
+				Limit(bulkBatchSize).Find(&olds).Error
+			if err != nil || len(olds) == 0 {
+				return err
+			}
+			ids := make([]any, len(olds))
+			for i := range olds {
+				ids[i], _ = pk.ValueOf(ctx, reflect.ValueOf(&olds[i]).Elem())
+			}
+			last = ids[len(ids)-1]
+
+			n, err := fn(query(ctx).Where(map[string]any{pk.DBName: ids}))
+			rowsAffected += n
+			if err != nil {
+				return err
+			}
+			if err := recordMany(ctx, kind, pk, ids, olds); err != nil {
+				return err
+			}
+			if len(olds) < bulkBatchSize {
+				return nil
+			}
+		}
+	})
+	return rowsAffected, err
+}
+
+// recordMany records the changes of the models written by writeMany:
+// olds are the models before the write, of the primary keys ids.
+func recordMany[T any](ctx context.Context, kind event.Kind, pk *schema.Field, ids []any, olds []T) error {
+	news := map[any]*T{}
+	if kind != event.KindDeleted {
+		var models []T
+		err := scoped[T](ctx, DB(ctx).Model(new(T))).
+			Where(map[string]any{pk.DBName: ids}).Find(&models).Error
+		if err != nil {
+			return err
+		}
+		for i := range models {
+			id, _ := pk.ValueOf(ctx, reflect.ValueOf(&models[i]).Elem())
+			news[id] = &models[i]
+		}
+	}
+	for i := range olds {
+		change := event.Change{Kind: kind, Model: &olds[i]}
+		if kind != event.KindDeleted {
+			model, ok := news[ids[i]]
+			if !ok {
+				continue
+			}
+			change.Model, change.Old = model, &olds[i]
+		}
+		if err := record(ctx, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,11 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/event"
 	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+	"reflect"
 )
 
 // Update all fields of an existing model in database.
 func Update(ctx context.Context, model any, opt *enum.UpdateOption) (rowsAffected int64, err error) {
+	ctx, span := startSpan(ctx, "Update", model)
+	defer endSpan(span, &err)
+
 	logger.WithContext(ctx).
 		WithField("model", model).Trace("Update model")
 
@@ -18,17 +24,107 @@ func Update(ctx context.Context, model any, opt *enum.UpdateOption) (rowsAffecte
 			Warn("Update: model is nil, nothing to update")
 		return 0, ErrNoRecord
 	}
-	db := orm.DB.WithContext(ctx)
-	db = Omit(opt.Omit)(db)
-	result := db.Save(model)
-	if result.Error != nil {
+	if err := stamp(ctx, model); err != nil {
+		return 0, err
+	}
+	auditUpdated(ctx, model)
+	var old any
+	if event.Subscribed(event.KindUpdated, model) {
+		old = getOld(ctx, model)
+	}
+
+	err = write(ctx, event.Change{Kind: event.KindUpdated, Model: model, Old: old}, func(db *gorm.DB) error {
+		if err := checkScope(ctx, db, model); err != nil {
+			return err
+		}
+		db = Omit(auditOmit(model, opt.Omit))(db)
+		result := db.Save(model)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
 		logger.WithContext(ctx).
-			WithError(result.Error).Warn("Update: failed")
+			WithError(err).Warn("Update: failed")
 	}
-	return result.RowsAffected, result.Error
+	return rowsAffected, err
+}
+
+// getOld loads the current record of the model (*T) from database by its
+// primary key. It returns nil if failed.
+func getOld(ctx context.Context, model any) any {
+	m, ok := model.(orm.Model)
+	if !ok {
+		return nil
+	}
+	idField, id := m.Identity()
+	old := reflect.New(reflect.TypeOf(model).Elem()).Interface()
+	if err := FilterBy(idField, id)(DB(ctx)).Take(old).Error; err != nil {
+		logger.WithContext(ctx).WithError(err).
+			Warn("getOld: load model before update failed")
+		return nil
+	}
+	return old
+}
+
+// UpdateMany updates the given fields of all models T matched by options
+// in a single UPDATE statement. values is a *T (or a map) carrying the new
+// values, and fields are the (struct field or column) names to be written,
+// zero values included.
+//
+// An Updated change is recorded (write hooks, events) for each updated
+// model, with its old and new values, as Update does. To record them, the
+// models are loaded and updated in batches of 500 instead, in a single
+// transaction.
+//
+// GORM refuses to update without any condition, use AllowGlobal to
+// explicitly update the whole table.
+func UpdateMany[T any](ctx context.Context, values any, fields []string, options ...enum.QueryOption) (rowsAffected int64, err error) {
+	ctx, span := startSpan(ctx, "UpdateMany", new(T))
+	defer endSpan(span, &err)
+
+	logger := logger.WithContext(ctx).
+		WithField("model", fmt.Sprintf("%T", *new(T))).
+		WithField("fields", fields)
+	logger.Trace("UpdateMany: Update models")
+
+	if _, ok := any(new(T)).(orm.Audited); ok {
+		fields = auditUnset(values, fields)
+	}
+	if len(fields) == 0 {
+		logger.Warn("UpdateMany: no fields to update")
+		return 0, ErrNoFields
+	}
+
+	if err := stampAs(ctx, reflect.TypeOf(*new(T)), values); err != nil {
+		return 0, err
+	}
+	if _, ok := any(new(T)).(orm.Audited); ok {
+		actor, _ := orm.ActorFrom(ctx)
+		if m, ok := values.(map[string]any); ok {
+			m["updated_by"] = actor
+		} else {
+			auditUpdated(ctx, values)
+		}
+		fields = append(fields[:len(fields):len(fields)], "UpdatedBy")
+	}
+	rowsAffected, err = writeMany[T](ctx, event.KindUpdated, func(ctx context.Context) *gorm.DB {
+		query := scoped[T](ctx, DB(ctx).Model(new(T)))
+		for _, option := range options {
+			query = option(query)
+		}
+		return query
+	}, func(query *gorm.DB) (int64, error) {
+		result := query.Select(fields).Updates(values)
+		return result.RowsAffected, result.Error
+	})
+	if err != nil {
+		logger.WithError(err).Warn("UpdateMany: failed")
+	}
+	return rowsAffected, err
 }
 
 var (
+	ErrNoFields        = errors.New("no fields to update")
 	ErrNoRecord        = errors.New("no record found")
 	ErrMultipleRecords = errors.New("multiple records found")
 )
@@ -36,6 +132,9 @@ var (
 // UpdateField updates a single fields of an existing model in database.
 // It will try to GetByID first, to make sure the model exists, before updating.
 func UpdateField[T orm.Model](ctx context.Context, id any, field string, value interface{}) (rowsAffected int64, err error) {
+	ctx, span := startSpan(ctx, "UpdateField", new(T))
+	defer endSpan(span, &err)
+
 	logger.WithContext(ctx).
 		WithField("model", fmt.Sprintf("%T", *new(T))).
 		WithField("id", id).WithField("field", field).
@@ -48,10 +147,31 @@ func UpdateField[T orm.Model](ctx context.Context, id any, field string, value i
 			Warn("UpdateField: GetByID failed")
 		return 0, err
 	}
-	result := orm.DB.WithContext(ctx).Model(&record).Update(field, value)
-	if result.Error != nil {
+	if ok, err := isScopeField(ctx, &record, field); ok || err != nil {
+		if err == nil {
+			err = ErrOutOfScope
+		}
+		return 0, err
+	}
+	if _, ok := any(&record).(orm.Audited); ok && auditFields[field] {
+		return 0, ErrAuditField
+	}
+	old := record
+	err = write(ctx, event.Change{Kind: event.KindUpdated, Model: &record, Old: &old}, func(db *gorm.DB) error {
+		var result *gorm.DB
+		if audited, ok := any(&record).(orm.Audited); ok {
+			actor, _ := orm.ActorFrom(ctx)
+			audited.Audit().UpdatedBy = actor
+			result = db.Model(&record).Updates(map[string]any{field: value, "UpdatedBy": actor})
+		} else {
+			result = db.Model(&record).Update(field, value)
+		}
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
 		logger.WithContext(ctx).
-			WithError(result.Error).Warn("UpdateField: failed")
+			WithError(err).Warn("UpdateField: failed")
 	}
-	return result.RowsAffected, result.Error
+	return rowsAffected, err
 }
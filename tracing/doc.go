@@ -0,0 +1,23 @@
+// Package tracing instruments the requests, the service calls and the GORM
+// queries with OpenTelemetry spans:
+//
+//	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+//	otel.SetTracerProvider(tp)
+//	if err := orm.Use(tracing.Plugin()); err != nil { ... } // DB spans
+//	r := router.NewRouter(router.WithTracing())           // server spans
+//
+// which makes a span tree of each request:
+//
+//	GET /todos/:TodoID                  (Middleware)
+//	└── service.GetByID                 (service, by Start)
+//	    └── service.Get
+//	        └── query todos             (Plugin)
+//
+// The tracing is optional: without a TracerProvider set (otel's global
+// one is a no-op by default), nothing is recorded.
+//
+// The spans are found in a *gin.Context (which hides the context of its
+// request) as well, so the services can be called with the gin.Context as
+// usual. log.TraceIDHook adds the trace_id and span_id of the span to the
+// log entries.
+package tracing
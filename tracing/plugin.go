@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// Plugin returns the GORM plugin recording a span for each query, as a
+// child of the span in the ctx of the query (gorm.DB.WithContext). Apply
+// it by orm.Use (or gorm.DB.Use).
+//
+// The spans are named by the operation and the table (e.g. "query todos"),
+// with the SQL statement (the vars are not recorded) as db.statement.
+func Plugin(options ...Option) gorm.Plugin {
+	return &plugin{config: newConfig(options)}
+}
+
+type plugin struct {
+	config *config
+}
+
+func (p *plugin) Name() string {
+	return "crud:tracing"
+}
+
+const spanKey = "crud:tracing_span"
+
+// rowsAffectedKey is the attribute of gorm.DB.RowsAffected.
+const rowsAffectedKey = attribute.Key("db.rows_affected")
+
+// register is a callback processor of gorm, registering the callback
+// before or after a gorm callback.
+type register interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+func (p *plugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	for _, c := range []struct {
+		operation     string
+		before, after register
+	}{
+		{"create", callbacks.Create().Before("gorm:create"), callbacks.Create().After("gorm:create")},
+		{"query", callbacks.Query().Before("gorm:query"), callbacks.Query().After("gorm:query")},
+		{"update", callbacks.Update().Before("gorm:update"), callbacks.Update().After("gorm:update")},
+		{"delete", callbacks.Delete().Before("gorm:delete"), callbacks.Delete().After("gorm:delete")},
+		{"row", callbacks.Row().Before("gorm:row"), callbacks.Row().After("gorm:row")},
+		{"raw", callbacks.Raw().Before("gorm:raw"), callbacks.Raw().After("gorm:raw")},
+	} {
+		if err := c.before.Register("crud:tracing_before_"+c.operation, p.before(c.operation)); err != nil {
+			return err
+		}
+		if err := c.after.Register("crud:tracing_after_"+c.operation, p.after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *plugin) before(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := withSpan(db.Statement.Context)
+		name := operation
+		if db.Statement.Table != "" {
+			name += " " + db.Statement.Table
+		}
+		_, span := p.config.tracer(ctx).Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemKey.String(db.Dialector.Name()),
+				semconv.DBOperation(operation),
+				semconv.DBSQLTable(db.Statement.Table),
+			))
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func (p *plugin) after(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(semconv.DBStatement(db.Statement.SQL.String()))
+	if db.Error == nil {
+		span.SetAttributes(rowsAffectedKey.Int64(db.RowsAffected))
+	}
+	End(span, db.Error)
+}
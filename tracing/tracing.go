@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+// instrumentationName is the name of the tracers.
+const instrumentationName = "github.com/tqrj/cd"
+
+// config of the Middleware and the Plugin.
+type config struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+}
+
+// Option is an option of the Middleware and the Plugin.
+type Option func(c *config)
+
+// WithTracerProvider records the spans into provider, instead of otel's
+// global one.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// WithPropagator extracts the remote parent spans of the requests by
+// propagator (e.g. propagation.TraceContext{} for the traceparent header),
+// instead of otel's global one.
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = propagator
+	}
+}
+
+func newConfig(options []Option) *config {
+	c := &config{}
+	for _, option := range options {
+		option(c)
+	}
+	if c.propagator == nil {
+		c.propagator = otel.GetTextMapPropagator()
+	}
+	return c
+}
+
+// tracer returns the tracer of the provider in the config, or the
+// provider of the parent span in ctx, or otel's global provider.
+func (c *config) tracer(ctx context.Context) trace.Tracer {
+	provider := c.provider
+	if provider == nil {
+		provider = providerOf(ctx)
+	}
+	return provider.Tracer(instrumentationName)
+}
+
+// Middleware starts a server span for each request, named by the method
+// and the route template (e.g. "GET /todos/:TodoID"), as a child of the
+// span propagated by the request headers if any. The span is set into the
+// context of the request, so the spans started (by Start) in the handlers
+// are its children.
+//
+// Responses of 5xx mark the span as an error.
+func Middleware(options ...Option) gin.HandlerFunc {
+	cfg := newConfig(options)
+	return func(c *gin.Context) {
+		ctx := cfg.propagator.Extract(c.Request.Context(),
+			propagation.HeaderCarrier(c.Request.Header))
+
+		attributes := []attribute.KeyValue{
+			semconv.HTTPMethod(c.Request.Method),
+			semconv.HTTPTarget(c.Request.URL.RequestURI()),
+		}
+		name := c.Request.Method + " unmatched"
+		if route := c.FullPath(); route != "" {
+			name = c.Request.Method + " " + route
+			attributes = append(attributes, semconv.HTTPRoute(route))
+		}
+		ctx, span := cfg.tracer(ctx).Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attributes...))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		if len(c.Errors) > 0 {
+			span.RecordError(c.Errors.Last())
+		}
+	}
+}
+
+// Start starts a span named name, as a child of the span in ctx. The ctx
+// can be a *gin.Context, whose request context holds the span.
+//
+// The span is recorded by the provider of its parent, or otel's global
+// provider without a parent. End the span by End.
+func Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	ctx = withSpan(ctx)
+	return providerOf(ctx).Tracer(instrumentationName).Start(ctx, name, options...)
+}
+
+// End ends the span, marking it as an error if err is not nil.
+// gorm.ErrRecordNotFound is not an error here.
+func End(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// SpanFromContext returns the span in ctx, including the span in the
+// request context of a *gin.Context. It returns a no-op span if there is
+// none.
+func SpanFromContext(ctx context.Context) trace.Span {
+	return trace.SpanFromContext(withSpan(ctx))
+}
+
+// withSpan returns ctx with the span of its http request (a *gin.Context
+// hides it) set, if ctx itself does not have a span.
+func withSpan(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	request, ok := ctx.Value(0).(*http.Request) // gin.Context.Value(0) is its Request
+	if !ok || request == nil {
+		return ctx
+	}
+	span := trace.SpanFromContext(request.Context())
+	if !span.SpanContext().IsValid() {
+		return ctx
+	}
+	return trace.ContextWithSpan(ctx, span)
+}
+
+// providerOf returns the provider of the span in ctx, or otel's global
+// provider if there is no span.
+func providerOf(ctx context.Context) trace.TracerProvider {
+	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+		return span.TracerProvider()
+	}
+	return otel.GetTracerProvider()
+}
@@ -0,0 +1,165 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/tqrj/cd/log"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+	"github.com/tqrj/cd/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type widget struct {
+	orm.BasicModel
+	Name string
+}
+
+// setup returns a router with the tracing middleware, and the DB with the
+// tracing plugin, recording the spans into the exporter.
+func setup(t *testing.T, dsn string) (*gin.Engine, *tracetest.InMemoryExporter) {
+	gin.SetMode(gin.TestMode)
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(tracing.Plugin()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&widget{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&widget{Name: "w1"})
+	orm.UseDB(db)
+
+	r := gin.New()
+	r.Use(tracing.Middleware(
+		tracing.WithTracerProvider(provider),
+		tracing.WithPropagator(propagation.TraceContext{})))
+	return r, exporter
+}
+
+func TestSpanTree(t *testing.T) {
+	r, exporter := setup(t, "file:tracing_tree?mode=memory&cache=shared")
+	logger, hook := test.NewNullLogger()
+	log.WithHook(log.TraceIDHook())(logger)
+
+	r.GET("/widgets/:WidgetID", func(c *gin.Context) {
+		var w widget
+		if err := service.GetByID[widget](c, c.Param("WidgetID"), &w); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		logger.WithContext(c).Info("got")
+		c.String(http.StatusOK, w.Name)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/widgets/1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	for child, parent := range map[string]string{
+		"service.GetByID": "GET /widgets/:WidgetID",
+		"service.Get":     "service.GetByID",
+		"query widgets":   "service.Get",
+	} {
+		c, ok := spans[child]
+		if !ok {
+			t.Fatalf("missing span %q in %v", child, spans)
+		}
+		if p := spans[parent]; c.Parent.SpanID() != p.SpanContext.SpanID() {
+			t.Errorf("parent of %q is not %q", child, parent)
+		}
+	}
+
+	server := spans["GET /widgets/:WidgetID"]
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("missing log entry")
+	}
+	if entry.Data["trace_id"] != server.SpanContext.TraceID().String() ||
+		entry.Data["span_id"] != server.SpanContext.SpanID().String() {
+		t.Errorf("log entry fields = %v, want the ids of %v", entry.Data, server.SpanContext)
+	}
+}
+
+func TestPropagationAndErrors(t *testing.T) {
+	r, exporter := setup(t, "file:tracing_errors?mode=memory&cache=shared")
+	r.GET("/widgets/:WidgetID", func(c *gin.Context) {
+		var w widget
+		err := service.GetByID[widget](c, c.Param("WidgetID"), &w)
+		if err == nil {
+			err = service.DB(c).Raw("SELECT * FROM nope").Scan(&w).Error
+		}
+		c.String(http.StatusInternalServerError, "%v", err)
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/widgets/404", nil)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), request)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/widgets/1", nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) == 0 {
+		t.Fatal("no spans")
+	}
+	var server, notFound, failed int
+	for _, span := range spans {
+		switch span.Name {
+		case "GET /widgets/:WidgetID":
+			server++
+			if span.Status.Code != codes.Error {
+				t.Errorf("status of %q = %v, want error", span.Name, span.Status)
+			}
+			if server == 1 && span.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+				t.Errorf("trace id = %v, want the propagated one", span.SpanContext.TraceID())
+			}
+		case "service.GetByID":
+			// record not found is not an error of the span
+			if span.Status.Code == codes.Error {
+				t.Errorf("status of %q = %v", span.Name, span.Status)
+			}
+			notFound++
+		case "row": // Raw().Scan()
+			if span.Status.Code != codes.Error {
+				t.Errorf("status of %q = %v, want error", span.Name, span.Status)
+			}
+			failed++
+		}
+	}
+	if server != 2 || notFound != 2 || failed != 1 {
+		t.Errorf("server, GetByID, row spans = %d, %d, %d", server, notFound, failed)
+	}
+}
+
+func TestNoTracing(t *testing.T) {
+	span := tracing.SpanFromContext(&gin.Context{})
+	if span.SpanContext().IsValid() || span.IsRecording() {
+		t.Errorf("span without tracing = %v", span)
+	}
+	entry := logrus.NewEntry(logrus.New()).WithContext(&gin.Context{})
+	if err := log.TraceIDHook().Fire(entry); err != nil || len(entry.Data) != 0 {
+		t.Errorf("Fire = %v, fields = %v", err, entry.Data)
+	}
+}
@@ -0,0 +1,277 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/tqrj/cd/job"
+	"github.com/tqrj/cd/orm"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Dispatcher delivers the pending messages in the outbox.
+type Dispatcher struct {
+	client       *http.Client
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	backoff      func(attempt int) time.Duration
+
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// DispatcherOption is an option to construct the Dispatcher.
+type DispatcherOption func(d *Dispatcher)
+
+// NewDispatcher creates a Dispatcher. By default, it polls the outbox every
+// second, delivers with a 10s timeout, and tries each message 5 times with
+// exponential backoff (1s, 2s, 4s... up to 1 hour) before marking it dead.
+func NewDispatcher(options ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		client:       &http.Client{Timeout: 10 * time.Second},
+		pollInterval: time.Second,
+		batchSize:    100,
+		maxAttempts:  5,
+		backoff:      job.ExponentialBackoff(time.Second, time.Hour),
+	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+// WithHTTPClient sets the http.Client to deliver messages.
+func WithHTTPClient(client *http.Client) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.client = client
+	}
+}
+
+// WithPollInterval sets how often the outbox is checked for due messages.
+func WithPollInterval(interval time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.pollInterval = interval
+	}
+}
+
+// WithBatchSize sets the max number of messages delivered in a poll.
+func WithBatchSize(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.batchSize = n
+	}
+}
+
+// WithMaxAttempts sets the attempts of a message before it's dead.
+func WithMaxAttempts(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.maxAttempts = n
+	}
+}
+
+// WithBackoff sets the delay before retrying a message failed for
+// attempt times.
+func WithBackoff(backoff func(attempt int) time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.backoff = backoff
+	}
+}
+
+// Start starts the dispatching goroutine. Call Enable before it.
+func (d *Dispatcher) Start() {
+	ctx, stop := context.WithCancel(context.Background())
+	d.stop = stop
+	d.wg.Add(1)
+	go d.loop(ctx)
+	logger.Info("Dispatcher started")
+}
+
+// Stop stops the dispatching goroutine and waits for it to exit.
+func (d *Dispatcher) Stop() {
+	if d.stop == nil {
+		return
+	}
+	d.stop()
+	d.wg.Wait()
+	logger.Info("Dispatcher stopped")
+}
+
+func (d *Dispatcher) loop(ctx context.Context) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Dispatch(ctx); err != nil && ctx.Err() == nil {
+			logger.WithError(err).Warn("Dispatcher: dispatch failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch delivers a batch of due messages once, and returns the number
+// of messages tried. It's what the Dispatcher does every poll interval,
+// and is useful in tests.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	var messages []Message
+	err := orm.DB.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", StatusPending, time.Now()).
+		Order("id").Limit(d.batchSize).Find(&messages).Error
+	if err != nil {
+		return 0, err
+	}
+
+	tried := 0
+	for i := range messages {
+		if ctx.Err() != nil {
+			break
+		}
+		message := &messages[i]
+		claimed, err := d.claim(ctx, message)
+		if err != nil {
+			return tried, err
+		}
+		if !claimed {
+			continue
+		}
+		tried++
+		d.deliver(ctx, message)
+	}
+	return tried, nil
+}
+
+// claim counts an attempt of the message, and leases it for the client
+// timeout: if the dispatcher crashes in the delivery, the message is
+// retried after the lease. It returns false if someone else claimed it.
+func (d *Dispatcher) claim(ctx context.Context, message *Message) (bool, error) {
+	lease := d.client.Timeout
+	if lease <= 0 {
+		lease = time.Minute
+	}
+	result := orm.DB.WithContext(ctx).Model(&Message{}).
+		Where("id = ? AND status = ? AND attempts = ?", message.ID, StatusPending, message.Attempts).
+		Updates(map[string]any{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": time.Now().Add(2 * lease),
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	message.Attempts++
+	return true, nil
+}
+
+// deliver POSTs the message and saves the result.
+func (d *Dispatcher) deliver(ctx context.Context, message *Message) {
+	logger := logger.WithField("message", message.ID).
+		WithField("topic", message.Topic).
+		WithField("attempt", message.Attempts)
+
+	var subscription Subscription
+	err := orm.DB.WithContext(ctx).Take(&subscription, message.SubscriptionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = ErrNoSubscription
+	}
+	if err == nil {
+		err = d.post(ctx, &subscription, message)
+	}
+
+	db := orm.DB.WithContext(context.Background()).Model(&Message{}).
+		Where("id = ?", message.ID)
+	switch {
+	case err == nil:
+		now := time.Now()
+		db = db.Updates(map[string]any{
+			"status":       StatusDelivered,
+			"last_error":   "",
+			"delivered_at": &now,
+		})
+		logger.Debug("deliver: message delivered")
+	case ctx.Err() != nil: // stopping: retry it soon, not counting the attempt
+		db = db.Updates(map[string]any{
+			"attempts":        gorm.Expr("attempts - 1"),
+			"next_attempt_at": time.Now(),
+		})
+	case message.Attempts < d.maxAttempts && !errors.Is(err, ErrNoSubscription):
+		db = db.Updates(map[string]any{
+			"next_attempt_at": time.Now().Add(d.backoff(message.Attempts)),
+			"last_error":      err.Error(),
+		})
+		logger.WithError(err).Warn("deliver: delivery failed, will retry")
+	default:
+		db = db.Updates(map[string]any{
+			"status":     StatusDead,
+			"last_error": err.Error(),
+		})
+		logger.WithError(err).Warn("deliver: delivery failed, message is dead")
+	}
+	if db.Error != nil {
+		logger.WithError(db.Error).Error("deliver: save message status failed")
+	}
+}
+
+// post sends the signed request. Any non-2xx response is an error.
+func (d *Dispatcher) post(ctx context.Context, subscription *Subscription, message *Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(message.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, strconv.FormatUint(uint64(message.ID), 10))
+	req.Header.Set(HeaderTopic, message.Topic)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(subscription.Secret, timestamp, message.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%w: %s", ErrBadStatus, resp.Status)
+	}
+	return nil
+}
+
+// Delivery request headers.
+const (
+	HeaderID        = "X-Webhook-Id"
+	HeaderTopic     = "X-Webhook-Topic"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Sign returns the X-Webhook-Signature of the body:
+//
+//	sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the X-Webhook-Signature of a delivery, for receivers.
+func Verify(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+var (
+	ErrNoSubscription = errors.New("webhook subscription not found")
+	ErrBadStatus      = errors.New("webhook responded non-2xx status")
+)
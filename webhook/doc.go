@@ -0,0 +1,42 @@
+// Package webhook delivers the model changes to external HTTP endpoints
+// through a transactional outbox.
+//
+// Each write of the service package (Create, Update, Delete...) inserts a
+// Message for every matching active Subscription into the outbox table,
+// in the same database transaction as the write. So a message is saved if
+// and only if the write is committed.
+//
+// A Dispatcher polls the outbox and POSTs the messages to the subscribed
+// URLs, signed by HMAC-SHA256 with the secret of the subscription. Failed
+// deliveries are retried with an exponential backoff, and a message is
+// marked dead after running out of attempts. Dead messages can be
+// redelivered by Redeliver.
+//
+//	if err := webhook.Enable(); err != nil { ... }
+//	dispatcher := webhook.NewDispatcher(webhook.WithMaxAttempts(8))
+//	dispatcher.Start()
+//	defer dispatcher.Stop()
+//
+// And router.Webhooks adds the HTTP APIs to manage subscriptions:
+//
+//	POST   /webhooks        # { "url": "https://...", "secret": "...", "events": "Todo.*" }
+//	GET    /webhooks
+//	GET    /webhooks/:SubscriptionID
+//	PUT    /webhooks/:SubscriptionID
+//	DELETE /webhooks/:SubscriptionID
+//	POST   /webhooks/:SubscriptionID/redeliver
+//
+// A delivery is a POST request with the JSON body (see Payload) and
+// the headers:
+//
+//	X-Webhook-Id:        the message id, the same in retries
+//	X-Webhook-Topic:     e.g. Todo.created
+//	X-Webhook-Timestamp: unix seconds of the request
+//	X-Webhook-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// Receivers can check the signature with Verify.
+package webhook
+
+import "github.com/tqrj/cd/log"
+
+var logger = log.ZoneLogger("crud/webhook")
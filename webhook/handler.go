@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tqrj/cd/controller"
+)
+
+// RedeliverHandler handles
+//
+//	POST /webhooks/:idParam/redeliver
+//
+// Response:
+//   - 200 OK: { redelivered: n }
+//   - 422 Unprocessable Entity: { error: "..." }
+func RedeliverHandler(idParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n, err := Redeliver(c, c.Param(idParam))
+		if err != nil {
+			logger.WithContext(c).WithError(err).
+				Warn("RedeliverHandler: redeliver failed")
+			controller.ResponseError(c, controller.CodeProcessFailed, err)
+			return
+		}
+		controller.ResponseSuccess(c, nil, gin.H{"redelivered": n})
+	}
+}
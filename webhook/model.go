@@ -0,0 +1,82 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/tqrj/cd/orm"
+	"strings"
+	"time"
+)
+
+// Subscription is a webhook endpoint subscribed to some topics.
+type Subscription struct {
+	orm.BasicModel
+	URL    string `json:"url" binding:"required,url"`
+	Secret string `json:"secret,omitempty"` // to sign the deliveries, never responded
+	// Events is a comma separated list of topics to subscribe:
+	// "Todo.created", "Todo.*", "*.deleted". Empty or "*" for all.
+	Events string `json:"events"`
+	Active bool   `json:"active" gorm:"index"`
+}
+
+// MarshalJSON encodes the subscription without its Secret: the Secret is
+// written by clients, and never responded (e.g. by PUT /webhooks/:id).
+func (s Subscription) MarshalJSON() ([]byte, error) {
+	type subscription Subscription // without the MarshalJSON
+	view := subscription(s)
+	view.Secret = ""
+	return json.Marshal(view)
+}
+
+// Matches reports whether the subscription is subscribed to the topic
+// ("Model.kind").
+func (s *Subscription) Matches(topic string) bool {
+	if strings.TrimSpace(s.Events) == "" {
+		return true
+	}
+	model, kind, _ := strings.Cut(topic, ".")
+	for _, pattern := range strings.Split(s.Events, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "*" || pattern == topic {
+			return true
+		}
+		m, k, _ := strings.Cut(pattern, ".")
+		if (m == "*" || m == model) && (k == "*" || k == kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// Status is the status of a Message.
+type Status string
+
+// Message statuses.
+const (
+	StatusPending   Status = "pending"   // waiting for (re)delivery
+	StatusDelivered Status = "delivered" // responded 2xx
+	StatusDead      Status = "dead"      // out of attempts
+)
+
+// Message is a record of the outbox table: a change to deliver to a
+// subscription.
+type Message struct {
+	orm.BasicModel
+	SubscriptionID uint            `json:"subscriptionId" gorm:"index"`
+	Topic          string          `json:"topic"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         Status          `json:"status" gorm:"index"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt" gorm:"index"`
+	LastError      string          `json:"lastError"`
+	DeliveredAt    *time.Time      `json:"deliveredAt"`
+}
+
+// Payload is the JSON body of deliveries.
+type Payload struct {
+	Topic      string    `json:"topic"`
+	Model      any       `json:"model"`
+	Old        any       `json:"old,omitempty"`   // updated: the value before
+	Field      string    `json:"field,omitempty"` // associated, dissociated
+	Child      any       `json:"child,omitempty"` // associated, dissociated
+	OccurredAt time.Time `json:"occurredAt"`
+}
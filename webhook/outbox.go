@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/tqrj/cd/changelog"
+	"github.com/tqrj/cd/event"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var enableOnce sync.Once
+
+// Enable migrates the subscriptions and outbox tables, and adds the write
+// hook to put changes into the outbox. Call it once after orm.ConnectDB.
+//
+// The subscriptions and messages are excluded from the change log, not to
+// leak the secrets. The outbox is delivered from the base DB, so it can not
+// be enabled with the tenancy (see orm.RequireBaseDB).
+func Enable() error {
+	if err := orm.RequireBaseDB("webhook"); err != nil {
+		return err
+	}
+	if err := orm.RegisterModel(&Subscription{}, &Message{}); err != nil {
+		return err
+	}
+	changelog.Exclude(&Subscription{}, &Message{})
+	enableOnce.Do(func() {
+		service.AddWriteHook(writeOutbox)
+	})
+	return nil
+}
+
+// Redeliver puts the dead messages of the subscription back to pending,
+// to be delivered again with fresh attempts.
+func Redeliver(ctx context.Context, subscriptionID any) (int64, error) {
+	result := orm.DB.WithContext(ctx).Model(&Message{}).
+		Where("subscription_id = ? AND status = ?", subscriptionID, StatusDead).
+		Updates(map[string]any{
+			"status":          StatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// writeOutbox is the service.WriteHook inserting a Message for each
+// matching active subscription, in the transaction of the write.
+func writeOutbox(ctx context.Context, change event.Change) error {
+	if isOwnModel(change.Model) {
+		return nil // not to leak secrets, and no loops.
+	}
+	db := service.DB(ctx)
+
+	var subscriptions []Subscription
+	if err := db.Where("active = ?", true).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+	topic := Topic(change)
+
+	var messages []Message
+	var payload []byte
+	for _, s := range subscriptions {
+		if !s.Matches(topic) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			payload, err = json.Marshal(Payload{
+				Topic:      topic,
+				Model:      change.Model,
+				Old:        change.Old,
+				Field:      change.Field,
+				Child:      change.Child,
+				OccurredAt: time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		messages = append(messages, Message{
+			SubscriptionID: s.ID,
+			Topic:          topic,
+			Payload:        payload,
+			Status:         StatusPending,
+			NextAttemptAt:  time.Now(),
+		})
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return db.Create(&messages).Error
+}
+
+// Topic returns the topic of the change: "Model.kind", e.g. "Todo.created".
+func Topic(change event.Change) string {
+	return typeName(change.Model) + "." + string(change.Kind)
+}
+
+func typeName(model any) string {
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
+
+func isOwnModel(model any) bool {
+	switch model.(type) {
+	case *Subscription, *Message, Subscription, Message:
+		return true
+	}
+	return false
+}
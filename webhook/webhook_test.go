@@ -0,0 +1,150 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tqrj/cd/enum"
+	"github.com/tqrj/cd/orm"
+	"github.com/tqrj/cd/service"
+)
+
+type todo struct {
+	orm.BasicModel
+	Title string `json:"title"`
+}
+
+func setup(t *testing.T) {
+	if _, err := orm.ConnectDB(orm.DBDriverSqlite, "file::memory:"); err != nil {
+		t.Fatal(err)
+	}
+	if err := orm.RegisterModel(&todo{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Enable(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	setup(t)
+	ctx := context.Background()
+
+	var fails atomic.Int32
+	fails.Store(1) // the first attempt fails
+	received := make(chan Payload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !Verify("s3cret", r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)) {
+			t.Error("bad signature")
+		}
+		if fails.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var payload Payload
+		_ = json.Unmarshal(body, &payload)
+		received <- payload
+	}))
+	defer server.Close()
+
+	subscriptions := []*Subscription{
+		{URL: server.URL, Secret: "s3cret", Events: "todo.created", Active: true},
+		{URL: server.URL, Secret: "s3cret", Events: "todo.deleted", Active: true},
+	}
+	for _, s := range subscriptions {
+		if err := orm.DB.Create(s).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rolled back: no message
+	_ = service.Transaction(ctx, func(ctx context.Context) error {
+		_ = service.Create(ctx, &todo{Title: "rollback"}, &enum.CreateOption{}, service.IfNotExist())
+		return context.Canceled
+	})
+	if err := service.Create(ctx, &todo{Title: "hello"}, &enum.CreateOption{}, service.IfNotExist()); err != nil {
+		t.Fatal(err)
+	}
+	var messages []Message
+	orm.DB.Find(&messages)
+	if len(messages) != 1 || messages[0].Topic != "todo.created" {
+		t.Fatalf("want 1 todo.created message, got %v", messages)
+	}
+
+	d := NewDispatcher(WithBackoff(func(int) time.Duration { return 0 }))
+	for i := 0; i < 2; i++ {
+		if _, err := d.Dispatch(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case payload := <-received:
+		if payload.Topic != "todo.created" {
+			t.Errorf("want topic todo.created, got %s", payload.Topic)
+		}
+	default:
+		t.Fatal("message not delivered")
+	}
+	var message Message
+	orm.DB.First(&message)
+	if message.Status != StatusDelivered || message.Attempts != 2 {
+		t.Errorf("want delivered in 2 attempts, got %s in %d", message.Status, message.Attempts)
+	}
+}
+
+func TestDead(t *testing.T) {
+	setup(t)
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	s := &Subscription{URL: server.URL, Active: true}
+	orm.DB.Create(s)
+	_ = service.Create(ctx, &todo{Title: "dead"}, &enum.CreateOption{}, service.IfNotExist())
+
+	d := NewDispatcher(WithMaxAttempts(2), WithBackoff(func(int) time.Duration { return 0 }))
+	for i := 0; i < 3; i++ {
+		_, _ = d.Dispatch(ctx)
+	}
+	var message Message
+	orm.DB.Where("subscription_id = ?", s.ID).First(&message)
+	if message.Status != StatusDead || message.Attempts != 2 {
+		t.Fatalf("want dead after 2 attempts, got %s after %d", message.Status, message.Attempts)
+	}
+
+	if n, err := Redeliver(ctx, s.ID); err != nil || n != 1 {
+		t.Fatalf("Redeliver: %v, %v", n, err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		events string
+		topic  string
+		want   bool
+	}{
+		{"", "Todo.created", true},
+		{"*", "Todo.created", true},
+		{"Todo.*", "Todo.updated", true},
+		{"*.deleted", "Todo.deleted", true},
+		{"Todo.created, User.*", "User.deleted", true},
+		{"Todo.created", "Todo.updated", false},
+		{"User.*", "Todo.created", false},
+	}
+	for _, tt := range tests {
+		s := Subscription{Events: tt.events}
+		if got := s.Matches(tt.topic); got != tt.want {
+			t.Errorf("%q.Matches(%q) = %v, want %v", tt.events, tt.topic, got, tt.want)
+		}
+	}
+}